@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// alignedTicker delivers ticks on the wall-clock boundaries of its interval,
+// e.g. every full minute for one minute. Every tick schedules the next one from
+// the clock, so ticks don't drift. Like time.Ticker, it drops ticks for slow
+// receivers.
+type alignedTicker struct {
+	C <-chan time.Time
+
+	c        chan time.Time
+	interval time.Duration
+	mu       sync.Mutex
+	timer    *time.Timer
+	next     time.Time
+	stopped  bool
+}
+
+func newAlignedTicker(interval time.Duration) *alignedTicker {
+	c := make(chan time.Time, 1)
+	t := &alignedTicker{C: c, c: c, interval: interval}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.schedule(time.Now())
+	return t
+}
+
+// nextBoundary returns the first boundary of interval after now. Boundaries are
+// multiples of interval since the zero time, which fall on full minutes, hours
+// and days in UTC.
+func nextBoundary(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
+
+// schedule arms the timer for the boundary after now, or after the previous
+// one if the clock is behind it, which would otherwise tick twice.
+func (t *alignedTicker) schedule(now time.Time) {
+	if now.Before(t.next) {
+		now = t.next
+	}
+	t.next = nextBoundary(now, t.interval)
+	t.timer = time.AfterFunc(time.Until(t.next), t.fire)
+}
+
+func (t *alignedTicker) fire() {
+	now := time.Now()
+	select {
+	case t.c <- now:
+	default:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.stopped {
+		t.schedule(now)
+	}
+}
+
+// Stop turns off the ticker. Like time.Ticker.Stop, it does not close C.
+func (t *alignedTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	t.timer.Stop()
+}
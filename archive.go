@@ -0,0 +1,107 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveOutput gzips the s5cmd output file of jobID into cfg.OutputArchiveDir
+// as <jobID>-<timestamp>.json.gz (or .stderr.json.gz) and removes the original.
+func archiveOutput(cfg *Config, jobID, outputFile string, now time.Time) error {
+	name := fmt.Sprintf("%s-%s%s.gz", jobID, now.UTC().Format("20060102T150405Z"), strings.TrimPrefix(filepath.Base(outputFile), jobID))
+	target := filepath.Join(cfg.OutputArchiveDir, name)
+	if err := os.MkdirAll(cfg.OutputArchiveDir, 0o755); err != nil {
+		return fmt.Errorf("error creating archive directory: %w", err)
+	}
+
+	src, err := os.Open(outputFile)
+	if err != nil {
+		return fmt.Errorf("error opening job result file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating archive %s: %w", target, err)
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(outputFile)
+	zw.ModTime = now
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return fmt.Errorf("error writing archive %s: %w", target, err)
+	}
+	return os.Remove(outputFile)
+}
+
+// archiveOutputs archives the output files of a job. Failures are logged, the
+// output files are then removed as usual.
+func archiveOutputs(cfg *Config, jobID string, outputFiles []string) {
+	now := time.Now()
+	for _, outputFile := range outputFiles {
+		if err := archiveOutput(cfg, jobID, outputFile, now); err != nil {
+			log.Printf("Error archiving s5cmd output of job %s: %v", jobID, err)
+		}
+	}
+}
+
+// openOutputFile opens an s5cmd output file for reading, decompressing it if
+// its name ends in .gz.
+func openOutputFile(outputFile string) (io.ReadCloser, error) {
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(outputFile, ".gz") {
+		return file, nil
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return gzipFile{Reader: zr, file: file}, nil
+}
+
+// gzipFile closes both the gzip reader and the file underneath it.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f gzipFile) Close() error {
+	return errors.Join(f.Reader.Close(), f.file.Close())
+}
+
+// inspectOutput runs the one-shot --inspect-output mode. It parses an s5cmd
+// output file, archived or not, and logs what the run reported without
+// deleting anything.
+func inspectOutput(cfg *Config) {
+	cfg.state, _ = loadStateStore("")
+
+	var summary Summary
+	var stats s5cmdStats
+	if err := parseOutputFile(cfg, cfg.InspectOutput, false, &summary, &stats); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s: %d files transferred (%d bytes), %d uploads failed, %d skipped as existing, %d source files vanished, %d malformed lines",
+		cfg.InspectOutput, summary.FilesTransferred, summary.TotalBytes, len(summary.UploadsFailed),
+		summary.SkippedExisting, summary.SourceVanished, summary.MalformedLines)
+	if len(summary.FailureReasons) > 0 {
+		log.Printf("Failure reasons: %s", formatFailureReasons(summary.FailureReasons, len(summary.FailureReasons)))
+	}
+}
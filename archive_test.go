@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchivedOutputParsesLikeTheOriginal(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.OutputArchiveDir = t.TempDir()
+	uploaded := writeSpoolFile(t, cfg, "uploaded.log", "abc")
+	failed := writeSpoolFile(t, cfg, "failed.log", "abc")
+	output := writeOutput(t, cpSuccess(uploaded, 3), cpFailure(failed, "connection refused"))
+
+	parsed, err := parseAndCleanup(cfg, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiveOutput(cfg, "job", output, time.Date(2026, 10, 16, 10, 15, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	assertGone(t, output)
+	archived := filepath.Join(cfg.OutputArchiveDir, "job-20261016T101500Z.json.gz")
+
+	var reparsed Summary
+	if err := parseOutputFile(cfg, archived, false, &reparsed, &s5cmdStats{}); err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.FilesTransferred != parsed.FilesTransferred || reparsed.TotalBytes != parsed.TotalBytes ||
+		len(reparsed.UploadsFailed) != len(parsed.UploadsFailed) || reparsed.MalformedLines != 0 {
+		t.Errorf("the archive parsed as %d transferred, %d bytes, %d failed, %d malformed, want %d, %d, %d, 0",
+			reparsed.FilesTransferred, reparsed.TotalBytes, len(reparsed.UploadsFailed), reparsed.MalformedLines,
+			parsed.FilesTransferred, parsed.TotalBytes, len(parsed.UploadsFailed))
+	}
+}
+
+func TestFailedRunOutputIsArchived(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.OutputArchiveDir = t.TempDir()
+	writeSpoolFile(t, cfg, "a.log", "abc")
+	cfg.S5cmdBinary = fakeS5cmd(t, `echo '{"operation":"cp","success":false,"error":"AccessDenied: access denied"}'`+"\nexit 1\n")
+
+	if _, err := runJob(context.Background(), cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), cfg.S3BucketPath); err == nil {
+		t.Fatal("the failed s5cmd run reported no error")
+	}
+	entries, err := os.ReadDir(cfg.OutputArchiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "job-") {
+		t.Fatalf("got archive entries %v, want the output of job", entries)
+	}
+	assertGone(t, "job.json")
+}
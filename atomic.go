@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// pendingDelete is a file of an atomic-delete run that may be deleted once all
+// invocations of the run are known to have succeeded.
+type pendingDelete struct {
+	path    string
+	size    int64
+	skipped bool // not uploaded because its object already exists
+}
+
+// applyAtomicDelete deletes the files of a run that were held back for
+// atomic-delete, or keeps all of them if any upload of the run failed. In
+// split-by-subdir mode the run covers every subdirectory, so it is only
+// decided once their summaries are merged.
+func applyAtomicDelete(cfg *Config, summary *Summary, runErr error) {
+	pending := summary.PendingDeletes
+	summary.PendingDeletes = nil
+	if len(pending) == 0 {
+		return
+	}
+
+	if runErr != nil || len(summary.UploadsFailed) > 0 {
+		summary.DeletesWithheld += len(pending)
+		log.Printf("Keeping %d transferred files for the next run, %d uploads of the run failed", len(pending), len(summary.UploadsFailed))
+		return
+	}
+	for _, p := range pending {
+		if !p.skipped {
+			deleteUploaded(cfg, p.path, p.size, summary)
+		} else if err := os.Remove(p.path); err != nil {
+			recordDeleteFailure(summary, p.path, err)
+		} else {
+			summary.SkippedDeleted++
+			cfg.state.forget(p.path)
+		}
+	}
+}
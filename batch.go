@@ -0,0 +1,37 @@
+package main
+
+import "time"
+
+// batchGate holds back runs until enough files are pending, so that the fixed
+// cost of a run is spread over more files. A batch that stays too small goes
+// ahead once its first deferral is maxWait old.
+type batchGate struct {
+	minFiles     int
+	maxWait      time.Duration // 0 waits for a full batch
+	waitingSince time.Time     // first deferral of the current batch, zero if none
+}
+
+func newBatchGate(minFiles int, maxWait time.Duration) *batchGate {
+	return &batchGate{minFiles: minFiles, maxWait: maxWait}
+}
+
+// admit reports whether a run with pending files should go ahead. Without
+// pending files there is nothing to wait for and no run is needed.
+func (g *batchGate) admit(pending int, now time.Time) bool {
+	if pending == 0 {
+		g.waitingSince = time.Time{}
+		return false
+	}
+	if pending >= g.minFiles {
+		g.waitingSince = time.Time{}
+		return true
+	}
+	if g.waitingSince.IsZero() {
+		g.waitingSince = now
+	}
+	if g.maxWait > 0 && now.Sub(g.waitingSince) >= g.maxWait {
+		g.waitingSince = time.Time{}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	binarySelectionRoundRobin = "round-robin"
+	binarySelectionRandom     = "random"
+)
+
+// binaryPicker selects one of several s5cmd binaries for every job.
+type binaryPicker struct {
+	binaries []string
+	tags     map[string]string // binary -> metric name element
+	random   bool
+	next     atomic.Uint64
+}
+
+// newBinaryPicker checks that every binary can be run and that their metric
+// names are unique.
+func newBinaryPicker(binaries []string, selection string) (*binaryPicker, error) {
+	p := &binaryPicker{binaries: binaries, tags: make(map[string]string), random: selection == binarySelectionRandom}
+	byTag := make(map[string]string)
+	for _, binary := range binaries {
+		if _, err := exec.LookPath(binary); err != nil {
+			return nil, fmt.Errorf("s5cmd binary %s: %w", binary, err)
+		}
+		tag := reasonSlug(filepath.Base(binary))
+		if other, ok := byTag[tag]; ok {
+			return nil, fmt.Errorf("s5cmd binaries %s and %s share the metric name %q, rename one of them", other, binary, tag)
+		}
+		byTag[tag] = binary
+		p.tags[binary] = tag
+	}
+	return p, nil
+}
+
+// pick returns the binary of the next job.
+func (p *binaryPicker) pick() string {
+	if p.random {
+		return p.binaries[rand.IntN(len(p.binaries))]
+	}
+	return p.binaries[(p.next.Add(1)-1)%uint64(len(p.binaries))]
+}
+
+// s5cmdBinary returns the binary run outside of jobs, e.g. for the manifest,
+// the first one if several are configured.
+func (cfg *Config) s5cmdBinary() string {
+	if cfg.binaries != nil {
+		return cfg.binaries.binaries[0]
+	}
+	return cfg.S5cmdBinary
+}
+
+// splitBinaries splits the comma-separated s5cmd-binary setting.
+func splitBinaries(spec string) []string {
+	var binaries []string
+	for _, binary := range strings.Split(spec, ",") {
+		if binary = strings.TrimSpace(binary); binary != "" {
+			binaries = append(binaries, binary)
+		}
+	}
+	return binaries
+}
+
+// addCounts adds the counts of other to counts, which is allocated if nil.
+func addCounts(counts map[string]int, other map[string]int) map[string]int {
+	for key, count := range other {
+		if counts == nil {
+			counts = make(map[string]int)
+		}
+		counts[key] += count
+	}
+	return counts
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errorClass classifies the cause of a failed s5cmd run.
+type errorClass string
+
+const (
+	errorClassNoMatch   errorClass = "nomatch"
+	errorClassAuth      errorClass = "auth"
+	errorClassThrottled errorClass = "throttled"
+	errorClassNetwork   errorClass = "network"
+	errorClassUnknown   errorClass = "unknown"
+
+	// errorClassVanished is not configurable, uploads of files removed since
+	// they were matched never fail a run
+	errorClassVanished errorClass = "vanished"
+)
+
+var errorClasses = []errorClass{errorClassNoMatch, errorClassAuth, errorClassThrottled, errorClassNetwork, errorClassUnknown}
+
+// errorPatterns lists substrings of s5cmd error messages per class. They are
+// matched case-insensitively in the order of errorPatternOrder.
+var errorPatterns = map[errorClass][]string{
+	errorClassNoMatch:  {"no match found for"},
+	errorClassVanished: {"no such file or directory", "cannot find the file"},
+	errorClassAuth: {
+		"accessdenied", "access denied", "invalidaccesskeyid", "signaturedoesnotmatch",
+		"expiredtoken", "invalidtoken", "nocredentialproviders", "status code: 403",
+	},
+	errorClassThrottled: {
+		"slowdown", "slow down", "toomanyrequests", "too many requests", "requestlimitexceeded",
+		"throttl", "status code: 429", "status code: 503",
+	},
+	errorClassNetwork: {
+		"connection refused", "connection reset", "no such host", "i/o timeout",
+		"timeout", "network is unreachable", "broken pipe", "requesterror", "unexpected eof",
+	},
+}
+
+var errorPatternOrder = []errorClass{errorClassNoMatch, errorClassVanished, errorClassAuth, errorClassThrottled, errorClassNetwork}
+
+// runError is the error of a failed run together with its class.
+type runError struct {
+	class      errorClass
+	retryAfter time.Duration // delay the endpoint asked for, 0 without a hint
+	err        error
+}
+
+func (e *runError) Error() string { return e.err.Error() }
+func (e *runError) Unwrap() error { return e.err }
+
+// classOf returns the class of err, errorClassUnknown if it wasn't classified.
+func classOf(err error) errorClass {
+	var re *runError
+	if errors.As(err, &re) {
+		return re.class
+	}
+	return errorClassUnknown
+}
+
+// retryAfterOf returns the delay the endpoint asked for before err is retried,
+// 0 if it gave none.
+func retryAfterOf(err error) time.Duration {
+	var re *runError
+	if errors.As(err, &re) {
+		return re.retryAfter
+	}
+	return 0
+}
+
+// retryAfterPattern finds a Retry-After hint, in seconds, in an error message.
+var retryAfterPattern = regexp.MustCompile(`(?i)retry[- ]after\W{0,3}(\d+)`)
+
+// retryAfterHint returns the longest Retry-After hint in the error records of
+// the output file, 0 if there is none.
+func retryAfterHint(cfg *Config, outputFile string) time.Duration {
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	var hint time.Duration
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var result JobResult
+		if err := decodeResult(cfg, scanner.Bytes(), &result); err != nil || result.Error == "" {
+			continue
+		}
+		if match := retryAfterPattern.FindStringSubmatch(result.Error); match != nil {
+			if seconds, err := strconv.Atoi(match[1]); err == nil {
+				hint = max(hint, time.Duration(seconds)*time.Second)
+			}
+		}
+	}
+	return hint
+}
+
+// classifyMessage returns the class of a single s5cmd error message.
+func classifyMessage(message string) errorClass {
+	message = strings.ToLower(message)
+	for _, class := range errorPatternOrder {
+		for _, pattern := range errorPatterns[class] {
+			if strings.Contains(message, pattern) {
+				return class
+			}
+		}
+	}
+	return errorClassUnknown
+}
+
+// sourceVanished reports whether result is a failed upload of a file that was
+// removed after it was matched, which is no failure as the file is gone anyway.
+func sourceVanished(cfg *Config, result *JobResult) bool {
+	if result.Operation != "cp" || result.Success || classifyMessage(result.Error) != errorClassVanished {
+		return false
+	}
+	source, ok := failedSource(cfg, result)
+	if !ok {
+		return false
+	}
+	_, err := os.Lstat(source)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// classifyOutput classifies a failed run from the error records in its output
+// file. Authentication errors win over throttling, which wins over network
+// errors, which win over errors that aren't recognized. A run whose only
+// errors are uploads of vanished files is errorClassVanished.
+func classifyOutput(cfg *Config, outputFile string) errorClass {
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return errorClassUnknown
+	}
+	defer file.Close()
+
+	found := make(map[errorClass]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var result JobResult
+		if err := decodeResult(cfg, scanner.Bytes(), &result); err != nil || result.Error == "" {
+			continue
+		}
+		class := classifyMessage(result.Error)
+		if class == errorClassVanished && !sourceVanished(cfg, &result) {
+			class = errorClassUnknown
+		}
+		found[class] = true
+	}
+
+	for _, class := range []errorClass{errorClassAuth, errorClassThrottled, errorClassNetwork, errorClassNoMatch} {
+		if found[class] {
+			return class
+		}
+	}
+	if found[errorClassVanished] && !found[errorClassUnknown] {
+		return errorClassVanished
+	}
+	return errorClassUnknown
+}
+
+// parseErrorClasses parses a comma-separated list of error classes.
+func parseErrorClasses(spec string) (map[errorClass]bool, error) {
+	classes := make(map[errorClass]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, class := range errorClasses {
+			if errorClass(name) == class {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown error class %q, expected one of %v", name, errorClasses)
+		}
+		classes[errorClass(name)] = true
+	}
+	return classes, nil
+}
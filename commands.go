@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// perFileMode reports whether uploads need a destination computed per file, in
+// which case runs enumerate the files themselves and pass s5cmd a commands file
+// instead of a wildcard.
+func (cfg *Config) perFileMode() bool {
+	return cfg.SanitizeKeys || cfg.StableCopy || len(cfg.routes) > 0 || cfg.SkipEmptyFiles || cfg.ShuffleOrder ||
+		cfg.MaxAgeDelete > 0 || cfg.MaxDeleteFailures > 0 || cfg.AsyncDelete || !cfg.IncludeHidden || cfg.StripPrefix != "" ||
+		cfg.PipelineUploads || cfg.AutoContentType || cfg.MaxKeyLength > 0 || cfg.ShortfallPercent > 0 || cfg.KeepLargerThan > 0 ||
+		len(cfg.excludedDirs) > 0 || cfg.InstancePrefix || cfg.allowedUIDs != nil || cfg.allowedGIDs != nil
+}
+
+// stripKeyPrefix removes cfg.StripPrefix from key. Keys not starting with it
+// are returned unchanged and false.
+func stripKeyPrefix(cfg *Config, key string) (string, bool) {
+	if cfg.StripPrefix == "" {
+		return key, true
+	}
+	stripped, ok := strings.CutPrefix(key, cfg.StripPrefix)
+	if !ok || stripped == "" {
+		return key, false
+	}
+	return stripped, true
+}
+
+// instanceKey prepends the instance ID to key if instance-prefix is set, so
+// that hosts uploading same-named files to one prefix don't collide.
+func instanceKey(cfg *Config, key string) string {
+	if !cfg.InstancePrefix {
+		return key
+	}
+	return cfg.InstanceID + "/" + key
+}
+
+// joinDestination appends key to the destination prefix base.
+func joinDestination(base, key string) string {
+	return strings.TrimSuffix(base, "/") + "/" + key
+}
+
+// quoteArg quotes s for the s5cmd commands file using POSIX shell quoting.
+func quoteArg(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// planUploads enumerates the files matching srcPath and writes one s5cmd cp
+// command per file to commandsFile. With stable copies enabled, the commands
+// upload a snapshot of each file taken in the job's work directory. It returns
+// the number of commands written and a Summary holding the counters collected
+// while planning.
+//
+// Files are streamed from the enumeration into the commands file, so memory
+// stays bounded by a hash per file rather than the file list. Destinations
+// found to collide are removed from the file once enumeration is complete.
+func planUploads(cfg *Config, jobID, srcPath, commandsFile string) (int, Summary, error) {
+	file, err := os.Create(commandsFile)
+	if err != nil {
+		return 0, Summary{}, fmt.Errorf("error creating commands file: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	lines, seen, planned, err := writeUploads(cfg, jobID, srcPath, writer)
+	if err != nil {
+		return 0, planned, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, planned, fmt.Errorf("error writing commands file: %w", err)
+	}
+
+	if planned.KeyCollisions == 0 {
+		return len(lines), planned, nil
+	}
+	commands, err := dropCollidingCommands(file, lines, seen)
+	if err != nil {
+		return 0, planned, fmt.Errorf("error writing commands file: %w", err)
+	}
+	return commands, planned, nil
+}
+
+// writeUploads enumerates the files matching srcPath and writes one s5cmd cp
+// command per file to w as they are found. It returns the destination hash of
+// every command written, whether each destination collides with another one,
+// and the counters collected while planning. Commands of colliding
+// destinations found after the first one was written are left in w.
+func writeUploads(cfg *Config, jobID, srcPath string, w io.Writer) ([]uint64, map[uint64]bool, Summary, error) {
+	var planned Summary
+
+	var options strings.Builder
+	for _, option := range cpOptions(cfg) {
+		options.WriteString(quoteArg(option) + " ")
+	}
+
+	seen := make(map[uint64]bool) // destination hash -> whether it collides
+	var lines []uint64            // destination hash of every line written
+	sizes := make(map[uint64]int64)
+	unstripped := 0
+	now := time.Now()
+	foreign, err := walkCandidates(cfg, srcPath, func(c candidate) error {
+		dest, stripped, skip := planFile(cfg, c, now)
+		if !stripped {
+			unstripped++
+		}
+		switch skip {
+		case skipQueued, skipKept:
+			return nil
+		case skipExpired:
+			if expireFile(cfg, c, now) {
+				planned.FilesExpired++
+			}
+			return nil
+		case skipStuck:
+			planned.FilesStuck++
+			return nil
+		case skipEmpty:
+			skipEmptyFile(cfg, c.Path)
+			planned.EmptyFilesSkipped++
+			return nil
+		case skipKeyTooLong:
+			if quarantineLongKey(cfg, c.Path, len(objectKey(dest))) {
+				planned.FilesDeadLettered++
+			}
+			planned.KeysTooLong++
+			return nil
+		}
+
+		// Two files must never be uploaded to the same key, one would overwrite the
+		// other. Leave all of them in place until they are renamed.
+		h := destinationHash(dest)
+		if colliding, ok := seen[h]; ok {
+			log.Printf("Error: %s maps to %s like another file, skipping them", c.Path, dest)
+			if !colliding {
+				seen[h] = true
+				planned.KeyCollisions++
+				planned.BytesPlanned -= sizes[h]
+			}
+			planned.KeyCollisions++
+			return nil
+		}
+		seen[h] = false
+
+		path := c.Path
+		if cfg.StableCopy {
+			stable, err := snapshot(cfg, jobID, c.Path)
+			if err != nil {
+				// The file stays in place and is retried next run.
+				log.Printf("Error: %v", err)
+				return nil
+			}
+			path = stable
+		}
+		fileOptions := options.String()
+		if cfg.AutoContentType {
+			for _, option := range contentOptions(cfg, path) {
+				fileOptions += quoteArg(option) + " "
+			}
+		}
+		lines = append(lines, h)
+		sizes[h] = c.Size
+		planned.BytesPlanned += c.Size
+		_, err := fmt.Fprintf(w, "cp %s%s %s\n", fileOptions, quoteArg(path), quoteArg(dest))
+		return err
+	})
+	planned.OwnerFiltered = foreign
+	if unstripped > 0 {
+		log.Printf("Warning: %d files of job %s don't start with strip-prefix %q, they keep their full key", unstripped, jobID, cfg.StripPrefix)
+	}
+	return lines, seen, planned, err
+}
+
+// planSkip is the reason a candidate is not uploaded, planUpload if it is.
+type planSkip int
+
+const (
+	planUpload     planSkip = iota
+	skipQueued              // uploaded already, only waiting to be deleted
+	skipKept                // uploaded already and kept on purpose
+	skipExpired             // past max-age-delete, deleted without upload
+	skipStuck               // failed to delete in max-delete-failures runs in a row
+	skipEmpty               // empty and skip-empty-files is set
+	skipKeyTooLong          // the backend would fail the upload, and with it the run
+)
+
+// planFile decides whether the candidate c is uploaded and returns its
+// destination. stripped is false if its key doesn't start with strip-prefix.
+// It has no side effects, so that counting the pending files applies the same
+// rules as planning a run.
+func planFile(cfg *Config, c candidate, now time.Time) (dest string, stripped bool, skip planSkip) {
+	switch {
+	case cfg.deleteQueue != nil && cfg.deleteQueue.pending(c.Path):
+		return "", true, skipQueued
+	case cfg.KeepLargerThan > 0 && cfg.state.kept(c.Path, c.ModTime):
+		return "", true, skipKept
+	case cfg.MaxAgeDelete > 0 && now.Sub(c.ModTime) > cfg.MaxAgeDelete:
+		return "", true, skipExpired
+	case cfg.MaxDeleteFailures > 0 && cfg.state.deleteFailures(c.Path, c.ModTime) >= cfg.MaxDeleteFailures:
+		return "", true, skipStuck
+	case c.Size == 0 && cfg.SkipEmptyFiles:
+		return "", true, skipEmpty
+	}
+
+	key, stripped := stripKeyPrefix(cfg, c.Key)
+	routeKey := key
+	if cfg.SanitizeKeys {
+		key = cfg.keySanitizer.sanitize(key)
+	}
+	key = instanceKey(cfg, key)
+	dest = joinDestination(cfg.destinationFor(routeKey), key)
+
+	if cfg.MaxKeyLength > 0 && len(objectKey(dest)) > cfg.MaxKeyLength {
+		return dest, stripped, skipKeyTooLong
+	}
+	return dest, stripped, planUpload
+}
+
+// destinationHash returns the FNV-1a hash of dest. Two destinations sharing a
+// hash are treated as colliding, which at worst leaves both files in place.
+func destinationHash(dest string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(dest))
+	return h.Sum64()
+}
+
+// dropCollidingCommands rewrites the commands file without the lines whose
+// destination collides, given the destination hash of each line, and returns
+// the number of lines kept.
+func dropCollidingCommands(file *os.File, lines []uint64, colliding map[uint64]bool) (int, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var kept bytes.Buffer
+	reader := bufio.NewReader(file)
+	commands := 0
+	for _, h := range lines {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return 0, err
+		}
+		if !colliding[h] {
+			kept.Write(line)
+			commands++
+		}
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteAt(kept.Bytes(), 0); err != nil {
+		return 0, err
+	}
+	return commands, nil
+}
+
+// objectKey returns the object key of the s3:// URL dest, without the bucket.
+func objectKey(dest string) string {
+	_, key, _ := strings.Cut(strings.TrimPrefix(dest, "s3://"), "/")
+	return key
+}
+
+// quarantineLongKey moves a file whose object key is longer than
+// cfg.MaxKeyLength to the dead-letter directory if one is set, and otherwise
+// leaves it in place. It reports whether the file was moved.
+func quarantineLongKey(cfg *Config, path string, length int) bool {
+	if cfg.DeadLetterDir == "" {
+		log.Printf("Error: the object key of %s is %d bytes long, more than max-key-length %d, skipping it", path, length, cfg.MaxKeyLength)
+		return false
+	}
+	target, err := relocate(path, cfg.FolderPrefix, cfg.DeadLetterDir)
+	if err != nil {
+		log.Printf("Error moving %s with an object key of %d bytes to the dead-letter directory: %v", path, length, err)
+		return false
+	}
+	log.Printf("Moved %s to %s, its object key is %d bytes long, more than max-key-length %d", path, target, length, cfg.MaxKeyLength)
+	return true
+}
+
+// skipEmptyFile applies cfg.EmptyFileAction to a zero-byte file that is not
+// uploaded.
+func skipEmptyFile(cfg *Config, path string) {
+	if cfg.EmptyFileAction != emptyFileDelete {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error deleting empty file %s: %v", path, err)
+		return
+	}
+	cfg.state.forget(path)
+}
+
+// expireFile deletes a file past max-age-delete without uploading it and
+// reports whether it was deleted. This destroys data, so every file is logged.
+func expireFile(cfg *Config, c candidate, now time.Time) bool {
+	age := now.Sub(c.ModTime).Round(time.Second)
+	if err := os.Remove(c.Path); err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("EXPIRY: error deleting %s, %v old, without upload: %v", c.Path, age, err)
+		}
+		return false
+	}
+	log.Printf("EXPIRY: deleted %s without upload, last modified %v ago", c.Path, age)
+	cfg.state.forget(c.Path)
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog redirects the log output to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestWriteUploadsExpiresOnlyOldFiles(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.MaxAgeDelete = time.Hour
+	expired := writeSpoolFile(t, cfg, "expired.log", "abc")
+	fresh := writeSpoolFile(t, cfg, "fresh.log", "abc")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+	logged := captureLog(t)
+
+	var commands bytes.Buffer
+	_, _, planned, err := writeUploads(cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), &commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGone(t, expired)
+	assertExists(t, fresh)
+	if planned.FilesExpired != 1 {
+		t.Errorf("got %d files expired, want 1", planned.FilesExpired)
+	}
+	if !strings.Contains(logged.String(), "EXPIRY: deleted "+expired) {
+		t.Errorf("the expired file was not logged, log:\n%s", logged)
+	}
+	if strings.Contains(logged.String(), fresh) {
+		t.Errorf("the fresh file was logged, log:\n%s", logged)
+	}
+	if !strings.Contains(commands.String(), quoteArg(fresh)) || strings.Contains(commands.String(), quoteArg(expired)) {
+		t.Errorf("got commands\n%swant an upload of the fresh file only", commands.String())
+	}
+}
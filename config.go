@@ -0,0 +1,1057 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the resolved configuration of a s5-commander process.
+type Config struct {
+	// operational settings
+	FolderPrefix        string
+	PathSuffix          string
+	ProcessInterval     time.Duration
+	RunOnStart          bool
+	AlignInterval       bool
+	Once                bool
+	OutputJSON          bool
+	MinBatchFiles       int
+	MaxBatchWait        time.Duration
+	SkipEmptyRuns       bool
+	NetdataEnabled      bool
+	NetdataAddress      string
+	NetdataProbeTimeout time.Duration
+	NetdataMaxDatagram  int
+	NetdataRedeliver    bool
+	MetricDialTimeout   time.Duration
+	MetricsQueueSize    int
+	MetricFlushInterval time.Duration
+	MetricsWarmup       time.Duration
+	ThroughputWindow    int
+	MaxAccumulatedRuns  int
+	DegradedPercent     int
+	PrometheusListen    string
+	PrometheusTextfile  string
+	InfluxDBURL         string
+	InfluxDBToken       string
+	InstanceID          string
+	S5cmdBinary         string
+	BinarySelection     string
+	SplitBySubdir       bool
+	ShuffleOrder        bool
+	ShuffleSeed         int64
+	PipelineUploads     bool
+	SubdirConcurrency   int
+	ConcurrencyRamp     bool
+	InterBatchDelay     time.Duration
+	InterBatchMaxDelay  time.Duration
+	SeparateStderr      bool
+	OutputArchiveDir    string
+	MaxS5cmdProcesses   int
+	SpawnRateLimit      int
+	MaxOpenDirs         int
+	Nice                int
+	IONice              string
+	Verbose             bool
+	LogConfig           bool
+	LogIdleSummaries    bool
+	RuntimeMetrics      bool
+	ProgressInterval    time.Duration
+	DeterministicJobID  bool
+	JSONFieldMap        string
+	MaxMalformedLines   int
+	CrossCheckStats     bool
+	ShortfallPercent    int
+	MaxRuntime          time.Duration
+	DrainOnShutdown     bool
+	ShutdownTimeout     time.Duration
+	SecondSignal        string
+	ShutdownReport      string
+	ReadyFile           string
+	ManifestPrefix      string
+	HeartbeatObject     string
+	FailedLogSample     int
+	StateFile           string
+	StateCompaction     time.Duration
+	MaxUploadAttempts   int
+	MaxDeleteFailures   int
+	DeadLetterDir       string
+	ReplayDir           string
+	InspectOutput       string
+	Restore             string
+	MinFreeInodes       uint64
+	MaxLoadAverage      float64
+	MountSentinel       string
+	MountLossGrace      time.Duration
+	ExitOnMountLoss     bool
+	AtomicDelete        bool
+	MinSuccessRatio     float64
+	FailFast            bool
+	AsyncDelete         bool
+	DeleteQueueSize     int
+	MaxRetries          int
+	RetryBudget         int
+	RetryBackoff        time.Duration
+	ThrottleBackoff     time.Duration
+	RetryableErrors     string
+	CoordinationLock    string
+	CoordinationSlots   int
+	CoordinationWait    time.Duration
+
+	// s5cmd cp tuning settings
+	MultipartSize         int64 // bytes, 0 uses the s5cmd default
+	SoftMemoryLimit       int64 // bytes, 0 keeps the Go runtime default
+	GCPercent             int   // 0 keeps the Go runtime default, -1 collects only near the soft memory limit
+	MultipartConcurrency  int   // 0 uses the s5cmd default
+	ObjectTags            string
+	ObjectLockMode        string
+	ObjectLockRetainUntil string
+	NoOverwrite           bool
+	DeleteSkipped         bool
+
+	// per-file destination settings
+	SkipEmptyFiles       bool
+	KeepLargerThan       int64 // bytes, 0 deletes every uploaded file
+	IncludeHidden        bool
+	AllowedUIDs          string
+	AllowedGIDs          string
+	MaxAgeDelete         time.Duration
+	EnableExpiry         bool
+	EmptyFileAction      string
+	SanitizeKeys         bool
+	StripPrefix          string
+	MaxKeyLength         int
+	InstancePrefix       bool
+	SanitizeAllowedChars string
+	SanitizeReplacement  string
+	StableCopy           bool
+	AutoContentType      bool
+	GzipEncoding         bool
+	Routes               []string
+	WorkDir              string
+
+	// s3-like storage settings
+	S3BucketPath     string
+	S3BucketPathFile string
+	AwsCredsFile     string
+	StrictCredsPerms bool
+	AwsEndpointURL   string
+	VerifyWrite      bool
+	CACert           string
+	ClientCert       string
+	ClientKey        string
+	AwsProfile       string
+	CredsCommand     string
+	CredsTTL         time.Duration
+	HasAwsEnvCreds   bool
+
+	fieldMapping    fieldMapping
+	retryableErrors map[errorClass]bool
+	keySanitizer    *keySanitizer
+	routes          []route
+	objectTags      []objectTag
+	excludedDirs    []string // directories under folder-prefix left out of enumeration
+	allowedUIDs     []uint32 // nil unless allowed-uid is set
+	allowedGIDs     []uint32 // nil unless allowed-gid is set
+	state           *stateStore
+	s5cmdSlots      chan struct{} // bounds concurrent s5cmd processes, nil if unlimited
+	shuffler        *shuffler     // nil unless shuffle-order is enabled
+	deleteQueue     *deleteQueue  // nil unless async-delete is enabled
+	stableLinks     *stableLinks  // nil unless stable-copy is enabled
+	retryBudget     *retryBudget  // nil unless retry-budget is set
+	batchGate       *batchGate    // nil unless min-batch-files is set
+	spawnLimiter    *spawnLimiter // nil unless spawn-rate-limit is set
+	openDirs        *dirLimiter   // nil unless max-open-dirs is set
+	binaries        *binaryPicker // nil unless s5cmd-binary lists several binaries
+	credsSource     *credsSource  // nil unless creds-command is set
+	redactor        *redactor     // masks secrets in the log, nil until main sets it
+	jobs            *jobTracker   // running jobs for a forced exit, nil until main sets it
+	ioPriority      *ioPriority   // nil unless ionice is set
+	progress        *runProgress  // nil unless progress-interval is set
+
+	// runtime state
+	inodePressure bool
+	loadThrottled bool
+	spoolMissing  time.Time // since when the spool is missing, zero while present
+	mountLost     bool
+	heartbeatDown bool // the last heartbeat failed to write
+}
+
+// loadConfig parses the command line flags and resolves every value against its
+// environment variable, which takes precedence when set.
+func loadConfig() *Config {
+	// operational flags
+	folderPrefix := flag.String("folder-prefix", "/tmp/", "Folder prefix for files to be offloaded (env: FOLDER_PREFIX)")
+	pathSuffix := flag.String("path-suffix", "/**/**/*.gz", "the path suffix to use for glob matching (env: PATH_SUFFIX)")
+	processInterval := flag.Duration("process-interval", 1*time.Second, "The interval between processing runs (env: PROCESS_INTERVAL)")
+	runOnStart := flag.Bool("run-on-start", false, "Run once right after startup instead of waiting for the first interval (env: RUN_ON_START)")
+	alignInterval := flag.Bool("align-interval", false, "Run on the wall-clock boundaries of process-interval, e.g. every full minute for 1m, instead of relative to startup (env: ALIGN_INTERVAL)")
+	once := flag.Bool("once", false, "Run once and exit instead of running every process-interval (env: ONCE)")
+	outputJSON := flag.Bool("output-json", false, "With once, print the summary of the run as JSON to stdout, logs go to stderr (env: OUTPUT_JSON)")
+	minBatchFiles := flag.Int("min-batch-files", 0, "Skip runs until at least this many files are pending, 0 runs on every tick (env: MIN_BATCH_FILES)")
+	maxBatchWait := flag.Duration("max-batch-wait", 0, "Run anyway once files have waited this long for min-batch-files, 0 waits for a full batch (env: MAX_BATCH_WAIT)")
+	skipEmptyRuns := flag.Bool("skip-empty-runs", false, "Enumerate the spool before each run and skip s5cmd when no files match (env: SKIP_EMPTY_RUNS)")
+	netdataEnabled := flag.Bool("netdata-enabled", false, "Enable sending metrics to Netdata (env: NETDATA_ENABLED)")
+	netdataAddress := flag.String("netdata-address", "127.0.0.1:8125", "Netdata statsd address (UDP) (env: NETDATA_ADDRESS)")
+	netdataRedeliver := flag.Bool("netdata-redeliver", false, "Keep metrics that failed to reach Netdata and send them with the next batch, counters summed and gauges at their latest value (env: NETDATA_REDELIVER)")
+	netdataMaxDatagram := flag.Int("netdata-max-datagram", 1432, "Maximum size in bytes of the datagrams metrics are packed into, 0 sends every metric on its own (env: NETDATA_MAX_DATAGRAM)")
+	netdataProbeTimeout := flag.Duration("netdata-probe-timeout", 1*time.Second, "Timeout of the Netdata reachability check at startup (env: NETDATA_PROBE_TIMEOUT)")
+	metricDialTimeout := flag.Duration("metric-dial-timeout", 2*time.Second, "Timeout for resolving and connecting to metric sinks (env: METRIC_DIAL_TIMEOUT)")
+	metricsQueueSize := flag.Int("metrics-queue-size", 64, "Metric batches queued for sending in the background, 0 sends synchronously (env: METRICS_QUEUE_SIZE)")
+	metricFlushInterval := flag.Duration("metric-flush-interval", 0, "Aggregate Netdata metrics and send them once per interval instead of after every run, 0 sends after every run (env: METRIC_FLUSH_INTERVAL)")
+	metricsWarmup := flag.Duration("metrics-warmup", 0, "Do not send the metrics of runs completing within this long after startup (env: METRICS_WARMUP)")
+	throughputWindow := flag.Int("throughput-window", 0, "Number of recent runs with transfers over which throughput and duration percentiles are reported, 0 disables (env: THROUGHPUT_WINDOW)")
+	maxAccumulatedRuns := flag.Int("max-accumulated-runs", 0, "Flush the summary window after this many runs even if a minute hasn't passed, 0 flushes every minute only (env: MAX_ACCUMULATED_RUNS)")
+	throughputDegradedPercent := flag.Int("throughput-degraded-percent", 0, "Warn when a run's throughput falls below this percentage of the median of the throughput window, 0 disables (env: THROUGHPUT_DEGRADED_PERCENT)")
+	prometheusListen := flag.String("prometheus-listen", "", "Address serving Prometheus metrics on /metrics, e.g. :9410 (env: PROMETHEUS_LISTEN)")
+	prometheusTextfile := flag.String("prometheus-textfile", "", "File the Prometheus metrics are written to after every run, for the node_exporter textfile collector (env: PROMETHEUS_TEXTFILE)")
+	influxDBURL := flag.String("influxdb-url", "", "InfluxDB write endpoint receiving line protocol, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b (env: INFLUXDB_URL)")
+	influxDBToken := flag.String("influxdb-token", "", "Token sent to InfluxDB (env: INFLUXDB_TOKEN)")
+	instanceID := flag.String("instance-id", "", "Identity of this instance in metrics, manifests and reports, defaults to the hostname (env: INSTANCE_ID)")
+	s5cmdBinary := flag.String("s5cmd-binary", "s5cmd", "Full path to s5cmd binary, or comma-separated paths of several binaries jobs alternate between (env: S5CMD_BINARY)")
+	binarySelection := flag.String("s5cmd-binary-selection", binarySelectionRoundRobin, "How jobs choose among several s5cmd binaries: round-robin or random (env: S5CMD_BINARY_SELECTION)")
+	shuffleOrder := flag.Bool("shuffle-order", false, "Randomize the order in which subdirectories and files are handed to s5cmd (env: SHUFFLE_ORDER)")
+	pipelineUploads := flag.Bool("pipeline-uploads", false, "Stream the files to s5cmd while the spool is enumerated instead of after it (env: PIPELINE_UPLOADS)")
+	shuffleSeed := flag.Int64("shuffle-seed", 0, "Seed of shuffle-order for a reproducible order, 0 seeds from the clock (env: SHUFFLE_SEED)")
+	splitBySubdir := flag.Bool("split-by-subdir", false, "Run one s5cmd per top-level subdirectory of the folder prefix (env: SPLIT_BY_SUBDIR)")
+	subdirConcurrency := flag.Int("subdir-concurrency", 4, "Maximum number of concurrent s5cmd invocations in split-by-subdir mode (env: SUBDIR_CONCURRENCY)")
+	concurrencyRamp := flag.Bool("concurrency-ramp", false, "Start split-by-subdir runs with one s5cmd and add more up to subdir-concurrency as they succeed (env: CONCURRENCY_RAMP)")
+	interBatchDelay := flag.Duration("inter-batch-delay", 0, "Pause between starting the s5cmd invocations of a split-by-subdir run (env: INTER_BATCH_DELAY)")
+	interBatchMaxDelay := flag.Duration("inter-batch-max-delay", 0, "Double inter-batch-delay up to this after a throttled invocation, 0 keeps it fixed (env: INTER_BATCH_MAX_DELAY)")
+	logConfig := flag.Bool("log-config", false, "Log the effective value and source of every setting at startup, with secrets redacted (env: LOG_CONFIG)")
+	runtimeMetrics := flag.Bool("runtime-metrics", false, "Report goroutines, heap in use and GC pauses of the program once per summary window (env: RUNTIME_METRICS)")
+	progressInterval := flag.Duration("progress-interval", 0, "Log and report the progress of a running run this often, 0 disables (env: PROGRESS_INTERVAL)")
+	logIdleSummaries := flag.Bool("log-idle-summaries", false, "Log a short summary every summary interval even if no files were transferred (env: LOG_IDLE_SUMMARIES)")
+	deterministicJobID := flag.Bool("deterministic-job-id", false, "Derive job IDs from the pending files and the process interval of the run instead of choosing them at random (env: DETERMINISTIC_JOB_ID)")
+	verbose := flag.Bool("verbose", false, "Log every s5cmd command line, with credentials redacted (env: VERBOSE)")
+	maxOpenDirs := flag.Int("max-open-dirs", 0, "Maximum number of directories held open at once while enumerating the spool, 0 is unlimited (env: MAX_OPEN_DIRS)")
+	spawnRateLimit := flag.Int("spawn-rate-limit", 0, "Maximum number of s5cmd processes started per second, 0 is unlimited (env: SPAWN_RATE_LIMIT)")
+	maxS5cmdProcesses := flag.Int("max-s5cmd-processes", 0, "Maximum number of s5cmd processes running at once, 0 is unlimited (env: MAX_S5CMD_PROCESSES)")
+	nice := flag.Int("nice", 0, "Niceness s5cmd runs with, from -20 to 19, Linux only (env: NICE)")
+	ioNice := flag.String("ionice", "", "I/O scheduling class[:level] s5cmd runs with, e.g. idle or best-effort:7, Linux only (env: IONICE)")
+	separateStderr := flag.Bool("separate-stderr", false, "Write s5cmd stderr to a separate file instead of the JSON output file (env: SEPARATE_STDERR)")
+	outputArchiveDir := flag.String("output-archive-dir", "", "Directory the s5cmd output of every job is gzipped into after parsing instead of being deleted (env: OUTPUT_ARCHIVE_DIR)")
+	maxRuntime := flag.Duration("max-runtime", 0, "Shut down gracefully after running for this long, 0 runs forever (env: MAX_RUNTIME)")
+	drainOnShutdown := flag.Bool("drain-on-shutdown", false, "On shutdown, keep running until no matching files are left (env: DRAIN_ON_SHUTDOWN)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 0, "Maximum time spent draining the backlog on shutdown, 0 is unlimited (env: SHUTDOWN_TIMEOUT)")
+	secondSignal := flag.String("second-signal", secondSignalExit, "What a second signal during shutdown does: exit right away, or abort-drain and leave exiting to a third one (env: SECOND_SIGNAL)")
+	failedLogSample := flag.Int("failed-log-sample", 10, "Maximum number of failed files listed in logs and the shutdown report, 0 lists none (env: FAILED_LOG_SAMPLE)")
+	heartbeatObject := flag.String("heartbeat-object", "", "Object, relative to s3-bucket-path unless an s3:// URL, overwritten with a timestamp after every run as a liveness marker (env: HEARTBEAT_OBJECT)")
+	manifestPrefix := flag.String("manifest-prefix", "", "Prefix, relative to s3-bucket-path unless an s3:// URL, receiving a JSON manifest of the files uploaded by every run (env: MANIFEST_PREFIX)")
+	readyFile := flag.String("ready-file", "", "Create this file once startup succeeded and remove it on shutdown (env: READY_FILE)")
+	shutdownReport := flag.String("shutdown-report", "", "Write a JSON report of the session to this file on shutdown (env: SHUTDOWN_REPORT)")
+	crossCheckStats := flag.Bool("cross-check-stats", false, "Run s5cmd with --stat and compare its totals with the parsed per-file records (env: CROSS_CHECK_STATS)")
+	bytesShortfallPercent := flag.Int("bytes-shortfall-percent", 0, "Warn when a run transfers more than this percentage fewer bytes than the files it enumerated, 0 disables (env: BYTES_SHORTFALL_PERCENT)")
+	maxMalformedLines := flag.Int("max-malformed-lines", 0, "Mark a run as suspect when more lines of the s5cmd output cannot be parsed, 0 disables (env: MAX_MALFORMED_LINES)")
+	jsonFieldMap := flag.String("json-field-map", "", "Comma-separated field=path overrides for reading s5cmd JSON output, e.g. source=key (env: JSON_FIELD_MAP)")
+	stateCompaction := flag.Duration("state-compact-interval", time.Hour, "How often the state of files that no longer exist is dropped, 0 disables (env: STATE_COMPACT_INTERVAL)")
+	stateFile := flag.String("state-file", "", "File persisting per-file state across restarts, kept in memory only if empty (env: STATE_FILE)")
+	maxUploadAttempts := flag.Int("max-upload-attempts", 0, "Failed upload attempts after which a file is moved to the dead-letter directory, 0 disables (env: MAX_UPLOAD_ATTEMPTS)")
+	maxDeleteFailures := flag.Int("max-delete-failures", 0, "Runs in a row a file may upload but fail to delete before it is moved to the dead-letter directory or no longer uploaded, 0 disables (env: MAX_DELETE_FAILURES)")
+	deadLetterDir := flag.String("dead-letter-dir", "", "Directory receiving files that repeatedly failed to upload (env: DEAD_LETTER_DIR)")
+	restore := flag.String("restore", "", "Download the objects matching this pattern, relative to s3-bucket-path, into folder-prefix and exit (env: RESTORE)")
+	replayDir := flag.String("replay-dir", "", "Move the files of this directory, e.g. the dead-letter directory, back into folder-prefix and exit (env: REPLAY_DIR)")
+	inspectOutput := flag.String("inspect-output", "", "Parse this s5cmd output file, gzipped or not, log what it reports and exit without deleting anything (env: INSPECT_OUTPUT)")
+
+	atomicDelete := flag.Bool("atomic-delete", false, "Delete no files of a run if any upload of the run failed (env: ATOMIC_DELETE)")
+	minSuccessRatio := flag.Float64("min-success-ratio", 0, "Delete no files of a run in which less than this fraction of the uploads succeeded, 0 disables (env: MIN_SUCCESS_RATIO)")
+	failFast := flag.Bool("fail-fast", false, "Stop s5cmd at the first failed upload instead of uploading the rest of the run (env: FAIL_FAST)")
+	asyncDelete := flag.Bool("async-delete", false, "Delete transferred files in the background while the next run starts (env: ASYNC_DELETE)")
+	deleteQueueSize := flag.Int("delete-queue-size", 4096, "Files queued for deletion in async-delete mode before runs wait for the queue (env: DELETE_QUEUE_SIZE)")
+	mountSentinel := flag.String("mount-sentinel", "", "File relative to folder-prefix that only exists while the spool is mounted, checked before every run (env: MOUNT_SENTINEL)")
+	mountLossGrace := flag.Duration("mount-loss-grace", time.Minute, "How long the spool may be missing before it is reported as lost (env: MOUNT_LOSS_GRACE)")
+	exitOnMountLoss := flag.Bool("exit-on-mount-loss", false, "Shut down with a non-zero exit code once the spool is lost (env: EXIT_ON_MOUNT_LOSS)")
+	maxLoadAverage := flag.Float64("max-load-average", 0, "Skip runs while the 1-minute load average is above this, 0 disables (env: MAX_LOAD_AVERAGE, Linux only)")
+	minFreeInodes := flag.Uint64("min-free-inodes", 0, "Warn when fewer inodes are free on the folder prefix filesystem, 0 disables (env: MIN_FREE_INODES, Linux only)")
+
+	maxRetries := flag.Int("max-retries", 0, "Times a failed run is retried right away if its error is retryable (env: MAX_RETRIES)")
+	retryBudget := flag.Int("retry-budget", 0, "Retries allowed across all runs of a summary window, 0 is unlimited (env: RETRY_BUDGET)")
+	retryBackoff := flag.Duration("retry-backoff", 1*time.Second, "Delay before the first retry, doubled for every further retry (env: RETRY_BACKOFF)")
+	throttleBackoff := flag.Duration("throttle-backoff", 10*time.Second, "Delay before the first retry of a throttled run, doubled for every further retry, unless the endpoint asks for longer (env: THROTTLE_BACKOFF)")
+	retryableErrors := flag.String("retryable-errors", "throttled,network,unknown", "Comma-separated error classes that are retried: nomatch, auth, throttled, network, unknown (env: RETRYABLE_ERRORS)")
+
+	coordinationLock := flag.String("coordination-lock", "", "Directory of lock files limiting concurrent runs across cooperating processes (env: COORDINATION_LOCK)")
+	coordinationSlots := flag.Int("coordination-slots", 1, "Number of runs allowed at once across processes sharing the coordination lock (env: COORDINATION_SLOTS)")
+	coordinationWait := flag.Duration("coordination-wait", 1*time.Minute, "How long a run waits for a coordination token before it is skipped (env: COORDINATION_WAIT)")
+
+	// s5cmd cp tuning flags
+	multipartSize := flag.String("multipart-size", "", "Multipart part size passed to s5cmd, e.g. 64MiB (env: MULTIPART_SIZE)")
+	softMemoryLimit := flag.String("soft-memory-limit", "", "Soft limit of the memory of s5-commander itself, e.g. 256MiB, the garbage collector works harder near it (env: SOFT_MEMORY_LIMIT)")
+	gcPercent := flag.Int("gc-percent", 0, "Garbage collection target percentage like GOGC, 0 keeps the default, -1 collects only near soft-memory-limit (env: GC_PERCENT)")
+	multipartConcurrency := flag.Int("multipart-concurrency", 0, "Number of parts uploaded concurrently per file by s5cmd (env: MULTIPART_CONCURRENCY)")
+	noOverwrite := flag.Bool("no-overwrite", false, "Never overwrite existing objects, passing --no-clobber to s5cmd (env: NO_OVERWRITE)")
+	deleteSkipped := flag.Bool("delete-skipped", true, "Delete files skipped by no-overwrite because their object exists, as if they were uploaded (env: DELETE_SKIPPED)")
+	objectLockMode := flag.String("object-lock-mode", "", "Object lock retention mode of uploaded objects: GOVERNANCE or COMPLIANCE (env: OBJECT_LOCK_MODE)")
+	objectLockRetainUntil := flag.String("object-lock-retain-until", "", "Date until which uploaded objects are locked, e.g. 2030-01-31 or 2030-01-31T00:00:00Z (env: OBJECT_LOCK_RETAIN_UNTIL)")
+	objectTags := flag.String("object-tags", "", "Comma-separated key=value tags set on every uploaded object (env: OBJECT_TAGS)")
+
+	// per-file destination flags
+	maxAgeDelete := flag.Duration("max-age-delete", 0, "Delete files older than this without uploading them, requires enable-expiry; 0 disables (env: MAX_AGE_DELETE)")
+	enableExpiry := flag.Bool("enable-expiry", false, "Confirm that max-age-delete may delete files without uploading them (env: ENABLE_EXPIRY)")
+	skipEmptyFiles := flag.Bool("skip-empty-files", false, "Do not upload zero-byte files (env: SKIP_EMPTY_FILES)")
+	includeHidden := flag.Bool("include-hidden", true, "Upload hidden files and the files of hidden directories, whose names start with '.' (env: INCLUDE_HIDDEN)")
+	allowedUIDs := flag.String("allowed-uid", "", "Comma-separated user IDs; only files owned by one of them are uploaded (env: ALLOWED_UID)")
+	allowedGIDs := flag.String("allowed-gid", "", "Comma-separated group IDs; only files whose group is one of them are uploaded (env: ALLOWED_GID)")
+	keepLargerThan := flag.String("keep-larger-than", "", "Keep uploaded files larger than this locally instead of deleting them, e.g. 1GiB (env: KEEP_LARGER_THAN)")
+	emptyFileAction := flag.String("empty-file-action", emptyFileLeave, "What happens to skipped empty files: leave or delete (env: EMPTY_FILE_ACTION)")
+	stripPrefix := flag.String("strip-prefix", "", "Leading directories removed from the object keys, e.g. spool/ (env: STRIP_PREFIX)")
+	instancePrefix := flag.Bool("instance-prefix", false, "Prepend the instance-id as the first segment of every object key (env: INSTANCE_PREFIX)")
+	maxKeyLength := flag.Int("max-key-length", 0, "Skip files whose object key would be longer than this many bytes, moving them to dead-letter-dir if set; 0 disables (env: MAX_KEY_LENGTH)")
+	sanitizeKeys := flag.Bool("sanitize-keys", false, "Lowercase object keys and replace disallowed characters (env: SANITIZE_KEYS)")
+	sanitizeAllowedChars := flag.String("sanitize-allowed-chars", "a-z0-9._-", "Regexp character class of characters kept by sanitize-keys (env: SANITIZE_ALLOWED_CHARS)")
+	var routes stringList
+	flag.Var(&routes, "route", "Route files whose object key matches a regexp to another destination, as pattern=destination; repeatable, first match wins (env: ROUTES, ';'-separated)")
+	autoContentType := flag.Bool("auto-content-type", false, "Set the Content-Type of every object from the extension or content of its file (env: AUTO_CONTENT_TYPE)")
+	gzipEncoding := flag.Bool("gzip-content-encoding", false, "With auto-content-type, upload .gz files with Content-Encoding gzip and the type of the compressed file (env: GZIP_CONTENT_ENCODING)")
+	stableCopy := flag.Bool("stable-copy", false, "Upload hardlinked or copied snapshots of the files instead of the files themselves (env: STABLE_COPY)")
+	workDir := flag.String("work-dir", filepath.Join(os.TempDir(), "s5-commander"), "Directory for temporary working files such as stable copies (env: WORK_DIR)")
+	sanitizeReplacement := flag.String("sanitize-replacement", "_", "Replacement for characters removed by sanitize-keys (env: SANITIZE_REPLACEMENT)")
+
+	// s3-like storage flags
+	s3BucketPath := flag.String("s3-bucket-path", "", "S3 bucket path (e.g., s3://my-bucket/path/) (env: S3_BUCKET_PATH)")
+	s3BucketPathFile := flag.String("s3-bucket-path-file", "", "File holding the S3 bucket path, read before every run instead of s3-bucket-path (env: S3_BUCKET_PATH_FILE)")
+	awsCredsFile := flag.String("aws-creds-file", "", "Path to AWS credentials file (env: AWS_CREDS_FILE)")
+	strictCredsPerms := flag.Bool("strict-creds-perms", false, "Refuse to start if the AWS credentials file is readable by group or others (env: STRICT_CREDS_PERMS)")
+	awsEndpointURL := flag.String("aws-endpoint-url", "", "Custom AWS endpoint (env: AWS_ENDPOINT_URL)")
+	verifyWrite := flag.Bool("verify-write-access", false, "Upload and delete a probe object under s3-bucket-path at startup and exit if either is denied (env: VERIFY_WRITE_ACCESS)")
+	caCert := flag.String("ca-cert", "", "PEM file of the CA certificates trusted for the endpoint (env: CA_CERT)")
+	clientCert := flag.String("client-cert", "", "PEM file of the client certificate for mutual TLS with the endpoint (env: CLIENT_CERT)")
+	clientKey := flag.String("client-key", "", "PEM file of the key of client-cert (env: CLIENT_KEY)")
+	awsProfile := flag.String("aws-profile", "default", "AWS profile to use from credentials file (env: AWS_PROFILE)")
+	credsCommand := flag.String("creds-command", "", "Command printing AWS credentials as JSON in the credential_process format, used instead of the credentials file (env: CREDS_COMMAND)")
+	credsTTL := flag.Duration("creds-ttl", 15*time.Minute, "Run creds-command again after this long, or earlier if the credentials expire (env: CREDS_TTL)")
+
+	flag.Parse()
+
+	multipartSizeBytes, err := parseSize(getEnvOrFlag("MULTIPART_SIZE", *multipartSize))
+	if err != nil {
+		log.Fatalf("Invalid multipart-size (or MULTIPART_SIZE env var): %v", err)
+	}
+	keepLargerThanBytes, err := parseSize(getEnvOrFlag("KEEP_LARGER_THAN", *keepLargerThan))
+	if err != nil {
+		log.Fatalf("Invalid keep-larger-than (or KEEP_LARGER_THAN env var): %v", err)
+	}
+	softMemoryLimitBytes, err := parseSize(getEnvOrFlag("SOFT_MEMORY_LIMIT", *softMemoryLimit))
+	if err != nil {
+		log.Fatalf("Invalid soft-memory-limit (or SOFT_MEMORY_LIMIT env var): %v", err)
+	}
+
+	cfg := &Config{
+		FolderPrefix:        getEnvOrFlag("FOLDER_PREFIX", *folderPrefix),
+		PathSuffix:          getEnvOrFlag("PATH_SUFFIX", *pathSuffix),
+		ProcessInterval:     getEnvOrFlagDuration("PROCESS_INTERVAL", *processInterval),
+		RunOnStart:          getEnvOrFlagBool("RUN_ON_START", *runOnStart),
+		AlignInterval:       getEnvOrFlagBool("ALIGN_INTERVAL", *alignInterval),
+		Once:                getEnvOrFlagBool("ONCE", *once),
+		OutputJSON:          getEnvOrFlagBool("OUTPUT_JSON", *outputJSON),
+		MinBatchFiles:       getEnvOrFlagInt("MIN_BATCH_FILES", *minBatchFiles),
+		MaxBatchWait:        getEnvOrFlagDuration("MAX_BATCH_WAIT", *maxBatchWait),
+		SkipEmptyRuns:       getEnvOrFlagBool("SKIP_EMPTY_RUNS", *skipEmptyRuns),
+		NetdataEnabled:      getEnvOrFlagBool("NETDATA_ENABLED", *netdataEnabled),
+		NetdataAddress:      getEnvOrFlag("NETDATA_ADDRESS", *netdataAddress),
+		NetdataProbeTimeout: getEnvOrFlagDuration("NETDATA_PROBE_TIMEOUT", *netdataProbeTimeout),
+		NetdataMaxDatagram:  getEnvOrFlagInt("NETDATA_MAX_DATAGRAM", *netdataMaxDatagram),
+		NetdataRedeliver:    getEnvOrFlagBool("NETDATA_REDELIVER", *netdataRedeliver),
+		MetricDialTimeout:   getEnvOrFlagDuration("METRIC_DIAL_TIMEOUT", *metricDialTimeout),
+		MetricsQueueSize:    getEnvOrFlagInt("METRICS_QUEUE_SIZE", *metricsQueueSize),
+		MetricFlushInterval: getEnvOrFlagDuration("METRIC_FLUSH_INTERVAL", *metricFlushInterval),
+		MetricsWarmup:       getEnvOrFlagDuration("METRICS_WARMUP", *metricsWarmup),
+		ThroughputWindow:    getEnvOrFlagInt("THROUGHPUT_WINDOW", *throughputWindow),
+		MaxAccumulatedRuns:  getEnvOrFlagInt("MAX_ACCUMULATED_RUNS", *maxAccumulatedRuns),
+		DegradedPercent:     getEnvOrFlagInt("THROUGHPUT_DEGRADED_PERCENT", *throughputDegradedPercent),
+		PrometheusListen:    getEnvOrFlag("PROMETHEUS_LISTEN", *prometheusListen),
+		PrometheusTextfile:  getEnvOrFlag("PROMETHEUS_TEXTFILE", *prometheusTextfile),
+		InfluxDBURL:         getEnvOrFlag("INFLUXDB_URL", *influxDBURL),
+		InfluxDBToken:       getEnvOrFlag("INFLUXDB_TOKEN", *influxDBToken),
+		InstanceID:          getEnvOrFlag("INSTANCE_ID", *instanceID),
+		S5cmdBinary:         getEnvOrFlag("S5CMD_BINARY", *s5cmdBinary),
+		BinarySelection:     getEnvOrFlag("S5CMD_BINARY_SELECTION", *binarySelection),
+		SplitBySubdir:       getEnvOrFlagBool("SPLIT_BY_SUBDIR", *splitBySubdir),
+		ShuffleOrder:        getEnvOrFlagBool("SHUFFLE_ORDER", *shuffleOrder),
+		ShuffleSeed:         int64(getEnvOrFlagInt("SHUFFLE_SEED", int(*shuffleSeed))),
+		PipelineUploads:     getEnvOrFlagBool("PIPELINE_UPLOADS", *pipelineUploads),
+		SubdirConcurrency:   getEnvOrFlagInt("SUBDIR_CONCURRENCY", *subdirConcurrency),
+		ConcurrencyRamp:     getEnvOrFlagBool("CONCURRENCY_RAMP", *concurrencyRamp),
+		InterBatchDelay:     getEnvOrFlagDuration("INTER_BATCH_DELAY", *interBatchDelay),
+		InterBatchMaxDelay:  getEnvOrFlagDuration("INTER_BATCH_MAX_DELAY", *interBatchMaxDelay),
+		SeparateStderr:      getEnvOrFlagBool("SEPARATE_STDERR", *separateStderr),
+		OutputArchiveDir:    getEnvOrFlag("OUTPUT_ARCHIVE_DIR", *outputArchiveDir),
+		MaxS5cmdProcesses:   getEnvOrFlagInt("MAX_S5CMD_PROCESSES", *maxS5cmdProcesses),
+		SpawnRateLimit:      getEnvOrFlagInt("SPAWN_RATE_LIMIT", *spawnRateLimit),
+		MaxOpenDirs:         getEnvOrFlagInt("MAX_OPEN_DIRS", *maxOpenDirs),
+		Nice:                getEnvOrFlagInt("NICE", *nice),
+		IONice:              getEnvOrFlag("IONICE", *ioNice),
+		Verbose:             getEnvOrFlagBool("VERBOSE", *verbose),
+		LogConfig:           getEnvOrFlagBool("LOG_CONFIG", *logConfig),
+		RuntimeMetrics:      getEnvOrFlagBool("RUNTIME_METRICS", *runtimeMetrics),
+		ProgressInterval:    getEnvOrFlagDuration("PROGRESS_INTERVAL", *progressInterval),
+		LogIdleSummaries:    getEnvOrFlagBool("LOG_IDLE_SUMMARIES", *logIdleSummaries),
+		DeterministicJobID:  getEnvOrFlagBool("DETERMINISTIC_JOB_ID", *deterministicJobID),
+		MaxRuntime:          getEnvOrFlagDuration("MAX_RUNTIME", *maxRuntime),
+		DrainOnShutdown:     getEnvOrFlagBool("DRAIN_ON_SHUTDOWN", *drainOnShutdown),
+		ShutdownTimeout:     getEnvOrFlagDuration("SHUTDOWN_TIMEOUT", *shutdownTimeout),
+		SecondSignal:        getEnvOrFlag("SECOND_SIGNAL", *secondSignal),
+		ShutdownReport:      getEnvOrFlag("SHUTDOWN_REPORT", *shutdownReport),
+		ReadyFile:           getEnvOrFlag("READY_FILE", *readyFile),
+		ManifestPrefix:      getEnvOrFlag("MANIFEST_PREFIX", *manifestPrefix),
+		HeartbeatObject:     getEnvOrFlag("HEARTBEAT_OBJECT", *heartbeatObject),
+		FailedLogSample:     getEnvOrFlagInt("FAILED_LOG_SAMPLE", *failedLogSample),
+		JSONFieldMap:        getEnvOrFlag("JSON_FIELD_MAP", *jsonFieldMap),
+		MaxMalformedLines:   getEnvOrFlagInt("MAX_MALFORMED_LINES", *maxMalformedLines),
+		CrossCheckStats:     getEnvOrFlagBool("CROSS_CHECK_STATS", *crossCheckStats),
+		ShortfallPercent:    getEnvOrFlagInt("BYTES_SHORTFALL_PERCENT", *bytesShortfallPercent),
+		StateFile:           getEnvOrFlag("STATE_FILE", *stateFile),
+		StateCompaction:     getEnvOrFlagDuration("STATE_COMPACT_INTERVAL", *stateCompaction),
+		MaxUploadAttempts:   getEnvOrFlagInt("MAX_UPLOAD_ATTEMPTS", *maxUploadAttempts),
+		DeadLetterDir:       getEnvOrFlag("DEAD_LETTER_DIR", *deadLetterDir),
+		MaxDeleteFailures:   getEnvOrFlagInt("MAX_DELETE_FAILURES", *maxDeleteFailures),
+		ReplayDir:           getEnvOrFlag("REPLAY_DIR", *replayDir),
+		InspectOutput:       getEnvOrFlag("INSPECT_OUTPUT", *inspectOutput),
+		Restore:             getEnvOrFlag("RESTORE", *restore),
+		MaxRetries:          getEnvOrFlagInt("MAX_RETRIES", *maxRetries),
+		RetryBudget:         getEnvOrFlagInt("RETRY_BUDGET", *retryBudget),
+		RetryBackoff:        getEnvOrFlagDuration("RETRY_BACKOFF", *retryBackoff),
+		ThrottleBackoff:     getEnvOrFlagDuration("THROTTLE_BACKOFF", *throttleBackoff),
+		RetryableErrors:     getEnvOrFlag("RETRYABLE_ERRORS", *retryableErrors),
+		CoordinationLock:    getEnvOrFlag("COORDINATION_LOCK", *coordinationLock),
+		CoordinationSlots:   getEnvOrFlagInt("COORDINATION_SLOTS", *coordinationSlots),
+		CoordinationWait:    getEnvOrFlagDuration("COORDINATION_WAIT", *coordinationWait),
+		AtomicDelete:        getEnvOrFlagBool("ATOMIC_DELETE", *atomicDelete),
+		MinSuccessRatio:     getEnvOrFlagFloat("MIN_SUCCESS_RATIO", *minSuccessRatio),
+		FailFast:            getEnvOrFlagBool("FAIL_FAST", *failFast),
+		AsyncDelete:         getEnvOrFlagBool("ASYNC_DELETE", *asyncDelete),
+		DeleteQueueSize:     getEnvOrFlagInt("DELETE_QUEUE_SIZE", *deleteQueueSize),
+		MinFreeInodes:       getEnvOrFlagUint64("MIN_FREE_INODES", *minFreeInodes),
+		MaxLoadAverage:      getEnvOrFlagFloat("MAX_LOAD_AVERAGE", *maxLoadAverage),
+		MountSentinel:       getEnvOrFlag("MOUNT_SENTINEL", *mountSentinel),
+		MountLossGrace:      getEnvOrFlagDuration("MOUNT_LOSS_GRACE", *mountLossGrace),
+		ExitOnMountLoss:     getEnvOrFlagBool("EXIT_ON_MOUNT_LOSS", *exitOnMountLoss),
+
+		MultipartSize:         multipartSizeBytes,
+		SoftMemoryLimit:       softMemoryLimitBytes,
+		GCPercent:             getEnvOrFlagInt("GC_PERCENT", *gcPercent),
+		MultipartConcurrency:  getEnvOrFlagInt("MULTIPART_CONCURRENCY", *multipartConcurrency),
+		ObjectTags:            getEnvOrFlag("OBJECT_TAGS", *objectTags),
+		ObjectLockMode:        getEnvOrFlag("OBJECT_LOCK_MODE", *objectLockMode),
+		ObjectLockRetainUntil: getEnvOrFlag("OBJECT_LOCK_RETAIN_UNTIL", *objectLockRetainUntil),
+		NoOverwrite:           getEnvOrFlagBool("NO_OVERWRITE", *noOverwrite),
+		DeleteSkipped:         getEnvOrFlagBool("DELETE_SKIPPED", *deleteSkipped),
+
+		SkipEmptyFiles:       getEnvOrFlagBool("SKIP_EMPTY_FILES", *skipEmptyFiles),
+		KeepLargerThan:       keepLargerThanBytes,
+		IncludeHidden:        getEnvOrFlagBool("INCLUDE_HIDDEN", *includeHidden),
+		AllowedUIDs:          getEnvOrFlag("ALLOWED_UID", *allowedUIDs),
+		AllowedGIDs:          getEnvOrFlag("ALLOWED_GID", *allowedGIDs),
+		MaxAgeDelete:         getEnvOrFlagAge("MAX_AGE_DELETE", *maxAgeDelete),
+		EnableExpiry:         getEnvOrFlagBool("ENABLE_EXPIRY", *enableExpiry),
+		EmptyFileAction:      getEnvOrFlag("EMPTY_FILE_ACTION", *emptyFileAction),
+		SanitizeKeys:         getEnvOrFlagBool("SANITIZE_KEYS", *sanitizeKeys),
+		StripPrefix:          getEnvOrFlag("STRIP_PREFIX", *stripPrefix),
+		MaxKeyLength:         getEnvOrFlagInt("MAX_KEY_LENGTH", *maxKeyLength),
+		InstancePrefix:       getEnvOrFlagBool("INSTANCE_PREFIX", *instancePrefix),
+		SanitizeAllowedChars: getEnvOrFlag("SANITIZE_ALLOWED_CHARS", *sanitizeAllowedChars),
+		SanitizeReplacement:  getEnvOrFlag("SANITIZE_REPLACEMENT", *sanitizeReplacement),
+		Routes:               getEnvOrFlagList("ROUTES", routes),
+		StableCopy:           getEnvOrFlagBool("STABLE_COPY", *stableCopy),
+		AutoContentType:      getEnvOrFlagBool("AUTO_CONTENT_TYPE", *autoContentType),
+		GzipEncoding:         getEnvOrFlagBool("GZIP_CONTENT_ENCODING", *gzipEncoding),
+		WorkDir:              getEnvOrFlag("WORK_DIR", *workDir),
+
+		// If AWS endpoint, creds file, profile, or S3 bucket path are set via env vars, override flags
+		AwsEndpointURL:   getEnvOrFlag("AWS_ENDPOINT_URL", *awsEndpointURL),
+		VerifyWrite:      getEnvOrFlagBool("VERIFY_WRITE_ACCESS", *verifyWrite),
+		CACert:           getEnvOrFlag("CA_CERT", *caCert),
+		ClientCert:       getEnvOrFlag("CLIENT_CERT", *clientCert),
+		ClientKey:        getEnvOrFlag("CLIENT_KEY", *clientKey),
+		AwsCredsFile:     getEnvOrFlag("AWS_CREDS_FILE", *awsCredsFile),
+		StrictCredsPerms: getEnvOrFlagBool("STRICT_CREDS_PERMS", *strictCredsPerms),
+		AwsProfile:       getEnvOrFlag("AWS_PROFILE", *awsProfile),
+		CredsCommand:     getEnvOrFlag("CREDS_COMMAND", *credsCommand),
+		CredsTTL:         getEnvOrFlagDuration("CREDS_TTL", *credsTTL),
+		S3BucketPath:     getEnvOrFlag("S3_BUCKET_PATH", *s3BucketPath),
+		S3BucketPathFile: getEnvOrFlag("S3_BUCKET_PATH_FILE", *s3BucketPathFile),
+	}
+
+	// Check for AWS credentials in environment variables
+	cfg.HasAwsEnvCreds = os.Getenv("AWS_ACCESS_KEY_ID") != "" &&
+		os.Getenv("AWS_SECRET_ACCESS_KEY") != "" &&
+		os.Getenv("AWS_DEFAULT_REGION") != ""
+	return cfg
+}
+
+// validate checks the configuration for missing or conflicting settings and
+// prepares the settings derived from it.
+func (cfg *Config) validate() error {
+	if cfg.S3BucketPath == "" && cfg.S3BucketPathFile == "" {
+		return errors.New("s3-bucket-path (or S3_BUCKET_PATH env var) is required")
+	}
+	if cfg.S3BucketPath != "" && cfg.S3BucketPathFile != "" {
+		return errors.New("s3-bucket-path and s3-bucket-path-file (or S3_BUCKET_PATH and S3_BUCKET_PATH_FILE env vars) are mutually exclusive")
+	}
+	if cfg.S3BucketPath != "" && cfg.needsTrailingSlash(cfg.S3BucketPath) {
+		log.Printf("Warning: s3-bucket-path %q has no trailing slash, treating it as the prefix %q", cfg.S3BucketPath, cfg.S3BucketPath+"/")
+		cfg.S3BucketPath += "/"
+	}
+
+	if cfg.CredsCommand != "" {
+		if cfg.CredsTTL <= 0 {
+			return errors.New("creds-ttl (or CREDS_TTL env var) must be positive")
+		}
+		cfg.credsSource = newCredsSource(cfg.CredsCommand, cfg.CredsTTL, cfg.redactor)
+	} else if cfg.AwsCredsFile == "" && !cfg.HasAwsEnvCreds {
+		return errors.New("Either aws-creds-file (or AWS_CREDS_FILE env var), creds-command (or CREDS_COMMAND env var) or AWS environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_DEFAULT_REGION) are required")
+	}
+
+	if err := checkTLSFiles(cfg); err != nil {
+		return err
+	}
+
+	if cfg.SplitBySubdir {
+		if cfg.SubdirConcurrency < 1 {
+			return errors.New("subdir-concurrency (or SUBDIR_CONCURRENCY env var) must be at least 1")
+		}
+		if _, err := subdirPattern(cfg.PathSuffix, "x"); err != nil {
+			return fmt.Errorf("split-by-subdir cannot be used with path-suffix %q: %w", cfg.PathSuffix, err)
+		}
+	}
+	if cfg.ConcurrencyRamp && !cfg.SplitBySubdir {
+		return errors.New("concurrency-ramp (or CONCURRENCY_RAMP env var) requires split-by-subdir")
+	}
+	if cfg.InterBatchDelay < 0 {
+		return errors.New("inter-batch-delay (or INTER_BATCH_DELAY env var) must not be negative")
+	}
+	if cfg.InterBatchDelay > 0 && !cfg.SplitBySubdir {
+		return errors.New("inter-batch-delay (or INTER_BATCH_DELAY env var) requires split-by-subdir")
+	}
+	if cfg.InterBatchMaxDelay != 0 && cfg.InterBatchMaxDelay < cfg.InterBatchDelay {
+		return errors.New("inter-batch-max-delay (or INTER_BATCH_MAX_DELAY env var) must not be less than inter-batch-delay")
+	}
+	if cfg.InterBatchMaxDelay > 0 && cfg.InterBatchDelay == 0 {
+		return errors.New("inter-batch-max-delay (or INTER_BATCH_MAX_DELAY env var) requires inter-batch-delay")
+	}
+
+	if cfg.BinarySelection != binarySelectionRoundRobin && cfg.BinarySelection != binarySelectionRandom {
+		return fmt.Errorf("s5cmd-binary-selection (or S5CMD_BINARY_SELECTION env var) must be %s or %s", binarySelectionRoundRobin, binarySelectionRandom)
+	}
+	if binaries := splitBinaries(cfg.S5cmdBinary); len(binaries) > 1 {
+		picker, err := newBinaryPicker(binaries, cfg.BinarySelection)
+		if err != nil {
+			return fmt.Errorf("invalid s5cmd-binary (or S5CMD_BINARY env var): %w", err)
+		}
+		cfg.binaries = picker
+	}
+	if cfg.MaxS5cmdProcesses < 0 {
+		return errors.New("max-s5cmd-processes (or MAX_S5CMD_PROCESSES env var) must not be negative")
+	}
+	if cfg.MaxS5cmdProcesses > 0 {
+		cfg.s5cmdSlots = make(chan struct{}, cfg.MaxS5cmdProcesses)
+	}
+	if cfg.MaxOpenDirs < 0 {
+		return errors.New("max-open-dirs (or MAX_OPEN_DIRS env var) must not be negative")
+	}
+	if cfg.MaxOpenDirs > 0 {
+		cfg.openDirs = newDirLimiter(cfg.MaxOpenDirs)
+	}
+	if cfg.NetdataMaxDatagram < 0 {
+		return errors.New("netdata-max-datagram (or NETDATA_MAX_DATAGRAM env var) must not be negative")
+	}
+	if cfg.MountLossGrace < 0 {
+		return errors.New("mount-loss-grace (or MOUNT_LOSS_GRACE env var) must not be negative")
+	}
+	if cfg.MaxLoadAverage < 0 {
+		return errors.New("max-load-average (or MAX_LOAD_AVERAGE env var) must not be negative")
+	}
+	if cfg.MinSuccessRatio < 0 || cfg.MinSuccessRatio > 1 {
+		return errors.New("min-success-ratio (or MIN_SUCCESS_RATIO env var) must be between 0 and 1")
+	}
+	if cfg.SpawnRateLimit < 0 {
+		return errors.New("spawn-rate-limit (or SPAWN_RATE_LIMIT env var) must not be negative")
+	}
+	if cfg.SpawnRateLimit > 0 {
+		cfg.spawnLimiter = newSpawnLimiter(cfg.SpawnRateLimit)
+	}
+
+	if cfg.Nice < -20 || cfg.Nice > 19 {
+		return errors.New("nice (or NICE env var) must be between -20 and 19")
+	}
+	if cfg.IONice != "" {
+		prio, err := parseIONice(cfg.IONice)
+		if err != nil {
+			return fmt.Errorf("invalid ionice (or IONICE env var): %w", err)
+		}
+		cfg.ioPriority = &prio
+	}
+	if err := checkPriority(cfg); err != nil {
+		return err
+	}
+
+	if cfg.MetricDialTimeout <= 0 {
+		return errors.New("metric-dial-timeout (or METRIC_DIAL_TIMEOUT env var) must be positive")
+	}
+
+	if cfg.ShuffleOrder {
+		cfg.shuffler = newShuffler(cfg.ShuffleSeed)
+	}
+
+	if cfg.MetricsQueueSize < 0 {
+		return errors.New("metrics-queue-size (or METRICS_QUEUE_SIZE env var) must not be negative")
+	}
+	if cfg.MetricFlushInterval < 0 {
+		return errors.New("metric-flush-interval (or METRIC_FLUSH_INTERVAL env var) must not be negative")
+	}
+	if cfg.MetricsWarmup < 0 {
+		return errors.New("metrics-warmup (or METRICS_WARMUP env var) must not be negative")
+	}
+
+	if cfg.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("instance-id (or INSTANCE_ID env var) is not set and the hostname is unknown: %w", err)
+		}
+		cfg.InstanceID = hostname
+	}
+	// The id ends up unquoted in statsd tags and InfluxDB line protocol
+	if !instanceIDPattern.MatchString(cfg.InstanceID) {
+		return fmt.Errorf("instance-id (or INSTANCE_ID env var) %q may only contain letters, digits, '.', '_' and '-'", cfg.InstanceID)
+	}
+
+	if cfg.OutputJSON && !cfg.Once {
+		return errors.New("output-json (or OUTPUT_JSON env var) requires once")
+	}
+
+	if cfg.MinBatchFiles < 0 {
+		return errors.New("min-batch-files (or MIN_BATCH_FILES env var) must not be negative")
+	}
+	if cfg.MaxBatchWait < 0 {
+		return errors.New("max-batch-wait (or MAX_BATCH_WAIT env var) must not be negative")
+	}
+	if cfg.MaxBatchWait > 0 && cfg.MinBatchFiles == 0 {
+		return errors.New("max-batch-wait (or MAX_BATCH_WAIT env var) requires min-batch-files")
+	}
+	if cfg.MinBatchFiles > 0 {
+		cfg.batchGate = newBatchGate(cfg.MinBatchFiles, cfg.MaxBatchWait)
+	}
+
+	if cfg.MaxAccumulatedRuns < 0 {
+		return errors.New("max-accumulated-runs (or MAX_ACCUMULATED_RUNS env var) must not be negative")
+	}
+
+	if cfg.ThroughputWindow < 0 {
+		return errors.New("throughput-window (or THROUGHPUT_WINDOW env var) must not be negative")
+	}
+	if cfg.ShortfallPercent < 0 || cfg.ShortfallPercent > 100 {
+		return errors.New("bytes-shortfall-percent (or BYTES_SHORTFALL_PERCENT env var) must be between 0 and 100")
+	}
+	if cfg.DegradedPercent < 0 || cfg.DegradedPercent >= 100 {
+		return errors.New("throughput-degraded-percent (or THROUGHPUT_DEGRADED_PERCENT env var) must be between 0 and 99")
+	}
+	if cfg.DegradedPercent > 0 && cfg.ThroughputWindow == 0 {
+		return errors.New("throughput-degraded-percent (or THROUGHPUT_DEGRADED_PERCENT env var) requires throughput-window, its median is the baseline")
+	}
+
+	if cfg.InfluxDBURL != "" {
+		if u, err := url.Parse(cfg.InfluxDBURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("influxdb-url (or INFLUXDB_URL env var) must be an http or https URL, got %q", cfg.InfluxDBURL)
+		}
+	}
+
+	if cfg.MaxMalformedLines < 0 {
+		return errors.New("max-malformed-lines (or MAX_MALFORMED_LINES env var) must not be negative")
+	}
+
+	if cfg.FailedLogSample < 0 {
+		return errors.New("failed-log-sample (or FAILED_LOG_SAMPLE env var) must not be negative")
+	}
+
+	if cfg.MaxRuntime < 0 {
+		return errors.New("max-runtime (or MAX_RUNTIME env var) must not be negative")
+	}
+	if cfg.ProgressInterval < 0 {
+		return errors.New("progress-interval (or PROGRESS_INTERVAL env var) must not be negative")
+	}
+	if cfg.ShutdownTimeout < 0 {
+		return errors.New("shutdown-timeout (or SHUTDOWN_TIMEOUT env var) must not be negative")
+	}
+	if cfg.SecondSignal != secondSignalExit && cfg.SecondSignal != secondSignalAbortDrain {
+		return fmt.Errorf("second-signal (or SECOND_SIGNAL env var) must be %s or %s, got %q", secondSignalExit, secondSignalAbortDrain, cfg.SecondSignal)
+	}
+
+	if cfg.JSONFieldMap != "" {
+		mapping, err := parseFieldMapping(cfg.JSONFieldMap)
+		if err != nil {
+			return fmt.Errorf("invalid json-field-map (or JSON_FIELD_MAP env var): %w", err)
+		}
+		cfg.fieldMapping = mapping
+	}
+
+	if cfg.MaxUploadAttempts < 0 {
+		return errors.New("max-upload-attempts (or MAX_UPLOAD_ATTEMPTS env var) must not be negative")
+	}
+
+	if cfg.MaxDeleteFailures < 0 {
+		return errors.New("max-delete-failures (or MAX_DELETE_FAILURES env var) must not be negative")
+	}
+	if cfg.AsyncDelete && cfg.DeleteQueueSize < 1 {
+		return errors.New("delete-queue-size (or DELETE_QUEUE_SIZE env var) must be at least 1")
+	}
+
+	if cfg.MaxUploadAttempts > 0 && cfg.DeadLetterDir == "" {
+		return errors.New("max-upload-attempts (or MAX_UPLOAD_ATTEMPTS env var) requires dead-letter-dir (or DEAD_LETTER_DIR env var)")
+	}
+
+	if cfg.MaxRetries < 0 {
+		return errors.New("max-retries (or MAX_RETRIES env var) must not be negative")
+	}
+	if cfg.RetryBudget < 0 {
+		return errors.New("retry-budget (or RETRY_BUDGET env var) must not be negative")
+	}
+	if cfg.RetryBudget > 0 {
+		cfg.retryBudget = newRetryBudget(cfg.RetryBudget)
+	}
+
+	retryable, err := parseErrorClasses(cfg.RetryableErrors)
+	if err != nil {
+		return fmt.Errorf("invalid retryable-errors (or RETRYABLE_ERRORS env var): %w", err)
+	}
+	cfg.retryableErrors = retryable
+
+	if cfg.CoordinationLock != "" {
+		if cfg.CoordinationSlots < 1 {
+			return errors.New("coordination-slots (or COORDINATION_SLOTS env var) must be at least 1")
+		}
+		if cfg.CoordinationWait < 0 {
+			return errors.New("coordination-wait (or COORDINATION_WAIT env var) must not be negative")
+		}
+	}
+
+	if cfg.SoftMemoryLimit < 0 {
+		return errors.New("soft-memory-limit (or SOFT_MEMORY_LIMIT env var) must not be negative")
+	}
+	if cfg.GCPercent < -1 {
+		return errors.New("gc-percent (or GC_PERCENT env var) must be -1 or more")
+	}
+	// Without a limit, nothing would ever trigger a collection
+	if cfg.GCPercent == -1 && cfg.SoftMemoryLimit == 0 {
+		return errors.New("gc-percent (or GC_PERCENT env var) of -1 requires soft-memory-limit")
+	}
+
+	if cfg.MultipartSize < 0 || (cfg.MultipartSize > 0 && cfg.MultipartSize < 5*mebibyte) {
+		return errors.New("multipart-size (or MULTIPART_SIZE env var) must be at least 5MiB")
+	}
+
+	if cfg.MultipartConcurrency < 0 {
+		return errors.New("multipart-concurrency (or MULTIPART_CONCURRENCY env var) must not be negative")
+	}
+
+	tags, err := parseObjectTags(cfg.ObjectTags)
+	if err != nil {
+		return fmt.Errorf("invalid object-tags (or OBJECT_TAGS env var): %w", err)
+	}
+	cfg.objectTags = tags
+
+	mode, retainUntil, err := parseObjectLock(cfg.ObjectLockMode, cfg.ObjectLockRetainUntil, time.Now())
+	if err != nil {
+		return err
+	}
+	cfg.ObjectLockMode, cfg.ObjectLockRetainUntil = mode, retainUntil
+
+	routes, err := parseRoutes(cfg.Routes, cfg.S3BucketPath)
+	if err != nil {
+		return err
+	}
+	cfg.routes = routes
+
+	if cfg.MaxAgeDelete < 0 {
+		return errors.New("max-age-delete (or MAX_AGE_DELETE env var) must not be negative")
+	}
+	if cfg.MaxAgeDelete > 0 && !cfg.EnableExpiry {
+		return errors.New("max-age-delete (or MAX_AGE_DELETE env var) deletes files without uploading them and requires enable-expiry (or ENABLE_EXPIRY env var)")
+	}
+
+	if cfg.EmptyFileAction != emptyFileLeave && cfg.EmptyFileAction != emptyFileDelete {
+		return fmt.Errorf("empty-file-action (or EMPTY_FILE_ACTION env var) must be %s or %s, got %q", emptyFileLeave, emptyFileDelete, cfg.EmptyFileAction)
+	}
+
+	if cfg.StableCopy && cfg.WorkDir == "" {
+		return errors.New("stable-copy (or STABLE_COPY env var) requires work-dir (or WORK_DIR env var)")
+	}
+
+	// Whole directories are stripped, "spool" must not cut "spool2/x" to "2/x"
+	if cfg.StripPrefix != "" {
+		cfg.StripPrefix = strings.Trim(filepath.ToSlash(cfg.StripPrefix), "/")
+		if cfg.StripPrefix == "" || strings.ContainsAny(cfg.StripPrefix, "*?") {
+			return errors.New("strip-prefix (or STRIP_PREFIX env var) must be a relative directory without wildcards")
+		}
+		cfg.StripPrefix += "/"
+	}
+
+	if cfg.SanitizeKeys {
+		sanitizer, err := newKeySanitizer(cfg.SanitizeAllowedChars, cfg.SanitizeReplacement)
+		if err != nil {
+			return fmt.Errorf("invalid sanitize-keys configuration: %w", err)
+		}
+		cfg.keySanitizer = sanitizer
+	}
+
+	if cfg.AllowedUIDs != "" || cfg.AllowedGIDs != "" {
+		if !ownerSupported {
+			return errors.New("allowed-uid and allowed-gid are not supported on this platform")
+		}
+		var err error
+		if cfg.allowedUIDs, err = parseIDs(cfg.AllowedUIDs); err != nil {
+			return fmt.Errorf("invalid allowed-uid (or ALLOWED_UID env var): %w", err)
+		}
+		if cfg.allowedGIDs, err = parseIDs(cfg.AllowedGIDs); err != nil {
+			return fmt.Errorf("invalid allowed-gid (or ALLOWED_GID env var): %w", err)
+		}
+	}
+	if cfg.MaxKeyLength < 0 {
+		return errors.New("max-key-length (or MAX_KEY_LENGTH env var) must not be negative")
+	}
+
+	if cfg.GzipEncoding && !cfg.AutoContentType {
+		return errors.New("gzip-content-encoding (or GZIP_CONTENT_ENCODING env var) requires auto-content-type")
+	}
+
+	if err := checkOverlaps(cfg); err != nil {
+		return err
+	}
+
+	// Streamed commands can't be withdrawn once a later file turns out to map
+	// to the same key
+	if cfg.PipelineUploads && (cfg.SanitizeKeys || cfg.StripPrefix != "") {
+		return errors.New("pipeline-uploads (or PIPELINE_UPLOADS env var) can't be combined with sanitize-keys or strip-prefix")
+	}
+
+	return nil
+}
+
+// What a second signal during shutdown does.
+const (
+	secondSignalExit       = "exit"
+	secondSignalAbortDrain = "abort-drain"
+)
+
+// Actions for empty files skipped by skip-empty-files.
+const (
+	emptyFileLeave  = "leave"
+	emptyFileDelete = "delete"
+)
+
+const (
+	kibibyte = 1024
+	mebibyte = 1024 * kibibyte
+	gibibyte = 1024 * mebibyte
+)
+
+// instanceIDPattern matches the instance ids accepted, which covers hostnames.
+var instanceIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// sizeUnits maps the accepted size suffixes to their multiplier.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   kibibyte,
+	"kb":  1000,
+	"kib": kibibyte,
+	"m":   mebibyte,
+	"mb":  1000 * 1000,
+	"mib": mebibyte,
+	"g":   gibibyte,
+	"gb":  1000 * 1000 * 1000,
+	"gib": gibibyte,
+}
+
+// parseSize parses a human-readable size such as 512KiB, 64MB or 1g into bytes.
+// An empty string yields 0.
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	split := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if split < 0 {
+		split = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:split], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(value[split:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", value)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// envPrefix namespaces the environment variables of s5-commander. Prefixed
+// variables take precedence over the unprefixed ones, which are still read for
+// backward compatibility.
+const envPrefix = "S5C_"
+
+// warnedBothSet holds the variables lookupEnv found set both with and without
+// the prefix, so that each is warned about once however often it is read.
+var warnedBothSet sync.Map
+
+// lookupEnv returns the value of the environment variable envKey, preferring
+// its prefixed variant and warning when both are set.
+func lookupEnv(envKey string) string {
+	value := os.Getenv(envKey)
+	if prefixed := os.Getenv(envPrefix + envKey); prefixed != "" {
+		if value != "" {
+			if _, warned := warnedBothSet.LoadOrStore(envKey, true); !warned {
+				log.Printf("Warning: both %s and %s are set, using %s", envPrefix+envKey, envKey, envPrefix+envKey)
+			}
+		}
+		return prefixed
+	}
+	return value
+}
+
+// envSources holds the variable each setting taken from the environment was
+// read from, keyed by its unprefixed name. Values a getter rejected are not
+// recorded, the flag value applies instead.
+var envSources sync.Map
+
+// useEnv records that the setting of envKey was taken from the environment.
+func useEnv(envKey string) {
+	source := envKey
+	if os.Getenv(envPrefix+envKey) != "" {
+		source = envPrefix + envKey
+	}
+	envSources.Store(envKey, source)
+}
+
+// getEnvOrFlag returns the environment variable value if set, otherwise returns the flag value
+func getEnvOrFlag(envKey string, flagValue string) string {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		useEnv(envKey)
+		return envValue
+	}
+	return flagValue
+}
+
+// getEnvOrFlagDuration returns the environment variable value as duration if set, otherwise returns the flag value.
+// Bare numbers are seconds, values that can't be parsed are ignored with a warning.
+func getEnvOrFlagDuration(envKey string, flagValue time.Duration) time.Duration {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if duration, err := time.ParseDuration(envValue); err == nil {
+			useEnv(envKey)
+			return duration
+		}
+		if seconds, err := strconv.ParseFloat(envValue, 64); err == nil {
+			useEnv(envKey)
+			return time.Duration(seconds * float64(time.Second))
+		}
+		log.Printf("Warning: %s=%q is not a duration like 30s or 5m, using %v", envKey, envValue, flagValue)
+	}
+	return flagValue
+}
+
+// getEnvOrFlagAge is getEnvOrFlagDuration for ages that files are deleted at.
+// A bare number is ignored with a warning: read as seconds, MAX_AGE_DELETE=7
+// meant as days would delete files right away.
+func getEnvOrFlagAge(envKey string, flagValue time.Duration) time.Duration {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if _, err := strconv.ParseFloat(envValue, 64); err == nil {
+			log.Printf("Warning: %s=%q has no unit, give one like %ss or %sh; using %v", envKey, envValue, envValue, envValue, flagValue)
+			return flagValue
+		}
+	}
+	return getEnvOrFlagDuration(envKey, flagValue)
+}
+
+// boolValues maps the accepted spellings of booleans in environment variables,
+// compared case-insensitively, to their value.
+var boolValues = map[string]bool{
+	"true": true, "t": true, "1": true, "yes": true, "y": true, "on": true, "enable": true, "enabled": true,
+	"false": false, "f": false, "0": false, "no": false, "n": false, "off": false, "disable": false, "disabled": false,
+}
+
+// getEnvOrFlagBool returns the environment variable value as bool if set, otherwise returns the flag value.
+// Values that aren't recognized are ignored with a warning.
+func getEnvOrFlagBool(envKey string, flagValue bool) bool {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if value, ok := boolValues[strings.ToLower(strings.TrimSpace(envValue))]; ok {
+			useEnv(envKey)
+			return value
+		}
+		log.Printf("Warning: %s=%q is not a boolean like true or false, using %v", envKey, envValue, flagValue)
+	}
+	return flagValue
+}
+
+// getEnvOrFlagInt returns the environment variable value as int if set, otherwise returns the flag value.
+// Values that can't be parsed are ignored with a warning.
+func getEnvOrFlagInt(envKey string, flagValue int) int {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if value, err := strconv.Atoi(envValue); err == nil {
+			useEnv(envKey)
+			return value
+		}
+		log.Printf("Warning: %s=%q is not a whole number, using %v", envKey, envValue, flagValue)
+	}
+	return flagValue
+}
+
+// getEnvOrFlagUint64 returns the environment variable value as uint64 if set, otherwise returns the flag value.
+// Values that can't be parsed, negative ones included, are ignored with a warning.
+func getEnvOrFlagUint64(envKey string, flagValue uint64) uint64 {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if value, err := strconv.ParseUint(envValue, 10, 64); err == nil {
+			useEnv(envKey)
+			return value
+		}
+		log.Printf("Warning: %s=%q is not a non-negative whole number, using %v", envKey, envValue, flagValue)
+	}
+	return flagValue
+}
+
+// getEnvOrFlagFloat returns the environment variable value as float64 if set, otherwise returns the flag value.
+// Values that can't be parsed are ignored with a warning.
+func getEnvOrFlagFloat(envKey string, flagValue float64) float64 {
+	if envValue := lookupEnv(envKey); envValue != "" {
+		if value, err := strconv.ParseFloat(envValue, 64); err == nil {
+			useEnv(envKey)
+			return value
+		}
+		log.Printf("Warning: %s=%q is not a number, using %v", envKey, envValue, flagValue)
+	}
+	return flagValue
+}
+
+// stringList is a flag.Value collecting the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ";") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// getEnvOrFlagList returns the ';'-separated values of the environment variable
+// if set, otherwise returns the flag values
+func getEnvOrFlagList(envKey string, flagValue []string) []string {
+	if envValue := getEnvOrFlag(envKey, ""); envValue != "" {
+		var values []string
+		for _, value := range strings.Split(envValue, ";") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		return values
+	}
+	return flagValue
+}
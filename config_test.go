@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookupEnvPrefersPrefixedVariable(t *testing.T) {
+	t.Setenv("PRECEDENCE_TEST", "legacy")
+	t.Setenv(envPrefix+"PRECEDENCE_TEST", "prefixed")
+
+	if got := lookupEnv("PRECEDENCE_TEST"); got != "prefixed" {
+		t.Errorf("got %q, want the prefixed value", got)
+	}
+}
+
+func TestLookupEnvFallsBackToLegacyVariable(t *testing.T) {
+	t.Setenv("LEGACY_ONLY_TEST", "legacy")
+	t.Setenv(envPrefix+"LEGACY_ONLY_TEST", "")
+
+	if got := lookupEnv("LEGACY_ONLY_TEST"); got != "legacy" {
+		t.Errorf("got %q, want the legacy value", got)
+	}
+	if got := getEnvOrFlag("UNSET_PRECEDENCE_TEST", "flag"); got != "flag" {
+		t.Errorf("got %q, want the flag value without either variable", got)
+	}
+}
+
+func TestGetEnvOrFlagIntWarnsOnInvalidValue(t *testing.T) {
+	t.Setenv("PROCESS_INTERVAL_TEST", "abc")
+	logged := captureLog(t)
+
+	if got := getEnvOrFlagInt("PROCESS_INTERVAL_TEST", 5); got != 5 {
+		t.Errorf("got %d, want the flag value 5", got)
+	}
+	if !strings.Contains(logged.String(), `PROCESS_INTERVAL_TEST="abc"`) {
+		t.Errorf("got log %q, want a warning about the value", logged)
+	}
+}
+
+func TestGetEnvOrFlagUint64RejectsNegativeValue(t *testing.T) {
+	t.Setenv("MIN_FREE_INODES_TEST", "-1")
+	logged := captureLog(t)
+
+	if got := getEnvOrFlagUint64("MIN_FREE_INODES_TEST", 1000); got != 1000 {
+		t.Errorf("got %d, want the flag value 1000", got)
+	}
+	if !strings.Contains(logged.String(), `MIN_FREE_INODES_TEST="-1"`) {
+		t.Errorf("got log %q, want a warning about the value", logged)
+	}
+
+	t.Setenv("MIN_FREE_INODES_TEST", "18446744073709551615")
+	if got := getEnvOrFlagUint64("MIN_FREE_INODES_TEST", 1000); got != 1<<64-1 {
+		t.Errorf("got %d, want the full uint64 range", got)
+	}
+}
+
+func TestGetEnvOrFlagAgeRejectsBareNumber(t *testing.T) {
+	t.Setenv("MAX_AGE_TEST", "7")
+	logged := captureLog(t)
+
+	if got := getEnvOrFlagAge("MAX_AGE_TEST", 0); got != 0 {
+		t.Errorf("got %v, want the flag value 0", got)
+	}
+	if !strings.Contains(logged.String(), `MAX_AGE_TEST="7" has no unit`) {
+		t.Errorf("got log %q, want a warning about the missing unit", logged)
+	}
+
+	t.Setenv("MAX_AGE_TEST", "168h")
+	if got := getEnvOrFlagAge("MAX_AGE_TEST", 0); got != 168*time.Hour {
+		t.Errorf("got %v, want 168h", got)
+	}
+}
+
+func TestLookupEnvWarnsOnceAboutBothVariants(t *testing.T) {
+	t.Setenv("BOTH_SET_TEST", "legacy")
+	t.Setenv(envPrefix+"BOTH_SET_TEST", "prefixed")
+	logged := captureLog(t)
+
+	for range 3 {
+		if got := lookupEnv("BOTH_SET_TEST"); got != "prefixed" {
+			t.Errorf("got %q, want the prefixed value", got)
+		}
+	}
+	if warnings := strings.Count(logged.String(), "both "+envPrefix+"BOTH_SET_TEST"); warnings != 1 {
+		t.Errorf("got %d warnings, want 1:\n%s", warnings, logged)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"512KiB": 512 * kibibyte,
+		"64MB":   64 * 1000 * 1000,
+		"64m":    64 * mebibyte,
+		"1.5 g":  3 * gibibyte / 2,
+	} {
+		got, err := parseSize(value)
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"abc", "64 parsecs", "MB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("parseSize(%q) was accepted", value)
+		}
+	}
+}
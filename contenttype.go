@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultContentType is set when the type of a file can't be determined.
+const defaultContentType = "application/octet-stream"
+
+// contentOptions returns the s5cmd cp options setting the Content-Type, and
+// with gzip-content-encoding the Content-Encoding, of the upload of path.
+func contentOptions(cfg *Config, path string) []string {
+	ext := filepath.Ext(path)
+	if cfg.GzipEncoding && strings.EqualFold(ext, ".gz") {
+		// Clients decompress transparently and see the type of the inner
+		// file, which is only known by its extension
+		contentType := mime.TypeByExtension(filepath.Ext(strings.TrimSuffix(path, ext)))
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		return []string{"--content-type", contentType, "--content-encoding", "gzip"}
+	}
+	return []string{"--content-type", detectContentType(path)}
+}
+
+// detectContentType returns the MIME type of the file at path by its
+// extension, or else sniffed from its first bytes.
+func detectContentType(path string) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		return contentType
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return defaultContentType
+	}
+	defer file.Close()
+
+	// DetectContentType considers at most 512 bytes
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	if n == 0 {
+		return defaultContentType
+	}
+	return http.DetectContentType(head[:n])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// coordinationPollInterval is how often a blocked run retries to get a token.
+const coordinationPollInterval = 500 * time.Millisecond
+
+// errNoToken is returned when no coordination token became free in time.
+var errNoToken = errors.New("no coordination token available")
+
+// acquireToken takes one of cfg.CoordinationSlots advisory file locks in
+// cfg.CoordinationLock, shared with cooperating processes on the host. It waits
+// up to cfg.CoordinationWait for a free slot and returns the time it waited
+// along with a function releasing the slot.
+func acquireToken(cfg *Config) (release func(), waited time.Duration, err error) {
+	if err := os.MkdirAll(cfg.CoordinationLock, 0o755); err != nil {
+		return nil, 0, fmt.Errorf("error creating coordination lock directory: %w", err)
+	}
+
+	start := time.Now()
+	for {
+		for slot := 0; slot < cfg.CoordinationSlots; slot++ {
+			path := filepath.Join(cfg.CoordinationLock, fmt.Sprintf("slot-%d.lock", slot))
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+			if err != nil {
+				return nil, time.Since(start), fmt.Errorf("error opening coordination lock: %w", err)
+			}
+			locked, err := tryLockFile(f)
+			if err != nil {
+				f.Close()
+				return nil, time.Since(start), fmt.Errorf("error locking %s: %w", path, err)
+			}
+			if locked {
+				return func() {
+					unlockFile(f)
+					f.Close()
+				}, time.Since(start), nil
+			}
+			f.Close()
+		}
+
+		if time.Since(start) >= cfg.CoordinationWait {
+			return nil, time.Since(start), fmt.Errorf("%w after waiting %v", errNoToken, cfg.CoordinationWait)
+		}
+		time.Sleep(coordinationPollInterval)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// checkCredsFilePerms returns an error if the credentials file at path can be
+// read by its group or by others.
+func checkCredsFilePerms(path string) error {
+	// Windows has no POSIX permission bits to check
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error checking credentials file: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("credentials file %s is accessible by other users (mode %04o), restrict it with: chmod 600 %s", path, perm, path)
+	}
+	return nil
+}
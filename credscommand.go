@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryMargin is how long before their expiration credentials are renewed, so
+// that they don't expire during a run.
+const expiryMargin = time.Minute
+
+// commandCredentials is the output of a credentials command, in the format of
+// the AWS credential_process setting.
+type commandCredentials struct {
+	Version         int        `json:"Version"`
+	AccessKeyID     string     `json:"AccessKeyId"`
+	SecretAccessKey string     `json:"SecretAccessKey"`
+	SessionToken    string     `json:"SessionToken"`
+	Expiration      *time.Time `json:"Expiration"`
+}
+
+// parseCommandCredentials parses and checks the output of a credentials command.
+func parseCommandCredentials(output []byte) (commandCredentials, error) {
+	var creds commandCredentials
+	if err := json.Unmarshal(output, &creds); err != nil {
+		return commandCredentials{}, fmt.Errorf("error parsing credentials: %w", err)
+	}
+	if creds.Version != 1 {
+		return commandCredentials{}, fmt.Errorf("unsupported credentials version %d, expected 1", creds.Version)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return commandCredentials{}, errors.New("credentials lack AccessKeyId or SecretAccessKey")
+	}
+	return creds, nil
+}
+
+// credsSource runs the credentials command and caches its credentials until
+// they expire, the TTL elapses or they are rejected.
+type credsSource struct {
+	mu      sync.Mutex
+	command []string
+	ttl     time.Duration
+	creds   commandCredentials
+	renewAt time.Time // zero if there are no credentials
+
+	// masks the credentials in the log, as they are only known once fetched
+	redactor *redactor
+}
+
+func newCredsSource(command string, ttl time.Duration, redactor *redactor) *credsSource {
+	return &credsSource{command: strings.Fields(command), ttl: ttl, redactor: redactor}
+}
+
+// get returns valid credentials, running the command if the cached ones are
+// due for renewal.
+func (p *credsSource) get() (commandCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if !p.renewAt.IsZero() && now.Before(p.renewAt) {
+		return p.creds, nil
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return commandCredentials{}, fmt.Errorf("error running credentials command: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	creds, err := parseCommandCredentials(output)
+	if err != nil {
+		return commandCredentials{}, err
+	}
+	p.redactor.add(creds.SecretAccessKey, creds.SessionToken)
+
+	p.creds = creds
+	p.renewAt = now.Add(p.ttl)
+	if creds.Expiration != nil && creds.Expiration.Add(-expiryMargin).Before(p.renewAt) {
+		p.renewAt = creds.Expiration.Add(-expiryMargin)
+	}
+	return creds, nil
+}
+
+// invalidate drops the cached credentials, after they were rejected.
+func (p *credsSource) invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.renewAt = time.Time{}
+}
+
+// env returns the environment variables passing creds to s5cmd.
+func (c commandCredentials) env() []string {
+	env := []string{
+		"AWS_ACCESS_KEY_ID=" + c.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + c.SecretAccessKey,
+	}
+	if c.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+c.SessionToken)
+	}
+	return env
+}
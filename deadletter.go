@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// handleUploadFailures counts the failed upload attempts of every file that
+// failed in this run and moves files that reached cfg.MaxUploadAttempts to the
+// dead-letter directory, so they stop holding up the rest of the backlog.
+func handleUploadFailures(cfg *Config, summary *Summary) {
+	if cfg.MaxUploadAttempts <= 0 {
+		return
+	}
+
+	for _, source := range summary.UploadsFailed {
+		attempts := cfg.state.recordUploadFailure(source)
+		if attempts < cfg.MaxUploadAttempts {
+			continue
+		}
+
+		target, err := relocate(source, cfg.FolderPrefix, cfg.DeadLetterDir)
+		if err != nil {
+			log.Printf("Error moving %s to the dead-letter directory after %d failed uploads: %v", source, attempts, err)
+			continue
+		}
+		log.Printf("Moved %s to %s after %d failed uploads", source, target, attempts)
+		cfg.state.forget(source)
+		summary.FilesDeadLettered++
+	}
+}
+
+// handleDeleteFailures counts the runs in a row in which a file was uploaded but
+// could not be deleted. Files that reach cfg.MaxDeleteFailures would otherwise
+// be uploaded again on every run, so they are moved to the dead-letter
+// directory if possible and left out of enumeration from then on.
+func handleDeleteFailures(cfg *Config, summary *Summary) {
+	if cfg.MaxDeleteFailures <= 0 {
+		return
+	}
+
+	for _, path := range summary.FilesFailed {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Removed by now after all
+			cfg.state.forget(path)
+			continue
+		}
+		failures := cfg.state.recordDeleteFailure(path, info.ModTime())
+		if failures != cfg.MaxDeleteFailures {
+			continue
+		}
+
+		if cfg.DeadLetterDir != "" {
+			target, err := relocate(path, cfg.FolderPrefix, cfg.DeadLetterDir)
+			if err == nil {
+				log.Printf("WARNING: %s was uploaded but could not be deleted in %d runs, moved it to %s", path, failures, target)
+				cfg.state.forget(path)
+				summary.FilesDeadLettered++
+				continue
+			}
+			log.Printf("Error moving %s to the dead-letter directory: %v", path, err)
+		}
+		log.Printf("WARNING: %s was uploaded but could not be deleted in %d runs, it is no longer uploaded until removed by hand", path, failures)
+	}
+}
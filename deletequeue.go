@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// deleteQueue deletes transferred files in a background goroutine, so that the
+// next run can start while the deletes of the last one are still going on.
+// Files stay in flight from being queued until they are deleted; enumeration
+// leaves them out so they are never uploaded a second time.
+type deleteQueue struct {
+	state    *stateStore
+	queue    chan string
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+	done     Summary // deletes finished since the last collect
+	wg       sync.WaitGroup
+}
+
+func newDeleteQueue(state *stateStore, size int) *deleteQueue {
+	q := &deleteQueue{
+		state:    state,
+		queue:    make(chan string, size),
+		inFlight: make(map[string]struct{}),
+	}
+	q.wg.Add(1)
+	go q.run()
+	return q
+}
+
+// push queues path for deletion, blocking while the queue is full. A path
+// already in flight is not queued again.
+func (q *deleteQueue) push(path string) {
+	q.mu.Lock()
+	if _, ok := q.inFlight[path]; ok {
+		q.mu.Unlock()
+		return
+	}
+	q.inFlight[path] = struct{}{}
+	q.mu.Unlock()
+
+	q.queue <- path
+}
+
+// pending reports whether path is queued for deletion or being deleted.
+func (q *deleteQueue) pending(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.inFlight[path]
+	return ok
+}
+
+func (q *deleteQueue) run() {
+	defer q.wg.Done()
+
+	for path := range q.queue {
+		err := os.Remove(path)
+		if err == nil {
+			q.state.forget(path)
+		}
+
+		q.mu.Lock()
+		if err != nil {
+			recordDeleteFailure(&q.done, path, err)
+		} else {
+			q.done.FilesDeleted++
+		}
+		delete(q.inFlight, path)
+		q.mu.Unlock()
+	}
+}
+
+// collect returns the deletes finished since the last call, with the number of
+// files still in flight as the queue depth.
+func (q *deleteQueue) collect() Summary {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	done := q.done
+	done.DeleteQueueDepth = len(q.inFlight)
+	q.done = Summary{}
+	return done
+}
+
+// Close waits for the queued deletes to finish. Their outcome is left for
+// collect.
+func (q *deleteQueue) Close() error {
+	close(q.queue)
+	q.wg.Wait()
+	return nil
+}
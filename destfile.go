@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// needsTrailingSlash reports whether s3BucketPath must get a trailing slash to
+// be used as a prefix. s5cmd copies a single file to a destination without one
+// as that very object, so many matching files would overwrite each other.
+func (cfg *Config) needsTrailingSlash(s3BucketPath string) bool {
+	return !strings.HasSuffix(s3BucketPath, "/") && strings.ContainsAny(sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), "*?")
+}
+
+// readDestination reads the bucket path published in the file at path.
+func readDestination(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading destination: %w", err)
+	}
+	destination := strings.TrimSpace(string(data))
+	if destination == "" {
+		return "", fmt.Errorf("destination file %s is empty", path)
+	}
+	if !strings.HasPrefix(destination, "s3://") {
+		return "", fmt.Errorf("destination %q in %s is not an s3:// URL", destination, path)
+	}
+	return destination, nil
+}
+
+// refreshDestination points the next run at the bucket path currently
+// published in cfg.S3BucketPathFile, along with the routes relative to it.
+func refreshDestination(cfg *Config) error {
+	destination, err := readDestination(cfg.S3BucketPathFile)
+	if err != nil {
+		return err
+	}
+	if cfg.needsTrailingSlash(destination) {
+		destination += "/"
+	}
+	if destination == cfg.S3BucketPath {
+		return nil
+	}
+
+	routes, err := parseRoutes(cfg.Routes, destination)
+	if err != nil {
+		return err
+	}
+	if cfg.S3BucketPath != "" {
+		log.Printf("Destination changed from %s to %s", cfg.S3BucketPath, destination)
+	}
+	cfg.S3BucketPath = destination
+	cfg.routes = routes
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// countPendingFiles returns the number of files matching the source pattern
+// that the next run would upload. Files the planner skips, such as kept, stuck
+// or colliding ones, are not pending even though they are left in the spool.
+func countPendingFiles(cfg *Config) (int, error) {
+	destinations := make(map[uint64]int) // destination hash -> files mapping to it
+	now := time.Now()
+	_, err := walkCandidates(cfg, sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), func(c candidate) error {
+		if dest, _, skip := planFile(cfg, c, now); skip == planUpload {
+			destinations[destinationHash(dest)]++
+		}
+		return nil
+	})
+	pending := 0
+	for _, files := range destinations {
+		// Colliding files are left in place until they are renamed
+		if files == 1 {
+			pending++
+		}
+	}
+	return pending, err
+}
+
+// drain keeps calling run, one process interval apart, until no files are
+// left in the spool, a run leaves as many files as it found, the shutdown
+// timeout elapses or ctx is cancelled. Runs are passed ctx, so that cancelling
+// it also cuts short their retry backoff, and with the shutdown timeout as its
+// deadline, so that the s5cmd still running then is killed.
+func drain(ctx context.Context, cfg *Config, run func(context.Context)) {
+	// The run in progress when the timeout is up has its s5cmd killed
+	if cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownTimeout)
+		defer cancel()
+	}
+
+	last := 0
+	for runs := 0; ; runs++ {
+		pending, err := countPendingFiles(cfg)
+		if err != nil {
+			log.Printf("Error checking for pending files, stopping drain: %v", err)
+			return
+		}
+		if pending == 0 {
+			log.Printf("Backlog drained after %d runs", runs)
+			return
+		}
+		// Files failing to upload would keep the drain going until the timeout
+		if runs > 0 && pending >= last {
+			log.Printf("Stopping drain, the last run left %d files of %d", pending, last)
+			return
+		}
+		last = pending
+		log.Printf("Draining backlog, %d files left", pending)
+
+		if runs > 0 {
+			select {
+			case <-time.After(cfg.ProcessInterval):
+			case <-ctx.Done():
+				stopDrain(ctx, cfg, pending)
+				return
+			}
+		}
+		run(ctx)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Shutdown timeout of %v reached during a drain run", cfg.ShutdownTimeout)
+			return
+		}
+	}
+}
+
+// stopDrain logs why the drain stopped with pending files left.
+func stopDrain(ctx context.Context, cfg *Config, pending int) {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Shutdown timeout of %v reached with %d files left", cfg.ShutdownTimeout, pending)
+	} else {
+		log.Printf("Drain aborted with %d files left", pending)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDrainRunsUntilBacklogIsEmpty(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.ProcessInterval = time.Millisecond
+	backlog := []string{
+		writeSpoolFile(t, cfg, "a.log", "abc"),
+		writeSpoolFile(t, cfg, "b.log", "abc"),
+		writeSpoolFile(t, cfg, "c.log", "abc"),
+	}
+
+	runs := 0
+	drain(context.Background(), cfg, func(context.Context) {
+		// Every run uploads one file
+		os.Remove(backlog[runs])
+		runs++
+	})
+	if runs != len(backlog) {
+		t.Errorf("got %d runs, want %d", runs, len(backlog))
+	}
+}
+
+func TestDrainStopsWithoutProgress(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.ProcessInterval = time.Millisecond
+	writeSpoolFile(t, cfg, "failing.log", "abc")
+
+	runs := 0
+	drain(context.Background(), cfg, func(context.Context) { runs++ })
+	if runs != 1 {
+		t.Errorf("got %d runs, want the drain to stop after 1 run without progress", runs)
+	}
+}
+
+func TestCountPendingFilesSkipsFilesLeftOnPurpose(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.KeepLargerThan = 1
+	cfg.MaxDeleteFailures = 2
+	cfg.SkipEmptyFiles = true
+	writeSpoolFile(t, cfg, "pending.log", "abc")
+	kept := writeSpoolFile(t, cfg, "kept.log", "abc")
+	stuck := writeSpoolFile(t, cfg, "stuck.log", "abc")
+	writeSpoolFile(t, cfg, "empty.log", "")
+	for path, record := range map[string]func(string, time.Time){
+		kept: cfg.state.recordKept,
+		stuck: func(path string, modTime time.Time) {
+			cfg.state.recordDeleteFailure(path, modTime)
+			cfg.state.recordDeleteFailure(path, modTime)
+		},
+	} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record(path, info.ModTime())
+	}
+
+	pending, err := countPendingFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 {
+		t.Errorf("got %d pending files, want 1", pending)
+	}
+
+	// A drain must not wait for files no run will upload
+	runs := 0
+	os.Remove(cfg.FolderPrefix + "pending.log")
+	drain(context.Background(), cfg, func(context.Context) { runs++ })
+	if runs != 0 {
+		t.Errorf("got %d runs, want none", runs)
+	}
+}
+
+func TestCountPendingFilesSkipsCollidingKeys(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.StripPrefix = "x/"
+	writeSpoolFile(t, cfg, "a.log", "abc")
+	writeSpoolFile(t, cfg, "x/a.log", "abc")
+	writeSpoolFile(t, cfg, "b.log", "abc")
+
+	pending, err := countPendingFiles(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 {
+		t.Errorf("got %d pending files, want 1", pending)
+	}
+}
+
+func TestDrainTimeoutCutsShortSlowRun(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.ProcessInterval = time.Millisecond
+	cfg.ShutdownTimeout = 100 * time.Millisecond
+	writeSpoolFile(t, cfg, "a.log", "abc")
+
+	var runErr error
+	begin := time.Now()
+	drain(context.Background(), cfg, func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			runErr = ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	})
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Fatalf("the drain took %v, want it to stop at the shutdown timeout", elapsed)
+	}
+	if runErr != context.DeadlineExceeded {
+		t.Errorf("the run ended with %v, want its deadline exceeded", runErr)
+	}
+}
+
+func TestRunJobKillsS5cmdAtDeadline(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	uploaded := writeSpoolFile(t, cfg, "a.log", "abc")
+	// Reports the upload, then hangs
+	cfg.S5cmdBinary = fakeS5cmd(t, s5cmdCommands+`for f in $sources; do
+	echo '{"operation":"cp","success":true,"source":"'$f'","destination":"s3://bucket/prefix/x","object":{"type":"file","size":3}}'
+done
+exec sleep 10
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	summary, err := runJob(ctx, cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), cfg.S3BucketPath)
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Fatalf("the job took %v, want s5cmd killed at the deadline", elapsed)
+	}
+	if err == nil {
+		t.Error("the killed s5cmd run reported no error")
+	}
+	// What was uploaded before the kill is cleaned up
+	if summary.FilesDeleted != 1 {
+		t.Errorf("got %d deleted, want 1", summary.FilesDeleted)
+	}
+	assertGone(t, uploaded)
+}
+
+func TestRunJobLetsS5cmdFinishOnCancel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	uploaded := writeSpoolFile(t, cfg, "a.log", "abc")
+	cfg.S5cmdBinary = fakeS5cmd(t, "sleep 0.3\n"+s5cmdCommands+`for f in $sources; do
+	echo '{"operation":"cp","success":true,"source":"'$f'","destination":"s3://bucket/prefix/x","object":{"type":"file","size":3}}'
+done
+`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := runJob(ctx, cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), cfg.S3BucketPath); err != nil {
+		t.Fatal(err)
+	}
+	assertGone(t, uploaded)
+}
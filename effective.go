@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// setting is the effective value of a flag and where it came from: its
+// default, the command line or an environment variable, which wins over the
+// command line.
+type setting struct {
+	name   string
+	value  string
+	source string
+}
+
+// envNamePattern finds the environment variable of a flag in its usage.
+var envNamePattern = regexp.MustCompile(`\(env: ([A-Z0-9_]+)`)
+
+// settingFields names the Config fields of the flags whose field isn't named
+// after the flag.
+var settingFields = map[string]string{
+	"allowed-gid":                 "AllowedGIDs",
+	"allowed-uid":                 "AllowedUIDs",
+	"bytes-shortfall-percent":     "ShortfallPercent",
+	"gzip-content-encoding":       "GzipEncoding",
+	"route":                       "Routes",
+	"s5cmd-binary-selection":      "BinarySelection",
+	"state-compact-interval":      "StateCompaction",
+	"throughput-degraded-percent": "DegradedPercent",
+	"verify-write-access":         "VerifyWrite",
+}
+
+// resolveSettings returns the effective value of every flag of flags, sorted by
+// name. The values are taken from cfg, so it should be called once cfg is
+// validated, to show them as normalized by the validation.
+func resolveSettings(cfg *Config, flags *flag.FlagSet) []setting {
+	onCommandLine := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	var settings []setting
+	flags.VisitAll(func(f *flag.Flag) {
+		s := setting{name: f.Name, value: settingValue(cfg, f), source: "default"}
+		if onCommandLine[f.Name] {
+			s.source = "flag"
+		}
+		if match := envNamePattern.FindStringSubmatch(f.Usage); match != nil {
+			if key, ok := envSources.Load(match[1]); ok {
+				s.source = "env " + key.(string)
+			}
+		}
+		settings = append(settings, s)
+	})
+	return settings
+}
+
+// settingValue renders the value of the Config field of f, or the value of f
+// itself if it has none.
+func settingValue(cfg *Config, f *flag.Flag) string {
+	name, ok := settingFields[f.Name]
+	if !ok {
+		name = strings.ReplaceAll(f.Name, "-", "")
+	}
+	fields := reflect.ValueOf(cfg).Elem()
+	for i := range fields.NumField() {
+		field := fields.Type().Field(i)
+		if !field.IsExported() || !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		switch value := fields.Field(i).Interface().(type) {
+		case []string:
+			return strings.Join(value, ";")
+		case time.Duration:
+			return value.String()
+		default:
+			return fmt.Sprint(value)
+		}
+	}
+	return f.Value.String()
+}
+
+// logEffectiveConfig logs settings as a single block, with secrets and the
+// credentials of URLs redacted.
+func logEffectiveConfig(settings []setting) {
+	var b strings.Builder
+	b.WriteString("Effective configuration:")
+	for _, s := range settings {
+		value := s.value
+		if isSensitiveKey(s.name) && value != "" {
+			value = "REDACTED"
+		}
+		if strings.Contains(value, "://") {
+			value = redactURL(value)
+		}
+		fmt.Fprintf(&b, "\n  %s = %q (%s)", s.name, value, s.source)
+	}
+	log.Print(b.String())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestResolveSettingsReportsParsedValues(t *testing.T) {
+	t.Setenv("PROCESS_INTERVAL", "abc")
+	t.Setenv("MAX_RETRIES", "3")
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Duration("process-interval", time.Second, "Interval (env: PROCESS_INTERVAL)")
+	flags.Int("max-retries", 0, "Retries (env: MAX_RETRIES)")
+	flags.String("s3-bucket-path", "", "Destination (env: S3_BUCKET_PATH)")
+	flags.String("allowed-uid", "", "Owners (env: ALLOWED_UID)")
+	if err := flags.Parse([]string{"--s3-bucket-path", "s3://bucket/prefix"}); err != nil {
+		t.Fatal(err)
+	}
+
+	captureLog(t)
+	cfg := newTestConfig(t)
+	cfg.ProcessInterval = getEnvOrFlagDuration("PROCESS_INTERVAL", time.Second)
+	cfg.MaxRetries = getEnvOrFlagInt("MAX_RETRIES", 0)
+	cfg.AllowedUIDs = "1000,1001"
+	// as normalized by validate
+	cfg.S3BucketPath = "s3://bucket/prefix/"
+
+	want := map[string]setting{
+		"process-interval": {value: "1s", source: "default"},
+		"max-retries":      {value: "3", source: "env MAX_RETRIES"},
+		"s3-bucket-path":   {value: "s3://bucket/prefix/", source: "flag"},
+		"allowed-uid":      {value: "1000,1001", source: "default"},
+	}
+	for _, s := range resolveSettings(cfg, flags) {
+		w := want[s.name]
+		if s.value != w.value || s.source != w.source {
+			t.Errorf("%s: got %q (%s), want %q (%s)", s.name, s.value, s.source, w.value, w.source)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// candidate is a local file selected for upload.
+type candidate struct {
+	Path    string
+	Key     string // object key relative to the destination, '/'-separated
+	Size    int64
+	ModTime time.Time
+}
+
+// globRegexp converts an s5cmd wildcard pattern into a regular expression. As in
+// s5cmd, '*' matches any sequence of characters including path separators and
+// '?' matches any single character.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// staticPrefix returns the directory part of pattern before its first wildcard,
+// which is the directory s5cmd computes object keys relative to.
+func staticPrefix(pattern string) string {
+	wildcard := strings.IndexAny(pattern, "*?")
+	if wildcard < 0 {
+		return filepath.Dir(pattern)
+	}
+	return filepath.Dir(pattern[:wildcard] + "x")
+}
+
+// enumerateChunkSize is the number of directory entries read at once, so that
+// huge directories are never held in memory as a whole.
+const enumerateChunkSize = 1024
+
+// walkCandidates walks the static prefix of srcPath and calls fn for every
+// regular file matching it, as the files are found. Keys are computed relative
+// to the static prefix of the configured source pattern, so that a pattern
+// restricted to a subdirectory yields the same keys as the full source pattern.
+// The work directory is never enumerated, nor are hidden files and directories
+// unless include-hidden is set. Files owned by users or groups outside
+// allowed-uid and allowed-gid are left out as well, their number is returned.
+func walkCandidates(cfg *Config, srcPath string, fn func(candidate) error) (int, error) {
+	re, err := globRegexp(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("error compiling source pattern %q: %w", srcPath, err)
+	}
+	root := staticPrefix(srcPath)
+	base := staticPrefix(sourcePattern(cfg.FolderPrefix, cfg.PathSuffix))
+
+	foreign := 0
+	var order func([]fs.DirEntry)
+	if cfg.shuffler != nil {
+		order = cfg.shuffler.shuffleEntries
+	}
+	err = walkChunked(root, order, cfg.openDirs, func(path string, d fs.DirEntry) error {
+		if d.IsDir() {
+			if slices.Contains(cfg.excludedDirs, path) {
+				return fs.SkipDir
+			}
+			if !cfg.IncludeHidden && path != root && isHidden(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !re.MatchString(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !ownerAllowed(cfg, info) {
+			foreign++
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return nil
+		}
+		// The walk may start in a hidden directory below base, e.g. a
+		// subdirectory of split-by-subdir
+		if !cfg.IncludeHidden && hasHiddenElement(rel) {
+			return nil
+		}
+
+		return fn(candidate{
+			Path:    path,
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	})
+	if err != nil {
+		return foreign, fmt.Errorf("error enumerating %s: %w", root, err)
+	}
+	return foreign, nil
+}
+
+// isHidden reports whether name is a dotfile or dot directory.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hasHiddenElement reports whether any element of the relative path rel is
+// hidden.
+func hasHiddenElement(rel string) bool {
+	for _, element := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isHidden(element) && element != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// walkChunked calls visit for every entry below root, in directory order and
+// reading enumerateChunkSize entries at a time. If order is not nil, it may
+// reorder each chunk before it is visited. Unlike filepath.WalkDir it never
+// reads a whole directory into memory. visit may return fs.SkipDir for a
+// directory to leave it out. A missing root is not an error, and directories
+// that disappear while walking the spool are skipped. Every open directory
+// holds a token of limit.
+func walkChunked(root string, order func([]fs.DirEntry), limit *dirLimiter, visit func(path string, d fs.DirEntry) error) error {
+	limit.acquire()
+	dir, err := os.Open(root)
+	if err != nil {
+		limit.release()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return walkDir(dir, root, true, order, limit, visit)
+}
+
+// walkDir walks the open directory dir at path and closes it, returning its
+// token to limit. Read errors are only returned for the root, other
+// directories are skipped from where reading failed.
+//
+// When no token is free for a subdirectory, the rest of dir is read into
+// memory and dir is closed early, so that a walk never holds more than one
+// token while it waits and the number of open directories stays bounded
+// however deep the tree is.
+func walkDir(dir *os.File, path string, isRoot bool, order func([]fs.DirEntry), limit *dirLimiter, visit func(path string, d fs.DirEntry) error) error {
+	defer func() {
+		if dir != nil {
+			dir.Close()
+			limit.release()
+		}
+	}()
+
+	for {
+		entries, err := dir.ReadDir(enumerateChunkSize)
+		if order != nil {
+			order(entries)
+		}
+		for i := 0; i < len(entries); i++ {
+			entry := entries[i]
+			entryPath := filepath.Join(path, entry.Name())
+			if err := visit(entryPath, entry); err != nil {
+				if err == fs.SkipDir && entry.IsDir() {
+					continue
+				}
+				return err
+			}
+			if !entry.IsDir() {
+				continue
+			}
+
+			if !limit.tryAcquire() {
+				if dir != nil && err == nil {
+					var rest []fs.DirEntry
+					rest, err = dir.ReadDir(-1)
+					if order != nil {
+						order(rest)
+					}
+					entries = append(entries, rest...)
+					if err == nil {
+						err = io.EOF
+					}
+				}
+				if dir != nil {
+					dir.Close()
+					dir = nil
+					limit.release()
+				}
+				limit.acquire()
+			}
+			sub, openErr := os.Open(entryPath)
+			if openErr != nil {
+				limit.release()
+				continue
+			}
+			if err := walkDir(sub, entryPath, false, order, limit, visit); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || (err != nil && !isRoot) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
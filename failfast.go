@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// errFailedFast is returned by runS5cmd when s5cmd was stopped at its first
+// failed upload.
+var errFailedFast = errors.New("s5cmd stopped at the first failed upload")
+
+// failWatcher scans the output of s5cmd while it is written and calls kill at
+// the first failed upload record.
+type failWatcher struct {
+	cfg    *Config
+	kill   func()
+	mu     sync.Mutex
+	failed bool
+}
+
+func newFailWatcher(cfg *Config, kill func()) *failWatcher {
+	return &failWatcher{cfg: cfg, kill: kill}
+}
+
+// stream returns a writer for one output stream of s5cmd.
+func (w *failWatcher) stream() *lineWriter {
+	return &lineWriter{check: w.check}
+}
+
+// tripped reports whether s5cmd was killed because of a failed upload.
+func (w *failWatcher) tripped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failed
+}
+
+func (w *failWatcher) check(line []byte) {
+	var result JobResult
+	if err := decodeResult(w.cfg, line, &result); err != nil {
+		return
+	}
+	if result.Operation != "cp" || result.Success || result.Error == "" || result.skippedExisting() || sourceVanished(w.cfg, &result) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.failed {
+		w.failed = true
+		w.kill()
+	}
+}
+
+// lineWriter collects the lines of one output stream, which may be written in
+// arbitrary pieces, and calls check for every complete line.
+type lineWriter struct {
+	check func(line []byte)
+	line  []byte
+}
+
+func (s *lineWriter) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		end := bytes.IndexByte(s.line, '\n')
+		if end < 0 {
+			break
+		}
+		s.check(s.line[:end])
+		s.line = s.line[end+1:]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingS5cmd returns a fake s5cmd reporting a failed upload and then a
+// successful one, which s5cmd only gets to if it isn't stopped.
+func failingS5cmd(t *testing.T) (binary, marker string) {
+	t.Helper()
+	marker = filepath.Join(t.TempDir(), "completed")
+	script := "echo '" + cpFailure("/spool/a.log", "connection refused") + "'\n" +
+		"sleep 1\n" +
+		"echo '" + cpSuccess("/spool/b.log", 3) + "'\n" +
+		"touch " + marker + "\n"
+	return fakeS5cmd(t, script), marker
+}
+
+func TestFailFastStopsUploadRuns(t *testing.T) {
+	cfg := newTestConfig(t)
+	binary, marker := failingS5cmd(t)
+	output := filepath.Join(t.TempDir(), "job.json")
+
+	err := runS5cmdInput(context.Background(), cfg, binary, []string{"run", "commands"}, nil, nil, true, output, output)
+	if !errors.Is(err, errFailedFast) {
+		t.Errorf("got %v, want errFailedFast", err)
+	}
+	assertGone(t, marker)
+}
+
+func TestFailFastLeavesOtherOperationsAlone(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.FailFast = true
+	binary, marker := failingS5cmd(t)
+	cfg.S5cmdBinary = binary
+	output := filepath.Join(t.TempDir(), "probe.json")
+
+	// e.g. the manifest, heartbeat or probe upload
+	if err := runS5cmd(cfg, []string{"cp", "local", "s3://bucket/prefix/remote"}, output, output); err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, marker)
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"success":true`) {
+		t.Errorf("got output %q, want the record written after the failure", data)
+	}
+}
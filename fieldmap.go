@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fieldMapping maps the fields of a JobResult to dotted paths into the JSON
+// records written by s5cmd, e.g. "object.size" for {"object":{"size":1}}.
+type fieldMapping map[string]string
+
+// defaultFieldMapping matches the JSON output of upstream s5cmd.
+var defaultFieldMapping = fieldMapping{
+	"operation":   "operation",
+	"success":     "success",
+	"source":      "source",
+	"destination": "destination",
+	"object.type": "object.type",
+	"object.size": "object.size",
+	"command":     "command",
+	"job":         "job",
+	"error":       "error",
+}
+
+// parseFieldMapping parses comma-separated field=path overrides of the default
+// mapping, e.g. "source=key,object.size=size".
+func parseFieldMapping(spec string) (fieldMapping, error) {
+	mapping := make(fieldMapping, len(defaultFieldMapping))
+	for field, path := range defaultFieldMapping {
+		mapping[field] = path
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		field, path, ok := strings.Cut(pair, "=")
+		field, path = strings.TrimSpace(field), strings.TrimSpace(path)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("invalid field mapping %q, expected field=path", pair)
+		}
+		if _, known := defaultFieldMapping[field]; !known {
+			return nil, fmt.Errorf("unknown field %q in field mapping", field)
+		}
+		mapping[field] = path
+	}
+	return mapping, nil
+}
+
+// decodeResult decodes an s5cmd output line into result, using the configured
+// field mapping if there is one.
+func decodeResult(cfg *Config, line []byte, result *JobResult) error {
+	if cfg.fieldMapping == nil {
+		return json.Unmarshal(line, result)
+	}
+	return cfg.fieldMapping.decode(line, result)
+}
+
+// decode decodes a JSON record into result through the mapping.
+func (m fieldMapping) decode(line []byte, result *JobResult) error {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var record map[string]any
+	if err := decoder.Decode(&record); err != nil {
+		return err
+	}
+
+	result.Operation = m.str(record, "operation")
+	result.Source = m.str(record, "source")
+	result.Destination = m.str(record, "destination")
+	result.Object.Type = m.str(record, "object.type")
+	result.Command = m.str(record, "command")
+	result.Job = m.str(record, "job")
+	result.Error = m.str(record, "error")
+
+	switch v := lookup(record, m["success"]).(type) {
+	case bool:
+		result.Success = v
+	case string:
+		result.Success, _ = strconv.ParseBool(v)
+	}
+	if size, ok := lookup(record, m["object.size"]).(json.Number); ok {
+		result.Object.Size, _ = size.Int64()
+	}
+	return nil
+}
+
+func (m fieldMapping) str(record map[string]any, field string) string {
+	s, _ := lookup(record, m[field]).(string)
+	return s
+}
+
+// lookup returns the value at the dotted path in record, or nil.
+func lookup(record map[string]any, path string) any {
+	var value any = record
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// isUnder reports whether path lies inside dir.
+func isUnder(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relocate moves path from below fromDir to the same relative location below
+// toDir and returns the new path. Existing files are never overwritten.
+func relocate(path, fromDir, toDir string) (string, error) {
+	rel, err := filepath.Rel(fromDir, path)
+	if err != nil || !isUnder(path, fromDir) {
+		return "", fmt.Errorf("%s is not under %s", path, fromDir)
+	}
+
+	target := filepath.Join(toDir, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", filepath.Dir(target), err)
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return "", fmt.Errorf("%s already exists", target)
+	}
+	return target, moveFile(path, target)
+}
+
+// moveFile renames src to dst, falling back to copy and remove when they are on
+// different filesystems.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to the new file dst, keeping its permissions and
+// modification time.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// linkOrCopy hardlinks src to dst, which needs no copying when both are on the
+// same filesystem, and copies the file otherwise. It reports whether a link
+// was created.
+func linkOrCopy(src, dst string) (bool, error) {
+	if err := os.Link(src, dst); err == nil {
+		return true, nil
+	} else if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.EMLINK) {
+		return false, err
+	}
+	return false, copyFile(src, dst)
+}
+
+// createExclusive creates empty files at paths, failing with fs.ErrExist if
+// one of them exists already. On failure, the files it created are removed and
+// existing ones are left untouched.
+func createExclusive(paths ...string) error {
+	for i, path := range paths {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			for _, created := range paths[:i] {
+				os.Remove(created)
+			}
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// outputEmpty reports whether all of the files are empty. Files that can't be
+// inspected don't count as empty.
+func outputEmpty(paths []string) bool {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() > 0 {
+			return false
+		}
+	}
+	return true
+}
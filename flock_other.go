@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// File locks are only implemented on Unix.
+func tryLockFile(f *os.File) (bool, error) {
+	return false, errors.New("file locks are not supported on this platform")
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// heartbeat is the content of the heartbeat object.
+type heartbeat struct {
+	InstanceID       string    `json:"instance_id"`
+	Timestamp        time.Time `json:"timestamp"`
+	FilesTransferred int       `json:"files_transferred"` // by the run that wrote it
+}
+
+// heartbeatDestination returns the s3:// URL of the heartbeat object, which is
+// relative to the bucket path unless it is an s3:// URL itself.
+func heartbeatDestination(cfg *Config) string {
+	if strings.HasPrefix(cfg.HeartbeatObject, "s3://") {
+		return cfg.HeartbeatObject
+	}
+	return joinDestination(cfg.S3BucketPath, strings.TrimPrefix(cfg.HeartbeatObject, "/"))
+}
+
+// writeHeartbeat overwrites the heartbeat object after every run, also the
+// ones without files, so that monitors can alert once it goes stale. Failures
+// never fail the run; they are logged when they start and when they end.
+func writeHeartbeat(cfg *Config, summary *Summary) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		log.Printf("Error generating heartbeat ID: %v", err)
+		return
+	}
+	data, err := json.Marshal(heartbeat{
+		InstanceID:       cfg.InstanceID,
+		Timestamp:        time.Now().UTC(),
+		FilesTransferred: summary.FilesTransferred,
+	})
+	if err != nil {
+		log.Printf("Error encoding heartbeat: %v", err)
+		return
+	}
+
+	localFile := fmt.Sprintf("%s.heartbeat.json", id)
+	defer os.Remove(localFile)
+	if err := os.WriteFile(localFile, append(data, '\n'), 0o644); err != nil {
+		log.Printf("Error writing heartbeat: %v", err)
+		return
+	}
+
+	outputFile := fmt.Sprintf("%s.json", id)
+	defer os.Remove(outputFile)
+	dest := heartbeatDestination(cfg)
+	err = runS5cmd(cfg, []string{"cp", localFile, dest}, outputFile, outputFile)
+	if err != nil && !cfg.heartbeatDown {
+		log.Printf("Error writing heartbeat object %s, not logging further failures until it succeeds: %s (class %s)", dest, firstError(cfg, outputFile, err), classifyOutput(cfg, outputFile))
+	} else if err == nil && cfg.heartbeatDown {
+		log.Printf("Heartbeat object %s is written again", dest)
+	}
+	cfg.heartbeatDown = err != nil
+}
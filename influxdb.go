@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// influxDBSink writes the metrics of every run as InfluxDB line protocol to a
+// write endpoint, e.g. http://host:8086/api/v2/write?org=o&bucket=b or
+// http://host:8086/write?db=d.
+type influxDBSink struct {
+	url        string
+	token      string
+	instanceID string
+	client     *http.Client
+}
+
+func newInfluxDBSink(url, token, instanceID string, dialTimeout time.Duration) *influxDBSink {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	return &influxDBSink{
+		url:        url,
+		token:      token,
+		instanceID: instanceID,
+		client:     &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+func (s *influxDBSink) Started() error {
+	line := fmt.Sprintf("s5commander_start,instance=%s,version=%s,commit=%s started=1i %d\n",
+		s.instanceID, escapeTag(version), escapeTag(commit), time.Now().UnixNano())
+	return s.write(line)
+}
+
+func (s *influxDBSink) RunCompleted(summary *Summary, runCount int) error {
+	line := fmt.Sprintf(
+		"s5commander_run,instance=%s files_transferred=%di,files_deleted=%di,bytes_transferred=%di,files_failed_delete=%di,files_failed_upload=%di,files_dead_lettered=%di,retries=%di,runs=%di,exec_seconds=%g,parse_seconds=%g %d\n",
+		s.instanceID,
+		summary.FilesTransferred,
+		summary.FilesDeleted,
+		summary.TotalBytes,
+		len(summary.FilesFailed),
+		len(summary.UploadsFailed),
+		summary.FilesDeadLettered,
+		summary.Retries,
+		runCount,
+		summary.ExecDuration.Seconds(),
+		summary.ParseDuration.Seconds(),
+		time.Now().UnixNano(),
+	)
+	return s.write(line)
+}
+
+func (s *influxDBSink) Progress(progress progressSnapshot) error {
+	line := fmt.Sprintf("s5commander_progress,instance=%s files_uploaded=%di,bytes_uploaded=%di,files_failed=%di,files_planned=%di,jobs=%di %d\n",
+		s.instanceID, progress.Uploaded, progress.Bytes, progress.Failed, progress.Planned, progress.Jobs, time.Now().UnixNano())
+	return s.write(line)
+}
+
+func (s *influxDBSink) Shutdown(summary *Summary, totalRuns int) error {
+	line := fmt.Sprintf(
+		"s5commander_session,instance=%s files_transferred=%di,files_deleted=%di,bytes_transferred=%di,files_failed_delete=%di,runs=%di %d\n",
+		s.instanceID,
+		summary.FilesTransferred,
+		summary.FilesDeleted,
+		summary.TotalBytes,
+		len(summary.FilesFailed),
+		totalRuns,
+		time.Now().UnixNano(),
+	)
+	return s.write(line)
+}
+
+func (s *influxDBSink) write(lines string) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewBufferString(lines))
+	if err != nil {
+		return fmt.Errorf("error creating InfluxDB request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB write failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *influxDBSink) Close() error {
+	return nil
+}
+
+// escapeTag escapes a tag value for line protocol.
+func escapeTag(value string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
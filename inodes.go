@@ -0,0 +1,27 @@
+package main
+
+import "log"
+
+// checkFreeInodes records the free inodes of the spool filesystem in summary and
+// flags inode pressure when they drop below cfg.MinFreeInodes. A warning is
+// logged when the pressure starts and a notice when it ends.
+func checkFreeInodes(cfg *Config, summary *Summary) {
+	if cfg.MinFreeInodes == 0 {
+		return
+	}
+
+	free, total, err := freeInodes(cfg.FolderPrefix)
+	if err != nil {
+		log.Printf("Error reading free inodes of %s: %v", cfg.FolderPrefix, err)
+		return
+	}
+	summary.FreeInodes = free
+	summary.InodePressure = free < cfg.MinFreeInodes
+
+	if summary.InodePressure && !cfg.inodePressure {
+		log.Printf("Warning: only %d of %d inodes are free on the filesystem of %s (minimum %d)", free, total, cfg.FolderPrefix, cfg.MinFreeInodes)
+	} else if !summary.InodePressure && cfg.inodePressure {
+		log.Printf("Free inodes on the filesystem of %s are back above the minimum: %d of %d", cfg.FolderPrefix, free, total)
+	}
+	cfg.inodePressure = summary.InodePressure
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// jobIDNamespace is the UUID namespace of deterministic job IDs.
+var jobIDNamespace = uuid.MustParse("6f1c3c0e-2b4a-4d59-9a47-51d2a8f0c7e3")
+
+// newJobID returns the ID of a run's job: a random UUID, or with
+// deterministic-job-id a UUID derived from the instance, the files pending in
+// the spool and the process interval the run started in, so that the same
+// files in the same interval always get the same ID.
+func newJobID(cfg *Config) (string, error) {
+	if !cfg.DeterministicJobID {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", fmt.Errorf("error generating job ID: %v", err)
+		}
+		return id.String(), nil
+	}
+
+	// Combining the path hashes with XOR makes the ID independent of the
+	// enumeration order without holding the file list
+	var combined uint64
+	files := 0
+	_, err := walkCandidates(cfg, sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), func(c candidate) error {
+		h := fnv.New64a()
+		h.Write([]byte(c.Path))
+		combined ^= h.Sum64()
+		files++
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error enumerating files for the job ID: %w", err)
+	}
+
+	bucket := time.Now().Truncate(cfg.ProcessInterval).UnixNano()
+	name := []byte(cfg.InstanceID)
+	name = binary.BigEndian.AppendUint64(name, uint64(bucket))
+	name = binary.BigEndian.AppendUint64(name, uint64(files))
+	name = binary.BigEndian.AppendUint64(name, combined)
+	return uuid.NewSHA1(jobIDNamespace, name).String(), nil
+}
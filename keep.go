@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// keepBySize leaves the uploaded file at path in place because it is larger
+// than keep-larger-than, and remembers it so that it isn't uploaded again
+// until it is modified.
+func keepBySize(cfg *Config, path string, summary *Summary) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Printf("Error reading uploaded file %s kept by size: %v", path, err)
+		return
+	}
+	cfg.state.recordKept(path, info.ModTime())
+	summary.FilesKeptBySize++
+}
@@ -0,0 +1,28 @@
+package main
+
+import "log"
+
+// loadTooHigh reports whether the 1-minute load average of the host exceeds
+// cfg.MaxLoadAverage, in which case the run is skipped. A warning is logged
+// when the throttling starts and a notice when it ends. The load is best
+// effort, if it can't be read runs go ahead.
+func loadTooHigh(cfg *Config) bool {
+	if cfg.MaxLoadAverage == 0 {
+		return false
+	}
+
+	load, err := loadAverage()
+	if err != nil {
+		log.Printf("Error reading the load average: %v", err)
+		return false
+	}
+	throttled := load > cfg.MaxLoadAverage
+
+	if throttled && !cfg.loadThrottled {
+		log.Printf("Warning: load average %.2f is above %g, skipping runs until it drops", load, cfg.MaxLoadAverage)
+	} else if !throttled && cfg.loadThrottled {
+		log.Printf("Load average is back at %.2f, resuming runs", load)
+	}
+	cfg.loadThrottled = throttled
+	return throttled
+}
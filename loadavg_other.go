@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// loadAverage is only implemented on Linux.
+func loadAverage() (float64, error) {
+	return 0, errors.New("the load average is not supported on this platform")
+}
@@ -2,17 +2,19 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -44,139 +46,469 @@ type JobResult struct {
 		Type string `json:"type"`
 		Size int64  `json:"size"`
 	} `json:"object"`
+	Command string `json:"command"`
+	Job     string `json:"job"` // the command of debug records
+	Error   string `json:"error"`
+}
+
+// skippedExisting reports whether result records a file that s5cmd skipped in
+// no-overwrite mode because its object already exists.
+func (r *JobResult) skippedExisting() bool {
+	return r.Operation == "cp" && !r.Success && strings.Contains(r.Error, "already exists")
 }
 
 // Summary holds the summarized results of a process run.
 type Summary struct {
-	FilesTransferred int
-	FilesDeleted     int
-	TotalBytes       int64
-	FilesFailed      []string
+	FilesTransferred      int
+	FilesDeleted          int
+	TotalBytes            int64
+	FilesFailed           []string
+	ExecDuration          time.Duration    // time spent waiting for s5cmd
+	ParseDuration         time.Duration    // time spent parsing the output and deleting files
+	KeyCollisions         int              // files skipped because their object key clashed with another file
+	KeysTooLong           int              // files skipped because their object key exceeded max-key-length
+	OwnerFiltered         int              // files left out because of their owner or group
+	UploadsFailed         []string         // files s5cmd failed to upload
+	FilesDeadLettered     int              // files moved to the dead-letter directory
+	ConsistencyViolations int              // runs that deleted more files than they transferred
+	FreeInodes            uint64           // free inodes on the spool filesystem at the end of the run
+	OpenFiles             int              // file descriptors open at the end of the run, 0 if unknown
+	InodePressure         bool             // free inodes were below the configured minimum
+	Retries               int              // attempts repeated after a retryable error
+	DeletesWithheld       int              // transferred files kept locally because the run had failures
+	FilesKeptBySize       int              // transferred files kept locally because of keep-larger-than
+	StableChanged         int              // originals kept because they changed after their stable copy
+	TokenWait             time.Duration    // time spent waiting for a coordination token
+	EmptyFilesSkipped     int              // zero-byte files not uploaded
+	MalformedLines        int              // s5cmd output lines that could not be parsed
+	SuspectRuns           int              // runs with more malformed output lines than allowed
+	SkippedExisting       int              // files not uploaded because their object already exists
+	SkippedDeleted        int              // of those, files deleted locally
+	Uploaded              []uploadedObject // files uploaded, collected for run manifests only
+	PendingDeletes        []pendingDelete  // files held back until the whole run succeeded, with atomic-delete only
+	CountDiscrepancy      int              // difference between the totals of s5cmd and the parsed records
+	BytesPlanned          int64            // bytes of the files enumerated for upload
+	BytesShortfall        int64            // bytes planned but not reported transferred by jobs without failures
+	FilesExpired          int              // files deleted without upload because of their age
+	FilesStuck            int              // files left out because they repeatedly failed to delete
+	DeleteQueueDepth      int              // files waiting in the delete queue at the end of the run
+	RetryBudget           *int             // retries left in the summary window, nil without a budget
+	Partitions            map[string]bool  // partitions files were transferred from
+	SourceVanished        int              // files removed by others between enumeration and upload
+	NoDestination         int              // runs skipped because the destination file was missing or invalid
+	EffectiveConcurrency  int              // concurrency reached by the ramp at the end of the run, 0 without a ramp
+	PartitionsTouched     int              // distinct partitions with transfers in the summary window
+	Percentiles           *runPercentiles  // throughput window percentiles, if enabled
+	Runtime               *runtimeStats    // health of the program, sampled once per summary window if enabled
+	JobIDs                []string         // s5cmd jobs of the run
+	ProgressLines         int              // progress and log records skipped in the s5cmd output
+	BatchDeferred         int              // runs held back because too few files were pending
+	InterBatchDelay       time.Duration    // pause between the invocations of a split run at its end, 0 without pacing
+	FailureReasons        map[string]int   // failed uploads per reason, bounded
+	DeleteReasons         map[string]int   // failed deletes per reason
+	BinaryRuns            map[string]int   // s5cmd runs per binary, if several are configured
+	BinaryFailures        map[string]int   // failed s5cmd runs per binary
+	BinaryFiles           map[string]int   // files transferred per binary
+	Throttled             int              // s5cmd invocations that failed because the endpoint throttled
+	StateCompacted        int              // state entries dropped because their file no longer exists
+	MountLost             bool             // the spool was missing for longer than the grace period
+	EmptyOutput           int              // s5cmd invocations that succeeded without writing any output
+	SpawnThrottled        int              // s5cmd starts delayed by the spawn rate limit
+	EmptySkipped          int              // runs skipped without starting s5cmd because no files matched
+	LoadThrottled         int              // runs skipped because the load average was too high
 }
 
-// getEnvOrFlag returns the environment variable value if set, otherwise returns the flag value
-func getEnvOrFlag(envKey string, flagValue string) string {
-	if envValue := os.Getenv(envKey); envValue != "" {
-		return envValue
+// merge adds the counters and failed files of other to s.
+func (s *Summary) merge(other Summary) {
+	s.FilesTransferred += other.FilesTransferred
+	s.FilesDeleted += other.FilesDeleted
+	s.TotalBytes += other.TotalBytes
+	s.FilesFailed = append(s.FilesFailed, other.FilesFailed...)
+	s.ExecDuration += other.ExecDuration
+	s.ParseDuration += other.ParseDuration
+	s.KeyCollisions += other.KeyCollisions
+	s.KeysTooLong += other.KeysTooLong
+	s.OwnerFiltered += other.OwnerFiltered
+	s.UploadsFailed = append(s.UploadsFailed, other.UploadsFailed...)
+	s.FailureReasons = addFailureReasons(s.FailureReasons, other.FailureReasons)
+	s.DeleteReasons = addFailureReasons(s.DeleteReasons, other.DeleteReasons)
+	s.BinaryRuns = addCounts(s.BinaryRuns, other.BinaryRuns)
+	s.BinaryFailures = addCounts(s.BinaryFailures, other.BinaryFailures)
+	s.BinaryFiles = addCounts(s.BinaryFiles, other.BinaryFiles)
+	s.Throttled += other.Throttled
+	s.StateCompacted += other.StateCompacted
+	s.MountLost = s.MountLost || other.MountLost
+	s.FilesDeadLettered += other.FilesDeadLettered
+	s.ConsistencyViolations += other.ConsistencyViolations
+	s.Retries += other.Retries
+	s.DeletesWithheld += other.DeletesWithheld
+	s.FilesKeptBySize += other.FilesKeptBySize
+	s.StableChanged += other.StableChanged
+	s.TokenWait += other.TokenWait
+	s.EmptyFilesSkipped += other.EmptyFilesSkipped
+	s.MalformedLines += other.MalformedLines
+	s.ProgressLines += other.ProgressLines
+	s.BatchDeferred += other.BatchDeferred
+	s.EmptyOutput += other.EmptyOutput
+	s.SpawnThrottled += other.SpawnThrottled
+	s.EmptySkipped += other.EmptySkipped
+	s.LoadThrottled += other.LoadThrottled
+	s.SuspectRuns += other.SuspectRuns
+	s.SkippedExisting += other.SkippedExisting
+	s.SkippedDeleted += other.SkippedDeleted
+	s.Uploaded = append(s.Uploaded, other.Uploaded...)
+	s.PendingDeletes = append(s.PendingDeletes, other.PendingDeletes...)
+	s.JobIDs = append(s.JobIDs, other.JobIDs...)
+	s.CountDiscrepancy += other.CountDiscrepancy
+	s.BytesPlanned += other.BytesPlanned
+	s.BytesShortfall += other.BytesShortfall
+	s.FilesExpired += other.FilesExpired
+	s.FilesStuck += other.FilesStuck
+	s.DeleteQueueDepth = max(s.DeleteQueueDepth, other.DeleteQueueDepth)
+	if other.RetryBudget != nil {
+		s.RetryBudget = other.RetryBudget
 	}
-	return flagValue
-}
-
-// getEnvOrFlagDuration returns the environment variable value as duration if set, otherwise returns the flag value
-func getEnvOrFlagDuration(envKey string, flagValue time.Duration) time.Duration {
-	if envValue := os.Getenv(envKey); envValue != "" {
-		if duration, err := time.ParseDuration(envValue); err == nil {
-			return duration
+	for partition := range other.Partitions {
+		if s.Partitions == nil {
+			s.Partitions = make(map[string]bool)
 		}
+		s.Partitions[partition] = true
+	}
+	s.PartitionsTouched = max(s.PartitionsTouched, other.PartitionsTouched, len(s.Partitions))
+	s.SourceVanished += other.SourceVanished
+	s.NoDestination += other.NoDestination
+	if other.EffectiveConcurrency > 0 {
+		s.EffectiveConcurrency = other.EffectiveConcurrency
+	}
+	if other.InterBatchDelay > 0 {
+		s.InterBatchDelay = other.InterBatchDelay
+	}
+	if other.Percentiles != nil {
+		s.Percentiles = other.Percentiles
+	}
+	if other.Runtime != nil {
+		s.Runtime = other.Runtime
+	}
+	if other.FreeInodes > 0 {
+		s.FreeInodes = other.FreeInodes
+		s.InodePressure = other.InodePressure
+	}
+	if other.OpenFiles > 0 {
+		s.OpenFiles = other.OpenFiles
 	}
-	return flagValue
 }
 
-// getEnvOrFlagBool returns the environment variable value as bool if set, otherwise returns the flag value
-func getEnvOrFlagBool(envKey string, flagValue bool) bool {
-	if envValue := os.Getenv(envKey); envValue != "" {
-		if envValue == "true" || envValue == "1" || envValue == "yes" {
-			return true
+func main() {
+	cfg := loadConfig()
+	// Everything logged from here on has the secrets of cfg masked
+	cfg.redactor = newRedactor(cfg)
+	log.SetOutput(redactingWriter{w: os.Stderr, redactor: cfg.redactor})
+	checkOpenFileLimit(cfg)
+
+	if cfg.ReplayDir != "" {
+		replay(cfg)
+		return
+	}
+	if cfg.InspectOutput != "" {
+		inspectOutput(cfg)
+		return
+	}
+
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	// Show the values as validation normalized them
+	if cfg.LogConfig {
+		logEffectiveConfig(resolveSettings(cfg, flag.CommandLine))
+	}
+	applyMemoryLimits(cfg)
+
+	if cfg.credsSource == nil && !cfg.HasAwsEnvCreds {
+		if err := checkCredsFilePerms(cfg.AwsCredsFile); err != nil {
+			if cfg.StrictCredsPerms {
+				log.Fatal(err)
+			}
+			log.Printf("Warning: %v", err)
 		}
-		if envValue == "false" || envValue == "0" || envValue == "no" {
-			return false
+	}
+
+	if cfg.Restore != "" {
+		if cfg.S3BucketPathFile != "" {
+			if err := refreshDestination(cfg); err != nil {
+				log.Fatal(err)
+			}
 		}
+		restore(cfg)
+		return
 	}
-	return flagValue
-}
 
-func main() {
-	// operational flags
-	folderPrefix := flag.String("folder-prefix", "/tmp/", "Folder prefix for files to be offloaded (env: FOLDER_PREFIX)")
-	pathSuffix := flag.String("path-suffix", "/**/**/*.gz", "the path suffix to use for glob matching (env: PATH_SUFFIX)")
-	processInterval := flag.Duration("process-interval", 1*time.Second, "The interval between processing runs (env: PROCESS_INTERVAL)")
-	netdataEnabled := flag.Bool("netdata-enabled", false, "Enable sending metrics to Netdata (env: NETDATA_ENABLED)")
-	netdataAddress := flag.String("netdata-address", "127.0.0.1:8125", "Netdata statsd address (UDP) (env: NETDATA_ADDRESS)")
-	s5cmdBinary := flag.String("s5cmd-binary", "s5cmd", "Full path to s5cmd binary (env: S5CMD_BINARY)")
-
-	// s3-like storage flags
-	s3BucketPath := flag.String("s3-bucket-path", "", "S3 bucket path (e.g., s3://my-bucket/path/) (env: S3_BUCKET_PATH)")
-	awsCredsFile := flag.String("aws-creds-file", "", "Path to AWS credentials file (env: AWS_CREDS_FILE)")
-	awsEndpointURL := flag.String("aws-endpoint-url", "", "Custom AWS endpoint (env: AWS_ENDPOINT_URL)")
-	awsProfile := flag.String("aws-profile", "default", "AWS profile to use from credentials file (env: AWS_PROFILE)")
-
-	flag.Parse()
-
-	// Get actual values from environment variables with flag fallbacks
-	actualFolderPrefix := getEnvOrFlag("FOLDER_PREFIX", *folderPrefix)
-	actualPathSuffix := getEnvOrFlag("PATH_SUFFIX", *pathSuffix)
-	actualProcessInterval := getEnvOrFlagDuration("PROCESS_INTERVAL", *processInterval)
-	actualNetdataEnabled := getEnvOrFlagBool("NETDATA_ENABLED", *netdataEnabled)
-	actualNetdataAddress := getEnvOrFlag("NETDATA_ADDRESS", *netdataAddress)
-	actualS5cmdBinary := getEnvOrFlag("S5CMD_BINARY", *s5cmdBinary)
-
-	// If AWS endpoint, creds file, profile, or S3 bucket path are set via env vars, override flags
-	actualAwsEndpointURL := getEnvOrFlag("AWS_ENDPOINT_URL", *awsEndpointURL)
-	actualAwsCredsFile := getEnvOrFlag("AWS_CREDS_FILE", *awsCredsFile)
-	actualAwsProfile := getEnvOrFlag("AWS_PROFILE", *awsProfile)
-	actualS3BucketPath := getEnvOrFlag("S3_BUCKET_PATH", *s3BucketPath)
-
-	// Check for AWS credentials in environment variables
-	awsAccessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
-	awsSecretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	awsDefaultRegion := os.Getenv("AWS_DEFAULT_REGION")
-	hasAwsEnvCreds := awsAccessKeyID != "" && awsSecretAccessKey != "" && awsDefaultRegion != ""
-
-	if actualS3BucketPath == "" {
-		log.Fatal("s3-bucket-path (or S3_BUCKET_PATH env var) is required")
-	}
-
-	if actualAwsCredsFile == "" && !hasAwsEnvCreds {
-		log.Fatal("Either aws-creds-file (or AWS_CREDS_FILE env var) or AWS environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_DEFAULT_REGION) are required")
+	state, err := loadStateStore(cfg.StateFile)
+	if err != nil {
+		log.Fatalf("Error loading state file: %v", err)
+	}
+	cfg.state = state
+	if cfg.AsyncDelete {
+		cfg.deleteQueue = newDeleteQueue(state, cfg.DeleteQueueSize)
+	}
+	if cfg.StableCopy {
+		cfg.stableLinks = newStableLinks()
 	}
 
 	// Log startup configuration
-	if hasAwsEnvCreds {
-		log.Printf("Using AWS credentials from environment variables (region: %s)", awsDefaultRegion)
+	if cfg.credsSource != nil {
+		log.Printf("Using AWS credentials from command: %s", cfg.CredsCommand)
+		// Fail now rather than on every run
+		if _, err := cfg.credsSource.get(); err != nil {
+			log.Fatal(err)
+		}
+	} else if cfg.HasAwsEnvCreds {
+		log.Printf("Using AWS credentials from environment variables (region: %s)", os.Getenv("AWS_DEFAULT_REGION"))
 	} else {
-		log.Printf("Using AWS credentials from file: %s", actualAwsCredsFile)
+		log.Printf("Using AWS credentials from file: %s", cfg.AwsCredsFile)
+	}
+	if cfg.binaries != nil {
+		log.Printf("Using s5cmd binaries: %s (%s)", strings.Join(cfg.binaries.binaries, ", "), cfg.BinarySelection)
+	} else {
+		log.Printf("Using s5cmd binary: %s", cfg.S5cmdBinary)
+	}
+	log.Printf("Instance ID: %s", cfg.InstanceID)
+	if cfg.MaxUploadAttempts > 0 {
+		log.Printf("Moving files to %s after %d failed upload attempts", cfg.DeadLetterDir, cfg.MaxUploadAttempts)
+	}
+	if cfg.MaxAgeDelete > 0 {
+		log.Printf("WARNING: expiry is enabled, files older than %v are deleted WITHOUT being uploaded", cfg.MaxAgeDelete)
+	}
+	if cfg.SplitBySubdir {
+		log.Printf("Splitting runs by subdirectory with up to %d concurrent s5cmd invocations", cfg.SubdirConcurrency)
+	}
+
+	// Rather fail now than with files of the first run stuck in the spool
+	if cfg.VerifyWrite {
+		if cfg.S3BucketPathFile != "" {
+			if err := refreshDestination(cfg); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := verifyWriteAccess(cfg); err != nil {
+			log.Fatalf("Write access check failed: %v", err)
+		}
+	}
+
+	// Every enabled sink receives the same summaries. Closing them flushes
+	// queued metrics.
+	metrics, err := newMetricSinks(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer metrics.Close()
+	if err := metrics.Started(); err != nil {
+		log.Printf("Error sending start metrics: %v", err)
 	}
-	log.Printf("Using s5cmd binary: %s", actualS5cmdBinary)
 
 	// Set up graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if cfg.ProgressInterval > 0 {
+		cfg.progress = newRunProgress()
+		go cfg.progress.report(ctx, cfg.ProgressInterval, metrics)
+	}
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// A second signal forces an exit or, if configured, aborts draining the
+	// backlog on shutdown and leaves the forced exit to a third one
+	drainCtx, abortDrain := context.WithCancel(context.Background())
+	defer abortDrain()
+
 	// Start shutdown handler in a goroutine
-	go func() {
-		sig := <-sigChan
-		log.Printf("Received signal %v, initiating graceful shutdown...", sig)
-		cancel()
-	}()
+	cfg.jobs = newJobTracker()
+	go watchSignals(ctx, cfg, sigChan, cancel, abortDrain, func() { forceExit(cfg, metrics) })
+
+	// Shut down through the same path once the maximum runtime is reached
+	if cfg.MaxRuntime > 0 {
+		runtimeTimer := time.AfterFunc(cfg.MaxRuntime, func() {
+			log.Printf("Maximum runtime of %v reached, initiating graceful shutdown...", cfg.MaxRuntime)
+			cancel()
+		})
+		defer runtimeTimer.Stop()
+	}
 
 	loggingInterval := 1 * time.Minute
-	runsPerLog := int(loggingInterval / actualProcessInterval)
+	runsPerLog := int(loggingInterval / cfg.ProcessInterval)
 	if runsPerLog < 1 {
 		runsPerLog = 1
 	}
+	// With a tiny interval, bound what accumulates before the window is flushed
+	if cfg.MaxAccumulatedRuns > 0 && runsPerLog > cfg.MaxAccumulatedRuns {
+		runsPerLog = cfg.MaxAccumulatedRuns
+		loggingInterval = time.Duration(runsPerLog) * cfg.ProcessInterval
+	}
 
 	var accumulatedSummary Summary
 	runCounter := 0
-	ticker := time.NewTicker(actualProcessInterval)
-	defer ticker.Stop()
+	var sessionSummary Summary
+	sessionRuns := 0
+	startedAt := time.Now()
+	warmedUp := false
+	var window *throughputWindow
+	if cfg.ThroughputWindow > 0 {
+		window = newThroughputWindow(cfg.ThroughputWindow, float64(cfg.DegradedPercent)/100)
+	}
+	var ticks <-chan time.Time
+	switch {
+	case cfg.Once:
+		// Never ticks, the single run happens right away
+	case cfg.AlignInterval:
+		ticker := newAlignedTicker(cfg.ProcessInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	default:
+		ticker := time.NewTicker(cfg.ProcessInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	// run performs a single run and reports it. Cancelling runCtx cuts short
+	// the backoff before a retry.
+	run := func(runCtx context.Context) {
+		// processFiles covers the whole run: s5cmd, parsing and deletion. Runs
+		// never overlap, ticks that fire while it is busy are dropped by the ticker.
+		cfg.progress.begin()
+		summary, err := processFiles(runCtx, cfg)
+		cfg.progress.end()
+		if err != nil {
+			log.Printf("Error processing files: %v", err)
+		}
+		if cfg.HeartbeatObject != "" {
+			writeHeartbeat(cfg, &summary)
+		}
+		if window != nil {
+			summary.Percentiles = window.observe(&summary)
+		}
+		// Reported with the run closing the summary window
+		if cfg.RuntimeMetrics && runCounter+1 >= runsPerLog {
+			summary.Runtime = readRuntimeStats()
+		}
+		accumulatedSummary.merge(summary)
+		summary.PartitionsTouched = len(accumulatedSummary.Partitions)
+		if summary.MountLost && cfg.ExitOnMountLoss && ctx.Err() == nil {
+			log.Println("Spool is lost, shutting down so that the orchestrator can restart or reschedule...")
+			cancel()
+		}
+
+		// Report individual run metrics immediately. This happens on every tick,
+		// also for no-match and failed runs, so the heartbeat shows liveness.
+		// Runs completing during the warmup are not reported.
+		if time.Since(startedAt) >= cfg.MetricsWarmup {
+			if !warmedUp && cfg.MetricsWarmup > 0 {
+				log.Printf("Metrics warmup of %v is over, sending metrics", cfg.MetricsWarmup)
+			}
+			warmedUp = true
+			if err := metrics.RunCompleted(&summary, 1); err != nil {
+				log.Printf("Error sending metrics: %v", err)
+			}
+		}
+
+		if cfg.ShutdownReport != "" || cfg.OutputJSON {
+			sessionSummary.merge(summary)
+		}
+		sessionRuns++
+
+		runCounter++
+
+		if runCounter >= runsPerLog {
+			if accumulatedSummary.FilesTransferred > 0 {
+				totalMegabytes := float64(accumulatedSummary.TotalBytes) / (1024 * 1024)
+				log.Printf(
+					"Summary over last %d runs (~%v): %d files transferred, %d files deleted, %.2f MB, %d files failed to delete. Time in s5cmd: %v, parsing and deleting: %v.",
+					runCounter,
+					loggingInterval,
+					accumulatedSummary.FilesTransferred,
+					accumulatedSummary.FilesDeleted,
+					totalMegabytes,
+					len(accumulatedSummary.FilesFailed),
+					accumulatedSummary.ExecDuration.Round(time.Millisecond),
+					accumulatedSummary.ParseDuration.Round(time.Millisecond),
+				)
+			} else if cfg.LogIdleSummaries {
+				// Shows liveness in the logs alone during long idle periods
+				log.Printf("Idle: 0 files over last %d runs (~%v)", runCounter, loggingInterval)
+			}
+			logFailedFiles(cfg, &accumulatedSummary)
+			if accumulatedSummary.Runtime != nil {
+				accumulatedSummary.Runtime.log()
+			}
+			runCounter = 0
+			accumulatedSummary = Summary{}
+			if cfg.retryBudget != nil {
+				cfg.retryBudget.reset()
+			}
+		}
+	}
+
+	if cfg.Once {
+		log.Println("s5-commander started, processing once")
+	} else if cfg.AlignInterval {
+		log.Printf("s5-commander started, processing every %v aligned to the clock", cfg.ProcessInterval)
+	} else {
+		log.Printf("s5-commander started, processing every %v", cfg.ProcessInterval)
+	}
+
+	// Tell init systems and sidecars waiting for the file that startup succeeded
+	if cfg.ReadyFile != "" {
+		if err := writeFileAtomic(cfg.ReadyFile, []byte(fmt.Sprintf("%d\n", os.Getpid()))); err != nil {
+			log.Fatalf("Error writing ready file: %v", err)
+		}
+	}
 
-	log.Printf("s5-commander started, processing every %v", actualProcessInterval)
+	// Drain an existing backlog right away instead of waiting for the first tick
+	if cfg.RunOnStart || cfg.Once {
+		run(ctx)
+	}
+	// A single run shuts down through the same path as a signal
+	if cfg.Once {
+		cancel()
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Shutdown signal received, finishing current operations...")
+			if cfg.ReadyFile != "" {
+				if err := os.Remove(cfg.ReadyFile); err != nil {
+					log.Printf("Error removing ready file: %v", err)
+				}
+			}
+			if !cfg.Once {
+				log.Println("Shutdown signal received, finishing current operations...")
+			}
+
+			if cfg.DrainOnShutdown && !cfg.mountLost {
+				// The backlog is drained regardless of the batch size and load
+				cfg.batchGate = nil
+				cfg.MaxLoadAverage = 0
+				drain(drainCtx, cfg, run)
+			}
+
+			// Wait for the deletes still queued and account for them
+			if cfg.deleteQueue != nil {
+				log.Println("Waiting for queued deletes to finish...")
+				cfg.deleteQueue.Close()
+				finished := cfg.deleteQueue.collect()
+				accumulatedSummary.merge(finished)
+				sessionSummary.merge(finished)
+				if err := cfg.state.save(); err != nil {
+					log.Printf("Error saving state: %v", err)
+				}
+			}
 
 			// Send any accumulated metrics before shutdown
-			if actualNetdataEnabled && (accumulatedSummary.FilesTransferred > 0 || runCounter > 0) {
-				if err := sendShutdownMetrics(actualNetdataAddress, &accumulatedSummary, runCounter); err != nil {
-					log.Printf("Error sending final metrics to Netdata: %v", err)
+			if len(metrics) > 0 && (accumulatedSummary.FilesTransferred > 0 || runCounter > 0) {
+				if err := metrics.Shutdown(&accumulatedSummary, runCounter); err != nil {
+					log.Printf("Error sending final metrics: %v", err)
 				} else {
-					log.Println("Final metrics sent to Netdata")
+					log.Println("Final metrics sent")
 				}
 			}
 
@@ -191,94 +523,435 @@ func main() {
 					len(accumulatedSummary.FilesFailed),
 					runCounter,
 				)
+				logFailedFiles(cfg, &accumulatedSummary)
 			}
 
-			log.Println("s5-commander shutdown complete")
-			return
-
-		case <-ticker.C:
-			summary, err := processFiles(
-				actualFolderPrefix,
-				actualAwsEndpointURL,
-				actualS3BucketPath,
-				actualAwsCredsFile,
-				actualAwsProfile,
-				actualPathSuffix,
-				actualS5cmdBinary,
-				hasAwsEnvCreds,
-			)
-			if err != nil {
-				log.Printf("Error processing files: %v", err)
+			if cfg.ShutdownReport != "" {
+				report := newShutdownReport(&sessionSummary, sessionRuns, startedAt, cfg.InstanceID, cfg.FailedLogSample)
+				if err := writeShutdownReport(cfg.ShutdownReport, report); err != nil {
+					log.Printf("Error writing shutdown report: %v", err)
+				} else {
+					log.Printf("Shutdown report written to %s", cfg.ShutdownReport)
+				}
 			}
 
-			// Send individual run metrics to Netdata immediately
-			if actualNetdataEnabled {
-				if err := sendToNetdata(actualNetdataAddress, &summary, 1); err != nil {
-					log.Printf("Error sending metrics to Netdata: %v", err)
+			if cfg.OutputJSON {
+				if err := writeRunOutput(os.Stdout, newRunOutput(&sessionSummary, sessionRuns, startedAt)); err != nil {
+					log.Printf("Error writing JSON output: %v", err)
 				}
 			}
 
-			accumulatedSummary.FilesTransferred += summary.FilesTransferred
-			accumulatedSummary.FilesDeleted += summary.FilesDeleted
-			accumulatedSummary.TotalBytes += summary.TotalBytes
-			accumulatedSummary.FilesFailed = append(accumulatedSummary.FilesFailed, summary.FilesFailed...)
-
-			runCounter++
-
-			if runCounter >= runsPerLog {
-				if accumulatedSummary.FilesTransferred > 0 {
-					totalMegabytes := float64(accumulatedSummary.TotalBytes) / (1024 * 1024)
-					log.Printf(
-						"Summary over last %d runs (~%v): %d files transferred, %d files deleted, %.2f MB, %d files failed to delete.",
-						runCounter,
-						loggingInterval,
-						accumulatedSummary.FilesTransferred,
-						accumulatedSummary.FilesDeleted,
-						totalMegabytes,
-						len(accumulatedSummary.FilesFailed),
-					)
-				}
-				runCounter = 0
-				accumulatedSummary = Summary{}
+			log.Println("s5-commander shutdown complete")
+			if cfg.mountLost && cfg.ExitOnMountLoss {
+				// Deferred calls don't run on exit
+				metrics.Close()
+				os.Exit(1)
 			}
+			return
+
+		case <-ticks:
+			run(ctx)
 		}
 	}
 }
 
-func processFiles(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfile, pathSuffix, s5cmdBinary string, hasAwsEnvCreds bool) (Summary, error) {
-	jobID, err := uuid.NewRandom()
+// processFiles performs a run, retrying it on the error classes configured as
+// retryable, and handles the files that failed to upload. No retry is started
+// once ctx is cancelled, also when it is cancelled during the backoff.
+func processFiles(ctx context.Context, cfg *Config) (Summary, error) {
+	var summary Summary
+
+	// Uploading from an unmounted spool would only report nothing to do
+	if !spoolPresent(cfg, &summary) {
+		return summary, nil
+	}
+
+	// Yield to whatever keeps the host busy
+	if loadTooHigh(cfg) {
+		summary.LoadThrottled++
+		return summary, nil
+	}
+
+	// Let small batches accumulate instead of paying for a run per file, and
+	// don't start s5cmd only to learn that there is nothing to upload
+	if cfg.batchGate != nil || cfg.SkipEmptyRuns {
+		pending, err := countPendingFiles(cfg)
+		if err != nil {
+			return summary, fmt.Errorf("error counting pending files: %w", err)
+		}
+		if pending == 0 && cfg.SkipEmptyRuns {
+			summary.EmptySkipped++
+		}
+		if cfg.batchGate != nil && !cfg.batchGate.admit(pending, time.Now()) {
+			if pending > 0 {
+				summary.BatchDeferred++
+			}
+			return summary, nil
+		}
+		if pending == 0 {
+			return summary, nil
+		}
+	}
+
+	// Rather skip the run than upload to a stale destination
+	if cfg.S3BucketPathFile != "" {
+		if err := refreshDestination(cfg); err != nil {
+			summary.NoDestination++
+			return summary, fmt.Errorf("skipping run: %w", err)
+		}
+	}
+
+	// Wait for a token shared with cooperating processes, or skip the run
+	if cfg.CoordinationLock != "" {
+		release, waited, err := acquireToken(cfg)
+		summary.TokenWait = waited
+		if err != nil {
+			return summary, err
+		}
+		defer release()
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var attemptSummary Summary
+		attemptSummary, err = runAttempt(ctx, cfg)
+		if cfg.AtomicDelete {
+			applyAtomicDelete(cfg, &attemptSummary, err)
+		}
+		// A retry goes over every file left in the spool again, the failures
+		// of the last attempt are the ones that count towards the dead letter
+		summary.UploadsFailed = nil
+		summary.merge(attemptSummary)
+
+		if err == nil || attempt >= cfg.MaxRetries {
+			break
+		}
+		class := classOf(err)
+		// Rejected credentials may have been revoked before they expired
+		if class == errorClassAuth && cfg.credsSource != nil {
+			cfg.credsSource.invalidate()
+		}
+		if !cfg.retryableErrors[class] {
+			break
+		}
+		if cfg.retryBudget != nil && !cfg.retryBudget.take() {
+			log.Printf("Run failed with %s error, not retrying as the retry budget of the summary window is used up: %v", class, err)
+			break
+		}
+
+		backoff := cfg.RetryBackoff << attempt
+		if class == errorClassThrottled {
+			// Retrying soon would only prolong the throttling
+			backoff = max(cfg.ThrottleBackoff<<attempt, retryAfterOf(err), backoff)
+		}
+		log.Printf("Run failed with %s error, retrying in %v (retry %d of %d): %v", class, backoff, attempt+1, cfg.MaxRetries, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			log.Println("Shutting down, giving up on the failed run instead of retrying it")
+			break
+		}
+		summary.Retries++
+	}
+
+	// Runs with failed uploads get a manifest too, their other files are gone
+	// from the spool already.
+	if cfg.ManifestPrefix != "" && summary.FilesTransferred > 0 {
+		writeManifest(cfg, &summary)
+	}
+	summary.Uploaded = nil
+
+	// Deletes finishing in the background are reported with the run that
+	// collects them
+	if cfg.deleteQueue != nil {
+		summary.merge(cfg.deleteQueue.collect())
+	}
+	if cfg.spawnLimiter != nil {
+		summary.SpawnThrottled += cfg.spawnLimiter.collect()
+	}
+
+	handleUploadFailures(cfg, &summary)
+	handleDeleteFailures(cfg, &summary)
+	checkFreeInodes(cfg, &summary)
+	if open, err := openFileCount(); err == nil {
+		summary.OpenFiles = open
+	}
+	if cfg.StateCompaction > 0 {
+		summary.StateCompacted = cfg.state.compact(cfg.StateCompaction)
+	}
+	if cfg.retryBudget != nil {
+		remaining := cfg.retryBudget.remaining()
+		summary.RetryBudget = &remaining
+	}
+	if saveErr := cfg.state.save(); saveErr != nil {
+		log.Printf("Error saving state: %v", saveErr)
+	}
+
+	return summary, err
+}
+
+// runAttempt runs s5cmd once over the spool, split by subdirectory if configured.
+func runAttempt(ctx context.Context, cfg *Config) (Summary, error) {
+	jobID, err := newJobID(cfg)
 	if err != nil {
-		return Summary{}, fmt.Errorf("error generating job ID: %v", err)
+		return Summary{}, err
 	}
 
+	if cfg.SplitBySubdir {
+		return processSubdirs(ctx, cfg, jobID)
+	}
+	return runJob(ctx, cfg, jobID, sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), cfg.S3BucketPath)
+}
+
+// runJob runs a single s5cmd copy from srcPath to destPath and cleans up the
+// transferred files. The s5cmd output is written to <jobID>.json, and its
+// stderr to <jobID>.stderr.json when separate stderr is enabled.
+func runJob(ctx context.Context, cfg *Config, jobID, srcPath, destPath string) (Summary, error) {
+	jobID, err := reserveJob(cfg, jobID)
+	if err != nil {
+		return Summary{}, err
+	}
+	defer cfg.jobs.release(jobID)
+
 	jsonOutputFile := fmt.Sprintf("%s.json", jobID)
 	defer os.Remove(jsonOutputFile)
 
-	err = runS5cmd(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfile, jsonOutputFile, pathSuffix, s5cmdBinary, hasAwsEnvCreds)
-	if err != nil {
-		isNoMatchError, _ := checkForNoMatchError(jsonOutputFile)
+	errorOutputFile := jsonOutputFile
+	if cfg.SeparateStderr {
+		errorOutputFile = fmt.Sprintf("%s.stderr.json", jobID)
+		defer os.Remove(errorOutputFile)
+	}
+	outputFiles := []string{jsonOutputFile}
+	if errorOutputFile != jsonOutputFile {
+		outputFiles = append(outputFiles, errorOutputFile)
+	}
+
+	// Archived whatever the outcome once s5cmd ran, the output of failed runs
+	// is the one most worth keeping
+	archive := false
+	if cfg.OutputArchiveDir != "" {
+		defer func() {
+			if archive {
+				archiveOutputs(cfg, jobID, outputFiles)
+			}
+		}()
+	}
+
+	operation := append(append([]string{"cp"}, cpOptions(cfg)...), srcPath, destPath)
+	planned := Summary{JobIDs: []string{jobID}}
+	var stream *uploadStream
+	plannedFiles := 0 // known up front only in per-file mode without pipelining
+	if cfg.perFileMode() {
+		commandsFile := fmt.Sprintf("%s.commands", jobID)
+		defer os.Remove(commandsFile)
+
+		if cfg.StableCopy {
+			defer removeStableDir(cfg, jobID)
+		}
+
+		if cfg.PipelineUploads {
+			// Planned while s5cmd runs, see pipeline.go
+			stream = startUploadStream(cfg, jobID, srcPath)
+			operation = []string{"run"}
+		} else {
+			commands, plan, err := planUploads(cfg, jobID, srcPath, commandsFile)
+			planned.merge(plan)
+			if err != nil {
+				return planned, fmt.Errorf("error planning uploads for job %s: %w", jobID, err)
+			}
+			if commands == 0 {
+				return planned, nil
+			}
+			plannedFiles = commands
+			operation = []string{"run", commandsFile}
+		}
+	}
+
+	// Jobs alternate between the binaries if several are configured
+	binary := cfg.S5cmdBinary
+	if cfg.binaries != nil {
+		binary = cfg.binaries.pick()
+		planned.BinaryRuns = map[string]int{cfg.binaries.tags[binary]: 1}
+	}
+
+	watch := cfg.progress.startJob(cfg, plannedFiles)
+	defer cfg.progress.finishJob()
+
+	execStart := time.Now()
+	if stream != nil {
+		err = runS5cmdInput(ctx, cfg, binary, operation, stream, watch, cfg.FailFast, jsonOutputFile, errorOutputFile)
+		commands, plan, planErr := stream.finish()
+		planned.merge(plan)
+		if planErr != nil {
+			return planned, fmt.Errorf("error planning uploads for job %s: %w", jobID, planErr)
+		}
+		if commands == 0 {
+			return planned, nil
+		}
+	} else {
+		err = runS5cmdInput(ctx, cfg, binary, operation, nil, watch, cfg.FailFast, jsonOutputFile, errorOutputFile)
+	}
+	planned.ExecDuration = time.Since(execStart)
+	archive = true
+	if errors.Is(err, errFailedFast) {
+		// What was uploaded up to the failure is cleaned up as usual, the
+		// rest is left for the next run instead of being retried
+		log.Printf("Warning: job %s stopped at its first failed upload", jobID)
+	}
+	var runErr *runError
+	if err != nil && !errors.Is(err, errFailedFast) {
+		isNoMatchError, _ := checkForNoMatchError(cfg, errorOutputFile)
 		if isNoMatchError {
 			// Don't log anything here, it's normal to have no files.
-			return Summary{}, nil
+			archive = false
+			return planned, nil
+		}
+		// A run failing only on files removed by others since they were
+		// matched did all it could, it is parsed like a successful one
+		class := classifyOutput(cfg, errorOutputFile)
+		if class != errorClassVanished {
+			runErr = &runError{
+				class: class,
+				err:   fmt.Errorf("error running s5cmd for job %s: %w", jobID, err),
+			}
+			if class == errorClassThrottled {
+				planned.Throttled++
+				runErr.retryAfter = retryAfterHint(cfg, errorOutputFile)
+			}
+			if cfg.binaries != nil {
+				planned.BinaryFailures = map[string]int{cfg.binaries.tags[binary]: 1}
+			}
+			// s5cmd didn't get to write anything, e.g. it failed to start
+			if _, statErr := os.Stat(jsonOutputFile); statErr != nil {
+				return planned, runErr
+			}
 		}
-		return Summary{}, fmt.Errorf("error running s5cmd for job %s: %w", jobID, err)
 	}
 
-	summary, err := parseAndCleanup(folderPrefix, jsonOutputFile)
+	parseStart := time.Now()
+	// s5cmd reports a wildcard matching nothing as an error, so a successful
+	// run without a single record is unexpected
+	if err == nil && outputEmpty(outputFiles) {
+		planned.EmptyOutput++
+		if cfg.Verbose {
+			log.Printf("s5cmd succeeded for job %s without writing any output", jobID)
+		}
+	}
+	// s5cmd exits non-zero as soon as a single copy failed, the output of
+	// such a run is parsed all the same so that what it did transfer is
+	// cleaned up and what failed is accounted for
+	summary, err := parseAndCleanup(cfg, outputFiles...)
+	planned.ParseDuration = time.Since(parseStart)
+	summary.merge(planned)
 	if err != nil {
-		return Summary{}, fmt.Errorf("error parsing results and cleaning up for job %s: %w", jobID, err)
+		return summary, fmt.Errorf("error parsing results and cleaning up for job %s: %w", jobID, err)
+	}
+	if runErr != nil {
+		return summary, runErr
+	}
+	if cfg.ShortfallPercent > 0 {
+		checkBytesShortfall(cfg, jobID, &summary)
+	}
+	if cfg.binaries != nil {
+		summary.BinaryFiles = map[string]int{cfg.binaries.tags[binary]: summary.FilesTransferred}
 	}
 
 	return summary, nil
 }
 
-func runS5cmd(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfile, jsonOutputFile, pathSuffix, s5cmdBinary string, hasAwsEnvCreds bool) error {
+// jobFiles returns the work files of jobID: its s5cmd output, the s5cmd stderr
+// if written separately and the commands file in per-file mode.
+func jobFiles(cfg *Config, jobID string) []string {
+	files := []string{fmt.Sprintf("%s.json", jobID)}
+	if cfg.SeparateStderr {
+		files = append(files, fmt.Sprintf("%s.stderr.json", jobID))
+	}
+	if cfg.perFileMode() {
+		files = append(files, fmt.Sprintf("%s.commands", jobID))
+	}
+	return files
+}
+
+// reserveJob creates the work files of jobID exclusively, so that a job never
+// truncates the files of another one. If any of them exists already, it retries
+// with a fresh ID. It returns the ID whose files were created.
+func reserveJob(cfg *Config, jobID string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		files := jobFiles(cfg, jobID)
+		err := createExclusive(files...)
+		if err == nil {
+			if cfg.StableCopy {
+				files = append(files, stableJobDir(cfg, jobID))
+			}
+			cfg.jobs.reserve(jobID, files)
+			return jobID, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || attempt >= 2 {
+			return "", fmt.Errorf("error creating work files for job %s: %w", jobID, err)
+		}
+
+		log.Printf("Warning: work files of job %s exist already, retrying with a new job ID", jobID)
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return "", fmt.Errorf("error generating job ID: %v", err)
+		}
+		jobID = id.String()
+	}
+}
+
+// sourcePattern joins the folder prefix and the glob path suffix into the s5cmd source argument.
+func sourcePattern(folderPrefix, pathSuffix string) string {
 	if len(pathSuffix) > 0 && pathSuffix[0] == '/' {
 		pathSuffix = pathSuffix[1:]
 	}
-	srcPath := filepath.Join(folderPrefix, pathSuffix)
-	destPath := s3BucketPath
+	return filepath.Join(folderPrefix, pathSuffix)
+}
+
+// cpOptions returns the options passed to every s5cmd cp command.
+func cpOptions(cfg *Config) []string {
+	var options []string
+	if cfg.MultipartSize > 0 {
+		// s5cmd expects the part size in MiB
+		partSize := (cfg.MultipartSize + mebibyte - 1) / mebibyte
+		options = append(options, "--part-size", strconv.FormatInt(partSize, 10))
+	}
+	if cfg.MultipartConcurrency > 0 {
+		options = append(options, "--concurrency", strconv.Itoa(cfg.MultipartConcurrency))
+	}
+	if cfg.NoOverwrite {
+		options = append(options, "--no-clobber")
+	}
+	if cfg.ObjectLockMode != "" {
+		options = append(options,
+			"--object-lock-mode", cfg.ObjectLockMode,
+			"--object-lock-retain-until-date", cfg.ObjectLockRetainUntil,
+		)
+	}
+	if len(cfg.objectTags) > 0 {
+		options = append(options, "--tagging", taggingArg(cfg.objectTags))
+	}
+	return options
+}
+
+// runS5cmd runs the s5cmd operation (e.g. cp <src> <dest>) and writes its stdout
+// to jsonOutputFile and its stderr to errorOutputFile. Both may name the same
+// file, in which case the streams are merged. fail-fast only applies to the
+// upload runs, the s5cmd operations run here always complete.
+func runS5cmd(cfg *Config, operation []string, jsonOutputFile, errorOutputFile string) error {
+	return runS5cmdInput(context.Background(), cfg, cfg.s5cmdBinary(), operation, nil, nil, false, jsonOutputFile, errorOutputFile)
+}
 
+// runS5cmdInput is runS5cmd running the given s5cmd binary with stdin
+// connected to it, e.g. for run commands streamed while s5cmd is already
+// working on them. If watch is set, it is called with every output line as
+// soon as s5cmd writes it. If failFast is set, s5cmd is stopped at its first
+// failed upload and errFailedFast returned. s5cmd is killed once the deadline
+// of ctx is exceeded.
+func runS5cmdInput(ctx context.Context, cfg *Config, binary string, operation []string, stdin io.Reader, watch func(line []byte), failFast bool, jsonOutputFile, errorOutputFile string) error {
 	var cmd *exec.Cmd
 
 	// build default arguments
@@ -286,16 +959,31 @@ func runS5cmd(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfi
 		"--json",
 		"--log", LogLevel,
 	}
+	if cfg.NoOverwrite {
+		// s5cmd reports objects skipped by --no-clobber at debug level only
+		cmdArguments[len(cmdArguments)-1] = "debug"
+	}
+	if cfg.CrossCheckStats {
+		cmdArguments = append(cmdArguments, "--stat")
+	}
 
 	// if we have an endpoint provided, add it to the arguments
-	if awsEndpointURL != "" {
-		cmdArguments = append(cmdArguments, "--endpoint-url", awsEndpointURL)
+	if cfg.AwsEndpointURL != "" {
+		cmdArguments = append(cmdArguments, "--endpoint-url", cfg.AwsEndpointURL)
 	}
 
-	// build the full command based on whether we have env creds or file creds
-	if hasAwsEnvCreds {
-		cmdArguments = append(cmdArguments, "cp", srcPath, destPath)
-		cmd = exec.Command(s5cmdBinary, cmdArguments...)
+	// build the full command based on where the credentials come from
+	if cfg.credsSource != nil {
+		creds, err := cfg.credsSource.get()
+		if err != nil {
+			return err
+		}
+		cmdArguments = append(cmdArguments, operation...)
+		cmd = s5cmdCommand(cfg, binary, cmdArguments)
+		cmd.Env = append(os.Environ(), creds.env()...)
+	} else if cfg.HasAwsEnvCreds {
+		cmdArguments = append(cmdArguments, operation...)
+		cmd = s5cmdCommand(cfg, binary, cmdArguments)
 		cmd.Env = append(os.Environ(),
 			fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", os.Getenv("AWS_ACCESS_KEY_ID")),
 			fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", os.Getenv("AWS_SECRET_ACCESS_KEY")),
@@ -303,16 +991,17 @@ func runS5cmd(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfi
 		)
 	} else {
 		cmdArguments = append(cmdArguments,
-			"--credentials-file", awsCredsFile,
-			"--profile", awsProfile,
-			"cp",
-			srcPath,
-			destPath,
+			"--credentials-file", cfg.AwsCredsFile,
+			"--profile", cfg.AwsProfile,
 		)
-		cmd = exec.Command(s5cmdBinary, cmdArguments...)
+		cmdArguments = append(cmdArguments, operation...)
+		cmd = s5cmdCommand(cfg, binary, cmdArguments)
 		cmd.Env = os.Environ()
 	}
 
+	cmd.Env = append(cmd.Env, tlsEnv(cfg)...)
+	cmd.Stdin = stdin
+
 	// redirect output to the JSON output file
 	outputFile, err := os.Create(jsonOutputFile)
 	if err != nil {
@@ -323,47 +1012,79 @@ func runS5cmd(folderPrefix, awsEndpointURL, s3BucketPath, awsCredsFile, awsProfi
 	cmd.Stdout = outputFile
 	cmd.Stderr = outputFile
 
-	// log.Printf("Running command: %s", cmd.String())
-	return cmd.Run()
-}
+	if errorOutputFile != jsonOutputFile {
+		errorFile, err := os.Create(errorOutputFile)
+		if err != nil {
+			return fmt.Errorf("error creating stderr output file: %w", err)
+		}
+		defer errorFile.Close()
 
-func sendToNetdata(address string, summary *Summary, runCount int) error {
-	conn, err := net.Dial("udp", address)
-	if err != nil {
-		return fmt.Errorf("failed to connect to Netdata at %s: %w", address, err)
+		cmd.Stderr = errorFile
 	}
-	defer conn.Close()
 
-	// Calculate derived metrics
-	megabytesTransferred := float64(summary.TotalBytes) / (1024 * 1024)
-	successRate := 0.0
-	if summary.FilesTransferred > 0 {
-		successRate = float64(summary.FilesDeleted) / float64(summary.FilesTransferred) * 100.0
+	// Watch the output as it is written to stop s5cmd at the first failure
+	var watcher *failWatcher
+	if failFast {
+		watcher = newFailWatcher(cfg, func() { cmd.Process.Kill() })
+		stdout := io.MultiWriter(cmd.Stdout, watcher.stream())
+		if cmd.Stderr == cmd.Stdout {
+			cmd.Stderr = stdout
+		} else {
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, watcher.stream())
+		}
+		cmd.Stdout = stdout
 	}
-
-	metrics := []string{
-		// Current run metrics (these reset each run)
-		fmt.Sprintf("s5commander.current.files_transferred:%d|g", summary.FilesTransferred),
-		fmt.Sprintf("s5commander.current.files_deleted:%d|g", summary.FilesDeleted),
-		fmt.Sprintf("s5commander.current.megabytes_transferred:%.2f|g", megabytesTransferred),
-		fmt.Sprintf("s5commander.current.files_failed_delete:%d|g", len(summary.FilesFailed)),
-		fmt.Sprintf("s5commander.current.success_rate:%.2f|g", successRate),
-
-		// Operational metrics
-		fmt.Sprintf("s5commander.runs_completed:%d|c", runCount),
-		fmt.Sprintf("s5commander.last_activity:%d|g", time.Now().Unix()),
+	// and count its records for progress reports
+	if watch != nil {
+		stdout := io.MultiWriter(cmd.Stdout, &lineWriter{check: watch})
+		if cmd.Stderr == cmd.Stdout {
+			cmd.Stderr = stdout
+		} else {
+			cmd.Stderr = io.MultiWriter(cmd.Stderr, &lineWriter{check: watch})
+		}
+		cmd.Stdout = stdout
 	}
 
-	for _, metric := range metrics {
-		_, err := fmt.Fprint(conn, metric)
-		if err != nil {
-			// Log individual metric send errors if needed, but for UDP it's often fire-and-forget
+	if cfg.Verbose {
+		log.Printf("Running command: %s", redactedCommand(cmd.Args))
+	}
+	// Wait for the rate limit first, a slot would be idle meanwhile
+	if cfg.spawnLimiter != nil {
+		cfg.spawnLimiter.wait()
+	}
+	if cfg.s5cmdSlots != nil {
+		cfg.s5cmdSlots <- struct{}{}
+		defer func() { <-cfg.s5cmdSlots }()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	cfg.jobs.started(cmd.Process)
+	// Cancelling ctx only keeps further work from starting, s5cmd is killed
+	// once its deadline is exceeded
+	stop := context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Killing s5cmd, the deadline of the run is exceeded")
+			cmd.Process.Kill()
 		}
+	})
+	err = cmd.Wait()
+	stop()
+	cfg.jobs.exited(cmd.Process)
+	if watcher != nil && watcher.tripped() {
+		return errFailedFast
 	}
-	return nil
+	return err
 }
 
-func checkForNoMatchError(jsonOutputFile string) (bool, error) {
+// s5cmdCommand returns the command running the s5cmd binary with args, at the
+// configured priority.
+func s5cmdCommand(cfg *Config, binary string, args []string) *exec.Cmd {
+	argv := priorityArgs(cfg, append([]string{binary}, args...))
+	return exec.Command(argv[0], argv[1:]...)
+}
+
+func checkForNoMatchError(cfg *Config, jsonOutputFile string) (bool, error) {
 	file, err := os.Open(jsonOutputFile)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -382,87 +1103,274 @@ func checkForNoMatchError(jsonOutputFile string) (bool, error) {
 		return false, nil
 	}
 
-	var s5Error struct {
-		Error string `json:"error"`
-	}
-
-	if err := json.Unmarshal(data, &s5Error); err != nil {
+	var s5Error JobResult
+	if err := decodeResult(cfg, data, &s5Error); err != nil {
 		return false, nil
 	}
 
 	return strings.Contains(s5Error.Error, "no match found for"), nil
 }
 
-func parseAndCleanup(folderPrefix, jsonOutputFile string) (Summary, error) {
+// parseAndCleanup parses the s5cmd output files of a job, deletes the files that
+// were transferred successfully and collects the files that failed to upload.
+// With atomic deletes, nothing is deleted if any upload of the job failed, and
+// with a minimum success ratio nothing if too few of its uploads succeeded.
+func parseAndCleanup(cfg *Config, outputFiles ...string) (Summary, error) {
 	summary := Summary{}
 
-	file, err := os.Open(jsonOutputFile)
+	deleteFiles := true
+	if cfg.MinSuccessRatio > 0 {
+		succeeded, failed, err := countUploads(cfg, outputFiles)
+		if err != nil {
+			return summary, err
+		}
+		// A low ratio points at a systemic problem rather than a few bad
+		// files, the run is retried wholesale instead
+		if attempted := succeeded + failed; attempted > 0 && float64(succeeded)/float64(attempted) < cfg.MinSuccessRatio {
+			log.Printf("Warning: marking run as suspect, only %d of %d uploads succeeded, below the minimum success ratio of %g", succeeded, attempted, cfg.MinSuccessRatio)
+			summary.SuspectRuns++
+			deleteFiles = false
+		}
+	}
+
+	var stats s5cmdStats
+	for _, outputFile := range outputFiles {
+		if err := parseOutputFile(cfg, outputFile, deleteFiles, &summary, &stats); err != nil {
+			return summary, err
+		}
+	}
+	if cfg.CrossCheckStats {
+		crossCheckStats(stats, &summary, outputFiles)
+	}
+
+	if !deleteFiles {
+		log.Printf("Keeping %d transferred files for the next run, %d uploads of the run failed", summary.DeletesWithheld, len(summary.UploadsFailed))
+	}
+
+	if summary.MalformedLines > 0 {
+		log.Printf("Warning: %d lines of the s5cmd output in %v could not be parsed", summary.MalformedLines, outputFiles)
+		if cfg.MaxMalformedLines > 0 && summary.MalformedLines > cfg.MaxMalformedLines {
+			log.Printf("Warning: marking run as suspect, more than %d malformed output lines", cfg.MaxMalformedLines)
+			summary.SuspectRuns++
+		}
+	}
+
+	// Local files may only be deleted after s5cmd reported them as transferred.
+	// Any other outcome means the deletion logic is broken and losing data.
+	if summary.FilesDeleted > summary.FilesTransferred {
+		log.Printf("CONSISTENCY VIOLATION: %d files deleted but only %d transferred in %v", summary.FilesDeleted, summary.FilesTransferred, outputFiles)
+		summary.ConsistencyViolations++
+	}
+
+	return summary, nil
+}
+
+// countUploads returns the number of successful and failed cp records in the
+// output files.
+func countUploads(cfg *Config, outputFiles []string) (succeeded, failed int, err error) {
+	for _, outputFile := range outputFiles {
+		file, err := os.Open(outputFile)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error opening job result file: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var result JobResult
+			if err := decodeResult(cfg, scanner.Bytes(), &result); err != nil {
+				continue
+			}
+			if result.Operation == "cp" && result.Success && result.Object.Type == "file" {
+				succeeded++
+			} else if result.Operation == "cp" && !result.Success && result.Error != "" && !result.skippedExisting() && !sourceVanished(cfg, &result) {
+				failed++
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("error reading job result file: %w", err)
+		}
+	}
+	return succeeded, failed, nil
+}
+
+func parseOutputFile(cfg *Config, outputFile string, deleteFiles bool, summary *Summary, stats *s5cmdStats) error {
+	file, err := openOutputFile(outputFile)
 	if err != nil {
-		return summary, fmt.Errorf("error opening job result file: %w", err)
+		return fmt.Errorf("error opening job result file: %w", err)
 	}
 	defer file.Close()
 
 	reader := bufio.NewReader(file)
 	for {
 		line, err := reader.ReadBytes('\n')
-		if err == io.EOF {
+		if err == io.EOF && len(line) == 0 {
 			break
 		}
-		if err != nil {
-			return summary, fmt.Errorf("error reading job result file: %w", err)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading job result file: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if cfg.CrossCheckStats {
+			if stat, ok := decodeStat(line); ok {
+				stats.add(stat)
+				continue
+			}
 		}
 
 		var result JobResult
-		if err := json.Unmarshal(line, &result); err != nil {
-			// Ignore unmarshalling errors as some lines may not be valid JSON
+		if err := decodeResult(cfg, line, &result); err != nil {
+			// Lines that aren't JSON may be stray output, but also records torn
+			// apart by interleaved writes, whose files then stay unaccounted for.
+			summary.MalformedLines++
+			continue
+		}
+		if result.Operation == "" && isNonResult(line) {
+			summary.ProgressLines++
 			continue
 		}
 
 		if result.Operation == "cp" && result.Success && result.Object.Type == "file" {
 			summary.FilesTransferred++
 			summary.TotalBytes += result.Object.Size
+			if partition := partitionOf(cfg.FolderPrefix, originalPath(cfg, result.Source)); partition != "" {
+				if summary.Partitions == nil {
+					summary.Partitions = make(map[string]bool)
+				}
+				summary.Partitions[partition] = true
+			}
+			if cfg.ManifestPrefix != "" {
+				summary.Uploaded = append(summary.Uploaded, uploadedObject{
+					Source:      originalPath(cfg, result.Source),
+					Destination: result.Destination,
+					Size:        result.Object.Size,
+				})
+			}
+
+			if !deleteFiles {
+				summary.DeletesWithheld++
+				continue
+			}
 
-			filePathToDelete := result.Source
-			if err := os.Remove(filePathToDelete); err != nil {
-				summary.FilesFailed = append(summary.FilesFailed, filePathToDelete)
-			} else {
-				summary.FilesDeleted++
+			// This is the only place local files are deleted, always after a
+			// successful transfer was counted for them. Stable copies are removed
+			// with their job directory, it is the original that is deleted here.
+			filePathToDelete := originalPath(cfg, result.Source)
+			if filePathToDelete != result.Source && !snapshotMatches(cfg, result.Source, filePathToDelete) {
+				// What was uploaded is not what would be deleted, the original
+				// is uploaded again by the next run
+				log.Printf("Warning: keeping %s, it changed after its stable copy was taken", filePathToDelete)
+				summary.StableChanged++
+				continue
+			}
+			if cfg.AtomicDelete {
+				// Decided once all invocations of the run are done, see
+				// applyAtomicDelete
+				summary.PendingDeletes = append(summary.PendingDeletes, pendingDelete{path: filePathToDelete, size: result.Object.Size})
+				continue
+			}
+			deleteUploaded(cfg, filePathToDelete, result.Object.Size, summary)
+		} else if result.skippedExisting() {
+			skipExisting(cfg, &result, deleteFiles, summary)
+		} else if sourceVanished(cfg, &result) {
+			summary.SourceVanished++
+			if source, ok := failedSource(cfg, &result); ok {
+				cfg.state.forget(source)
+			}
+		} else if result.Operation == "cp" && !result.Success {
+			if source, ok := failedSource(cfg, &result); ok {
+				summary.UploadsFailed = append(summary.UploadsFailed, source)
+				summary.FailureReasons = addFailureReasons(summary.FailureReasons, map[string]int{failureReason(result.Error): 1})
 			}
 		}
 	}
 
-	return summary, nil
+	return nil
 }
 
-func sendShutdownMetrics(address string, summary *Summary, totalRuns int) error {
-	conn, err := net.Dial("udp", address)
-	if err != nil {
-		return fmt.Errorf("failed to connect to Netdata at %s: %w", address, err)
+// deleteUploaded deletes the transferred file at path, unless it is kept by
+// keep-larger-than.
+func deleteUploaded(cfg *Config, path string, size int64, summary *Summary) {
+	if cfg.KeepLargerThan > 0 && size > cfg.KeepLargerThan {
+		keepBySize(cfg, path, summary)
+		return
 	}
-	defer conn.Close()
+	if cfg.deleteQueue != nil {
+		cfg.deleteQueue.push(path)
+	} else if err := os.Remove(path); err != nil {
+		recordDeleteFailure(summary, path, err)
+	} else {
+		summary.FilesDeleted++
+		cfg.state.forget(path)
+	}
+}
 
-	megabytesTransferred := float64(summary.TotalBytes) / (1024 * 1024)
-	successRate := 0.0
-	if summary.FilesTransferred > 0 {
-		successRate = float64(summary.FilesDeleted) / float64(summary.FilesTransferred) * 100.0
+// failedSource returns the local file of a failed cp record. Records without a
+// source field carry it in the command, as in "cp <source> <destination>".
+// Sources that aren't a single file under the folder prefix are rejected.
+func failedSource(cfg *Config, result *JobResult) (string, bool) {
+	source := result.Source
+	if source == "" {
+		command := result.Command
+		if command == "" {
+			command = result.Job
+		}
+		fields := strings.Fields(command)
+		if len(fields) < 3 || fields[0] != "cp" {
+			return "", false
+		}
+		source = strings.Trim(fields[len(fields)-2], `'"`)
 	}
+	source = originalPath(cfg, source)
 
-	metrics := []string{
-		// Final session metrics
-		fmt.Sprintf("s5commander.session.final_files_transferred:%d|g", summary.FilesTransferred),
-		fmt.Sprintf("s5commander.session.final_files_deleted:%d|g", summary.FilesDeleted),
-		fmt.Sprintf("s5commander.session.final_megabytes_transferred:%.2f|g", megabytesTransferred),
-		fmt.Sprintf("s5commander.session.final_files_failed_delete:%d|g", len(summary.FilesFailed)),
-		fmt.Sprintf("s5commander.session.final_success_rate:%.2f|g", successRate),
-		fmt.Sprintf("s5commander.session.total_runs:%d|g", totalRuns),
-		fmt.Sprintf("s5commander.shutdown:%d|c", 1),
+	if strings.ContainsAny(source, "*?") || !isUnder(source, cfg.FolderPrefix) {
+		return "", false
 	}
+	return source, true
+}
 
-	for _, metric := range metrics {
-		_, err := fmt.Fprint(conn, metric)
-		if err != nil {
-			// Log individual metric send errors if needed, but for UDP it's often fire-and-forget
-		}
+// logFailedFiles logs a sample of the files of summary that failed to delete or
+// to upload, bounded by cfg.FailedLogSample.
+func logFailedFiles(cfg *Config, summary *Summary) {
+	if cfg.FailedLogSample == 0 {
+		return
 	}
-	return nil
+	if len(summary.FilesFailed) > 0 {
+		log.Printf("Files failed to delete: %s", formatSample(summary.FilesFailed, cfg.FailedLogSample))
+		log.Printf("Delete failures by reason: %s", formatFailureReasons(summary.DeleteReasons, len(summary.DeleteReasons)))
+	}
+	if len(summary.UploadsFailed) > 0 {
+		log.Printf("Files failed to upload: %s", formatSample(summary.UploadsFailed, cfg.FailedLogSample))
+		log.Printf("Most common upload failures: %s", formatFailureReasons(summary.FailureReasons, 3))
+	}
+}
+
+// skipExisting handles a file skipped because its object already exists. The
+// upload is treated as done, so the file is deleted unless deletes are withheld
+// or cfg.DeleteSkipped is off. Skipped files are counted apart from transfers
+// and deletes, so they never count against the consistency check.
+func skipExisting(cfg *Config, result *JobResult, deleteFiles bool, summary *Summary) {
+	summary.SkippedExisting++
+	if !cfg.DeleteSkipped || !deleteFiles {
+		return
+	}
+	source, ok := failedSource(cfg, result)
+	if !ok {
+		return
+	}
+	if cfg.AtomicDelete {
+		summary.PendingDeletes = append(summary.PendingDeletes, pendingDelete{path: source, skipped: true})
+		return
+	}
+	if err := os.Remove(source); err != nil {
+		recordDeleteFailure(summary, source, err)
+		return
+	}
+	summary.SkippedDeleted++
+	cfg.state.forget(source)
 }
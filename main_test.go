@@ -0,0 +1,599 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestConfig returns a configuration spooling from a temporary folder
+// prefix, with an in-memory state store.
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	state, err := loadStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Config{
+		FolderPrefix: t.TempDir() + "/",
+		PathSuffix:   "*",
+		S3BucketPath: "s3://bucket/prefix/",
+		state:        state,
+	}
+}
+
+// writeSpoolFile creates a file below the folder prefix of cfg and returns its
+// path.
+func writeSpoolFile(t *testing.T, cfg *Config, rel, content string) string {
+	t.Helper()
+	path := filepath.Join(cfg.FolderPrefix, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeOutput writes lines as an s5cmd output file and returns its path.
+func writeOutput(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.json")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// cpSuccess returns the record of a successful upload of source.
+func cpSuccess(source string, size int64) string {
+	record := map[string]any{
+		"operation":   "cp",
+		"success":     true,
+		"source":      source,
+		"destination": "s3://bucket/prefix/" + filepath.Base(source),
+		"object":      map[string]any{"type": "file", "size": size},
+	}
+	line, _ := json.Marshal(record)
+	return string(line)
+}
+
+// cpFailure returns the record of a failed upload of source.
+func cpFailure(source, message string) string {
+	record := map[string]any{
+		"operation": "cp",
+		"success":   false,
+		"command":   "cp " + source + " s3://bucket/prefix/" + filepath.Base(source),
+		"error":     message,
+	}
+	line, _ := json.Marshal(record)
+	return string(line)
+}
+
+// fakeS5cmd writes a shell script standing in for s5cmd and returns its path.
+// The script sees the s5cmd arguments as "$@".
+func fakeS5cmd(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "s5cmd")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func assertExists(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("%s should still exist: %v", path, err)
+	}
+}
+
+func assertGone(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s should have been deleted, stat returned %v", path, err)
+	}
+}
+
+func TestParseAndCleanupDeletesOnlyTransferredFiles(t *testing.T) {
+	cfg := newTestConfig(t)
+	uploaded := writeSpoolFile(t, cfg, "a/uploaded.log", "abc")
+	failed := writeSpoolFile(t, cfg, "a/failed.log", "abc")
+	existing := writeSpoolFile(t, cfg, "a/existing.log", "abc")
+	directory := writeSpoolFile(t, cfg, "a/dir/file.log", "abc")
+	torn := writeSpoolFile(t, cfg, "a/torn.log", "abc")
+
+	dirRecord := strings.Replace(cpSuccess(filepath.Dir(directory), 0), `"type":"file"`, `"type":"directory"`, 1)
+	output := writeOutput(t,
+		cpSuccess(uploaded, 3),
+		cpFailure(failed, "connection refused"),
+		cpFailure(existing, "object already exists"),
+		dirRecord,
+		`{"operation":"cp","success":true,"source":"`+torn, // torn apart, not JSON
+	)
+
+	summary, err := parseAndCleanup(cfg, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGone(t, uploaded)
+	for _, path := range []string{failed, existing, directory, torn} {
+		assertExists(t, path)
+	}
+	if summary.FilesTransferred != 1 || summary.FilesDeleted != 1 {
+		t.Errorf("got %d transferred and %d deleted, want 1 and 1", summary.FilesTransferred, summary.FilesDeleted)
+	}
+	if summary.ConsistencyViolations != 0 {
+		t.Errorf("got %d consistency violations, want none", summary.ConsistencyViolations)
+	}
+	if len(summary.UploadsFailed) != 1 || summary.UploadsFailed[0] != failed {
+		t.Errorf("got failed uploads %v, want [%s]", summary.UploadsFailed, failed)
+	}
+	if summary.MalformedLines != 1 {
+		t.Errorf("got %d malformed lines, want 1", summary.MalformedLines)
+	}
+}
+
+func TestParseAndCleanupDeletesNothingOutsideFolderPrefix(t *testing.T) {
+	cfg := newTestConfig(t)
+	outside := filepath.Join(t.TempDir(), "outside.log")
+	if err := os.WriteFile(outside, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := parseAndCleanup(cfg, writeOutput(t, cpFailure(outside, "connection refused")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, outside)
+	if summary.FilesDeleted != 0 || len(summary.UploadsFailed) != 0 {
+		t.Errorf("got %d deleted and failed uploads %v, want none", summary.FilesDeleted, summary.UploadsFailed)
+	}
+}
+
+func TestAtomicDeleteWithholdsDeletes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.AtomicDelete = true
+	uploaded := writeSpoolFile(t, cfg, "uploaded.log", "abc")
+	failed := writeSpoolFile(t, cfg, "failed.log", "abc")
+
+	summary, err := parseAndCleanup(cfg, writeOutput(t,
+		cpSuccess(uploaded, 3),
+		cpFailure(failed, "connection refused"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyAtomicDelete(cfg, &summary, nil)
+	assertExists(t, uploaded)
+	assertExists(t, failed)
+	if summary.FilesDeleted != 0 || summary.DeletesWithheld != 1 {
+		t.Errorf("got %d deleted and %d withheld, want 0 and 1", summary.FilesDeleted, summary.DeletesWithheld)
+	}
+}
+
+func TestAtomicDeleteDeletesCleanRuns(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.AtomicDelete = true
+	cfg.DeleteSkipped = true
+	uploaded := writeSpoolFile(t, cfg, "uploaded.log", "abc")
+	existing := writeSpoolFile(t, cfg, "existing.log", "abc")
+
+	// A file skipped as existing is no failed upload
+	summary, err := parseAndCleanup(cfg, writeOutput(t,
+		cpSuccess(uploaded, 3),
+		cpFailure(existing, "object already exists"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is deleted before the whole run is known
+	assertExists(t, uploaded)
+	applyAtomicDelete(cfg, &summary, nil)
+	assertGone(t, uploaded)
+	assertGone(t, existing)
+	if summary.FilesDeleted != 1 || summary.DeletesWithheld != 0 {
+		t.Errorf("got %d deleted and %d withheld, want 1 and 0", summary.FilesDeleted, summary.DeletesWithheld)
+	}
+}
+
+func TestSummaryMergeKeepsDeepestDeleteQueue(t *testing.T) {
+	var total Summary
+	total.merge(Summary{DeleteQueueDepth: 7})
+	total.merge(Summary{DeleteQueueDepth: 3})
+	if total.DeleteQueueDepth != 7 {
+		t.Errorf("got delete queue depth %d, want the deepest 7", total.DeleteQueueDepth)
+	}
+}
+
+func TestSummaryMergeCountsMergedPartitions(t *testing.T) {
+	var total Summary
+	total.merge(Summary{Partitions: map[string]bool{"a": true, "b": true}, PartitionsTouched: 2})
+	total.merge(Summary{Partitions: map[string]bool{"b": true, "c": true}, PartitionsTouched: 2})
+	if total.PartitionsTouched != 3 {
+		t.Errorf("got %d partitions touched, want 3 distinct", total.PartitionsTouched)
+	}
+}
+
+func TestReserveJobRetriesOnNameClash(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.SeparateStderr = true
+	// Another job's stderr file holds the name
+	if err := os.WriteFile("clash.stderr.json", []byte("in use"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobID, err := reserveJob(cfg, "clash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobID == "clash" {
+		t.Fatal("reserveJob returned the clashing job ID")
+	}
+	if data, err := os.ReadFile("clash.stderr.json"); err != nil || string(data) != "in use" {
+		t.Errorf("the clashing file was changed: %q, %v", data, err)
+	}
+	assertExists(t, jobID+".json")
+	assertExists(t, jobID+".stderr.json")
+	// The output file created before the clash was found is removed again
+	assertGone(t, "clash.json")
+}
+
+func TestSummaryMergeKeepsMountLoss(t *testing.T) {
+	var total Summary
+	total.merge(Summary{MountLost: true})
+	total.merge(Summary{})
+	if !total.MountLost {
+		t.Error("a later run without mount loss cleared MountLost")
+	}
+}
+
+func TestParseAndCleanupMinSuccessRatioWithholdsDeletes(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.MinSuccessRatio = 0.5
+	uploaded := writeSpoolFile(t, cfg, "uploaded.log", "abc")
+	var lines []string
+	lines = append(lines, cpSuccess(uploaded, 3))
+	for _, name := range []string{"x.log", "y.log"} {
+		lines = append(lines, cpFailure(writeSpoolFile(t, cfg, name, "abc"), "connection refused"))
+	}
+
+	// 1 of 3 uploads succeeded
+	summary, err := parseAndCleanup(cfg, writeOutput(t, lines...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, uploaded)
+	if summary.FilesDeleted != 0 || summary.SuspectRuns != 1 {
+		t.Errorf("got %d deleted and %d suspect runs, want 0 and 1", summary.FilesDeleted, summary.SuspectRuns)
+	}
+}
+
+func TestParseAndCleanupMinSuccessRatioDeletesHealthyRuns(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.MinSuccessRatio = 0.5
+	first := writeSpoolFile(t, cfg, "first.log", "abc")
+	second := writeSpoolFile(t, cfg, "second.log", "abc")
+	failed := writeSpoolFile(t, cfg, "failed.log", "abc")
+
+	// 2 of 3 uploads succeeded
+	summary, err := parseAndCleanup(cfg, writeOutput(t,
+		cpSuccess(first, 3),
+		cpSuccess(second, 3),
+		cpFailure(failed, "connection refused"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGone(t, first)
+	assertGone(t, second)
+	assertExists(t, failed)
+	if summary.FilesDeleted != 2 || summary.SuspectRuns != 0 {
+		t.Errorf("got %d deleted and %d suspect runs, want 2 and 0", summary.FilesDeleted, summary.SuspectRuns)
+	}
+}
+
+// uploadingS5cmd returns a fake s5cmd reporting every file of its cp command,
+// or of the cp commands of its commands file in per-file mode, as transferred.
+func uploadingS5cmd(t *testing.T) string {
+	t.Helper()
+	return fakeS5cmd(t, s5cmdCommands+`for f in $sources; do
+	echo '{"operation":"cp","success":true,"source":"'$f'","destination":"s3://bucket/prefix/x","object":{"type":"file","size":3}}'
+done
+`)
+}
+
+// partlyFailingS5cmd returns a fake s5cmd that uploads its sources except
+// those with "bad" in their name, which are denied. Like s5cmd it exits 1 if a
+// single copy failed.
+func partlyFailingS5cmd(t *testing.T) string {
+	t.Helper()
+	return fakeS5cmd(t, s5cmdCommands+`status=0
+for f in $sources; do
+	case $f in
+	*bad*)
+		echo '{"operation":"cp","success":false,"command":"cp '$f' s3://bucket/prefix/x","error":"AccessDenied: access denied"}'
+		status=1
+		;;
+	*)
+		echo '{"operation":"cp","success":true,"source":"'$f'","destination":"s3://bucket/prefix/x","object":{"type":"file","size":3}}'
+		;;
+	esac
+done
+exit $status
+`)
+}
+
+// s5cmdCommands is the start of a fake s5cmd collecting the sources of its cp
+// commands in $sources, whether given on the command line or in a commands
+// file.
+const s5cmdCommands = `prev=; commands=
+for arg; do
+	[ "$prev" = run ] && commands=$arg
+	prev=$arg
+done
+sources=
+if [ -n "$commands" ]; then
+	while read -r line; do
+		eval "set -- $line"
+		shift $(($# - 2))
+		sources="$sources $1"
+	done < "$commands"
+else
+	sources=$(eval echo "\${$(($# - 1))}")
+fi
+`
+
+func TestCpOptionsMultipart(t *testing.T) {
+	for _, tc := range []struct {
+		size        int64
+		concurrency int
+		want        string
+	}{
+		{0, 0, ""},
+		{64 * mebibyte, 0, "--part-size 64"},
+		{5*mebibyte + 1, 0, "--part-size 6"},
+		{0, 8, "--concurrency 8"},
+		{16 * mebibyte, 4, "--part-size 16 --concurrency 4"},
+	} {
+		cfg := &Config{MultipartSize: tc.size, MultipartConcurrency: tc.concurrency}
+		if got := strings.Join(cpOptions(cfg), " "); got != tc.want {
+			t.Errorf("size %d, concurrency %d: got %q, want %q", tc.size, tc.concurrency, got, tc.want)
+		}
+	}
+}
+
+func TestFailedRunIsParsed(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.S5cmdBinary = partlyFailingS5cmd(t)
+	cfg.MaxUploadAttempts = 1
+	cfg.DeadLetterDir = t.TempDir()
+	good := writeSpoolFile(t, cfg, "good.log", "abc")
+	bad := writeSpoolFile(t, cfg, "bad.log", "abc")
+
+	summary, err := processFiles(context.Background(), cfg)
+	if classOf(err) != errorClassAuth {
+		t.Fatalf("got error %v, want the auth error of the s5cmd run", err)
+	}
+	if summary.FilesTransferred != 1 || summary.FilesDeleted != 1 {
+		t.Errorf("got %d transferred and %d deleted, want 1 and 1", summary.FilesTransferred, summary.FilesDeleted)
+	}
+	if len(summary.UploadsFailed) != 1 || summary.UploadsFailed[0] != bad {
+		t.Errorf("got failed uploads %v, want %s", summary.UploadsFailed, bad)
+	}
+	if summary.FilesDeadLettered != 1 {
+		t.Errorf("got %d files dead-lettered, want 1", summary.FilesDeadLettered)
+	}
+	assertGone(t, good)
+	assertGone(t, bad)
+	assertExists(t, filepath.Join(cfg.DeadLetterDir, "bad.log"))
+}
+
+func TestAtomicDeleteWithholdsDeletesOfFailedRun(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.AtomicDelete = true
+	cfg.S5cmdBinary = partlyFailingS5cmd(t)
+	good := writeSpoolFile(t, cfg, "good.log", "abc")
+	bad := writeSpoolFile(t, cfg, "bad.log", "abc")
+
+	summary, err := processFiles(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("the failed s5cmd run reported no error")
+	}
+	assertExists(t, good)
+	assertExists(t, bad)
+	if summary.FilesDeleted != 0 || summary.DeletesWithheld != 1 {
+		t.Errorf("got %d deleted and %d withheld, want 0 and 1", summary.FilesDeleted, summary.DeletesWithheld)
+	}
+}
+
+func TestAtomicDeleteCoversAllSubdirectories(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.AtomicDelete = true
+	cfg.PathSuffix = "*/*"
+	cfg.SplitBySubdir = true
+	cfg.SubdirConcurrency = 2
+	cfg.S5cmdBinary = partlyFailingS5cmd(t)
+	// Subdirectory a uploads cleanly, b fails
+	clean := writeSpoolFile(t, cfg, "a/1.log", "abc")
+	good := writeSpoolFile(t, cfg, "b/good.log", "abc")
+	bad := writeSpoolFile(t, cfg, "b/bad.log", "abc")
+
+	summary, err := processFiles(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("the failed s5cmd run reported no error")
+	}
+	assertExists(t, clean)
+	assertExists(t, good)
+	assertExists(t, bad)
+	if summary.FilesDeleted != 0 || summary.DeletesWithheld != 2 {
+		t.Errorf("got %d deleted and %d withheld, want 0 and 2", summary.FilesDeleted, summary.DeletesWithheld)
+	}
+}
+
+func TestS5cmdSlotsCapConcurrentProcesses(t *testing.T) {
+	running := t.TempDir()
+	seen := filepath.Join(t.TempDir(), "seen")
+	cfg := newTestConfig(t)
+	cfg.s5cmdSlots = make(chan struct{}, 2)
+	// Every process records how many are running alongside it
+	cfg.S5cmdBinary = fakeS5cmd(t, `touch `+running+`/$$
+ls `+running+` | wc -l >> `+seen+`
+sleep 0.2
+rm `+running+`/$$
+`)
+
+	var wg sync.WaitGroup
+	for i := range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			output := filepath.Join(t.TempDir(), fmt.Sprintf("job-%d.json", i))
+			if err := runS5cmd(cfg, []string{"ls"}, output, output); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := strings.Fields(string(data))
+	if len(counts) != 6 {
+		t.Fatalf("got %d recorded runs, want 6", len(counts))
+	}
+	for _, count := range counts {
+		if count != "1" && count != "2" {
+			t.Errorf("%s s5cmd processes ran at once, want at most 2", count)
+		}
+	}
+}
+
+func TestFailedUploadsAreSampled(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.S5cmdBinary = partlyFailingS5cmd(t)
+	cfg.FailedLogSample = 2
+	writeSpoolFile(t, cfg, "good.log", "abc")
+	var bad []string
+	for _, name := range []string{"bad1.log", "bad2.log", "bad3.log"} {
+		bad = append(bad, writeSpoolFile(t, cfg, name, "abc"))
+	}
+
+	summary, err := processFiles(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("the failed s5cmd run reported no error")
+	}
+	logged := captureLog(t)
+	logFailedFiles(cfg, &summary)
+	report := newShutdownReport(&summary, 1, time.Now(), "test", cfg.FailedLogSample)
+
+	if report.FilesFailedUploadCount != 3 || len(report.FilesFailedUpload) != 2 {
+		t.Fatalf("got %d failed uploads sampled as %v, want 3 sampled as 2", report.FilesFailedUploadCount, report.FilesFailedUpload)
+	}
+	for _, path := range report.FilesFailedUpload {
+		if !slices.Contains(bad, path) {
+			t.Errorf("sampled %s, which didn't fail", path)
+		}
+	}
+	if !strings.Contains(logged.String(), "Files failed to upload: "+strings.Join(report.FilesFailedUpload, ", ")+" (+1 more)") {
+		t.Errorf("got log %q, want the sample of the failed uploads", logged)
+	}
+}
+
+func TestFailureReasonsOfFailingRun(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	// Every denied upload carries request IDs of its own
+	cfg.S5cmdBinary = fakeS5cmd(t, s5cmdCommands+`n=0
+for f in $sources; do
+	n=$((n + 1))
+	case $f in
+	*bad*) printf '%s\n' '{"operation":"cp","success":false,"command":"cp '$f' s3://bucket/prefix/x","error":"AccessDenied: Access Denied\n\tstatus code: 403, request id: REQ'$n', host id: HOST'$n'"}' ;;
+	*timeout*) echo '{"operation":"cp","success":false,"command":"cp '$f' s3://bucket/prefix/x","error":"RequestTimeout: request timed out"}' ;;
+	*) echo '{"operation":"cp","success":true,"source":"'$f'","destination":"s3://bucket/prefix/x","object":{"type":"file","size":3}}' ;;
+	esac
+done
+exit 1
+`)
+	writeSpoolFile(t, cfg, "good.log", "abc")
+	writeSpoolFile(t, cfg, "bad1.log", "abc")
+	writeSpoolFile(t, cfg, "bad2.log", "abc")
+	writeSpoolFile(t, cfg, "timeout.log", "abc")
+
+	summary, err := processFiles(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("the failed s5cmd run reported no error")
+	}
+	want := map[string]int{"AccessDenied: Access Denied status code: 403": 2, "RequestTimeout: request timed out": 1}
+	if !reflect.DeepEqual(summary.FailureReasons, want) {
+		t.Errorf("got failure reasons %v, want %v", summary.FailureReasons, want)
+	}
+
+	sink := &recordingSender{}
+	if err := sendToNetdata(sink, &summary, 1); err != nil {
+		t.Fatal(err)
+	}
+	var metrics []string
+	for _, batch := range sink.batches {
+		metrics = append(metrics, batch...)
+	}
+	for _, metric := range []string{
+		"s5commander.upload_failures.accessdenied_access_denied_status_code_403:2|c",
+		"s5commander.upload_failures.requesttimeout_request_timed_out:1|c",
+	} {
+		if !slices.Contains(metrics, metric) {
+			t.Errorf("metric %s is missing", metric)
+		}
+	}
+}
+
+func TestRunJobMinSuccessRatioOfFailingRun(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		files    []string
+		suspect  int
+		deleted  int
+		withheld int
+	}{
+		{"below", []string{"good.log", "bad1.log", "bad2.log"}, 1, 0, 1},
+		{"above", []string{"good1.log", "good2.log", "bad.log"}, 0, 2, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			cfg := newTestConfig(t)
+			cfg.MinSuccessRatio = 0.5
+			cfg.S5cmdBinary = partlyFailingS5cmd(t)
+			for _, name := range tc.files {
+				writeSpoolFile(t, cfg, name, "abc")
+			}
+
+			summary, err := runJob(context.Background(), cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), cfg.S3BucketPath)
+			if err == nil {
+				t.Fatal("the failed s5cmd run reported no error")
+			}
+			if summary.SuspectRuns != tc.suspect || summary.FilesDeleted != tc.deleted || summary.DeletesWithheld != tc.withheld {
+				t.Errorf("got %d suspect runs, %d deleted and %d withheld, want %d, %d and %d",
+					summary.SuspectRuns, summary.FilesDeleted, summary.DeletesWithheld, tc.suspect, tc.deleted, tc.withheld)
+			}
+		})
+	}
+}
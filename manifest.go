@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// uploadedObject is a file uploaded in a run, as listed in its manifest.
+type uploadedObject struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+}
+
+// runManifest lists the files uploaded in a run, for consumers watching the
+// bucket.
+type runManifest struct {
+	RunID            string           `json:"run_id"`
+	InstanceID       string           `json:"instance_id"`
+	CompletedAt      time.Time        `json:"completed_at"`
+	FilesTransferred int              `json:"files_transferred"`
+	BytesTransferred int64            `json:"bytes_transferred"`
+	FilesDeleted     int              `json:"files_deleted"`
+	FilesFailed      int              `json:"files_failed_upload"`
+	Objects          []uploadedObject `json:"objects"`
+}
+
+func newRunManifest(runID, instanceID string, summary *Summary) runManifest {
+	return runManifest{
+		RunID:            runID,
+		InstanceID:       instanceID,
+		CompletedAt:      time.Now().UTC(),
+		FilesTransferred: summary.FilesTransferred,
+		BytesTransferred: summary.TotalBytes,
+		FilesDeleted:     summary.FilesDeleted,
+		FilesFailed:      len(summary.UploadsFailed),
+		Objects:          summary.Uploaded,
+	}
+}
+
+// manifestDestination returns the object the manifest of runID is written to.
+// Prefixes that aren't an s3:// URL are relative to the bucket path.
+func manifestDestination(cfg *Config, runID string, at time.Time) string {
+	prefix := cfg.ManifestPrefix
+	if !strings.HasPrefix(prefix, "s3://") {
+		prefix = joinDestination(cfg.S3BucketPath, strings.TrimPrefix(prefix, "/"))
+	}
+	return joinDestination(prefix, fmt.Sprintf("%s-%s.json", at.Format("20060102T150405Z"), runID))
+}
+
+// writeManifest uploads the manifest of a run that transferred files. Failures
+// are only logged, the files of the run are uploaded already.
+func writeManifest(cfg *Config, summary *Summary) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		log.Printf("Error generating manifest ID: %v", err)
+		return
+	}
+	runID := id.String()
+	// Named after the run's first job, so it is as reproducible as the job ID
+	if cfg.DeterministicJobID && len(summary.JobIDs) > 0 {
+		runID = summary.JobIDs[0]
+	}
+	manifest := newRunManifest(runID, cfg.InstanceID, summary)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding manifest: %v", err)
+		return
+	}
+
+	localFile := fmt.Sprintf("%s.manifest.json", runID)
+	defer os.Remove(localFile)
+	if err := os.WriteFile(localFile, append(data, '\n'), 0o644); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+		return
+	}
+
+	outputFile := fmt.Sprintf("%s.json", runID)
+	defer os.Remove(outputFile)
+	dest := manifestDestination(cfg, runID, manifest.CompletedAt)
+	if err := runS5cmd(cfg, []string{"cp", localFile, dest}, outputFile, outputFile); err != nil {
+		log.Printf("Error uploading manifest to %s: %v (class %s)", dest, err, classifyOutput(cfg, outputFile))
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// applyMemoryLimits tunes the garbage collector for memory-limited
+// deployments. Near the soft limit the collector runs more often, trading CPU
+// for staying below it.
+func applyMemoryLimits(cfg *Config) {
+	if cfg.SoftMemoryLimit > 0 {
+		debug.SetMemoryLimit(cfg.SoftMemoryLimit)
+		log.Printf("Soft memory limit: %.1f MiB", float64(cfg.SoftMemoryLimit)/mebibyte)
+	}
+	if cfg.GCPercent != 0 {
+		debug.SetGCPercent(cfg.GCPercent)
+		if cfg.GCPercent < 0 {
+			log.Println("Garbage collection only runs near the soft memory limit")
+		} else {
+			log.Printf("Garbage collection target: %d%%", cfg.GCPercent)
+		}
+	}
+}
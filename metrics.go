@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// MetricSink receives the metrics of every run and the totals of the session
+// on shutdown.
+type MetricSink interface {
+	// Started marks the start of the program, before the first run.
+	Started() error
+	// RunCompleted reports the summary of runCount completed runs.
+	RunCompleted(summary *Summary, runCount int) error
+	// Progress reports the progress of the run still running.
+	Progress(progress progressSnapshot) error
+	// Shutdown reports the accumulated summary of totalRuns runs on shutdown.
+	Shutdown(summary *Summary, totalRuns int) error
+	Close() error
+}
+
+// multiSink fans every report out to all enabled sinks.
+type multiSink []MetricSink
+
+// newMetricSinks sets up the metric sinks enabled in cfg.
+func newMetricSinks(cfg *Config) (multiSink, error) {
+	var sinks multiSink
+
+	if cfg.NetdataEnabled {
+		sinks = append(sinks, newNetdataSink(cfg))
+	}
+
+	if cfg.PrometheusListen != "" {
+		sink, err := newPrometheusSink(cfg.PrometheusListen, cfg.InstanceID)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		log.Printf("Serving Prometheus metrics on %s/metrics", cfg.PrometheusListen)
+		sinks = append(sinks, sink)
+	}
+
+	if cfg.PrometheusTextfile != "" {
+		sinks = append(sinks, newTextfileSink(cfg.PrometheusTextfile, cfg.InstanceID))
+	}
+
+	if cfg.InfluxDBURL != "" {
+		sinks = append(sinks, newInfluxDBSink(cfg.InfluxDBURL, cfg.InfluxDBToken, cfg.InstanceID, cfg.MetricDialTimeout))
+	}
+
+	return sinks, nil
+}
+
+func (m multiSink) Started() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Started())
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) RunCompleted(summary *Summary, runCount int) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.RunCompleted(summary, runCount))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Progress(progress progressSnapshot) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Progress(progress))
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Shutdown(summary *Summary, totalRuns int) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Shutdown(summary, totalRuns))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the sinks in reverse order of their creation.
+func (m multiSink) Close() error {
+	var errs []error
+	for i := len(m) - 1; i >= 0; i-- {
+		errs = append(errs, m[i].Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// spoolPresent checks that the spool is available, by the mount sentinel if
+// one is set and by folder-prefix otherwise. While it is missing, runs are
+// skipped: a transient gap, e.g. while a mount is being remounted, only logs a
+// warning, while a spool missing for longer than cfg.MountLossGrace is reported
+// as lost in summary. It returns whether the run may go ahead.
+func spoolPresent(cfg *Config, summary *Summary) bool {
+	path := cfg.FolderPrefix
+	if cfg.MountSentinel != "" {
+		path = filepath.Join(cfg.FolderPrefix, cfg.MountSentinel)
+	}
+
+	_, err := os.Stat(path)
+	if err == nil {
+		if !cfg.spoolMissing.IsZero() {
+			log.Printf("%s is back after %v, resuming runs", path, time.Since(cfg.spoolMissing).Round(time.Second))
+			cfg.spoolMissing = time.Time{}
+			cfg.mountLost = false
+		}
+		return true
+	}
+
+	now := time.Now()
+	if cfg.spoolMissing.IsZero() {
+		log.Printf("Warning: %s is missing, skipping runs until it is back: %v", path, err)
+		cfg.spoolMissing = now
+	}
+	missing := now.Sub(cfg.spoolMissing)
+	if missing >= cfg.MountLossGrace {
+		if !cfg.mountLost {
+			log.Printf("ERROR: %s has been missing for %v, the spool mount looks lost", path, missing.Round(time.Second))
+		}
+		cfg.mountLost = true
+		summary.MountLost = true
+	}
+	return false
+}
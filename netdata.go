@@ -0,0 +1,626 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// netdataClient sends statsd metrics to Netdata over a reused UDP connection.
+// The connection is dropped on write errors and dialed again on the next send.
+// Metrics are packed into newline-separated datagrams of up to maxDatagram
+// bytes, small enough to pass the path MTU unfragmented. Delivery errors are
+// reported once when Netdata becomes unreachable rather than on every send.
+//
+// Every metric is sent at most once. With redelivery, the metrics of datagrams
+// that failed to send are kept and sent ahead of the next batch; those of
+// datagrams sent already never are, so no counter is incremented twice.
+type netdataClient struct {
+	address     string
+	dialTimeout time.Duration
+	tags        string // statsd tags added to every metric
+	maxDatagram int    // 0 sends every metric on its own
+	conn        net.Conn
+	unreachable bool
+	pending     *metricsAggregate // undelivered metrics, nil without redelivery
+}
+
+func newNetdataClient(address string, dialTimeout time.Duration, instanceID string, maxDatagram int, redeliver bool) *netdataClient {
+	c := &netdataClient{address: address, dialTimeout: dialTimeout, tags: "instance:" + instanceID, maxDatagram: maxDatagram}
+	if redeliver {
+		c.pending = newMetricsAggregate()
+	}
+	return c
+}
+
+// probe checks once whether Netdata accepts datagrams at the client's address.
+// A bare newline, which statsd ignores, is sent and, as statsd never replies, a
+// read timing out means the port is open while a refused connection means
+// nothing listens.
+func (c *netdataClient) probe(timeout time.Duration) error {
+	conn, err := net.DialTimeout("udp", c.address, timeout)
+	if err != nil {
+		c.unreachable = true
+		return fmt.Errorf("failed to connect to Netdata at %s: %w", c.address, err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("\n")); err == nil {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, err = conn.Read(make([]byte, 1))
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		c.unreachable = true
+		return fmt.Errorf("nothing is listening for metrics at %s", c.address)
+	}
+	return nil
+}
+
+// send writes the metrics to Netdata. It returns an error only when
+// Netdata turns unreachable, later failures are silent until it recovers.
+func (c *netdataClient) send(metrics []string) error {
+	if c.pending != nil {
+		// Older values first, a gauge ends up with the latest one
+		metrics = append(c.pending.drain(), metrics...)
+	}
+	sent, err := c.write(metrics)
+	if err != nil {
+		if c.pending != nil {
+			if err := c.pending.add(metrics[sent:]); err != nil {
+				log.Printf("Error keeping undelivered metrics: %v", err)
+			}
+		}
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		if c.unreachable {
+			return nil
+		}
+		c.unreachable = true
+		return err
+	}
+
+	if c.unreachable {
+		log.Printf("Netdata at %s is reachable again", c.address)
+		c.unreachable = false
+	}
+	return nil
+}
+
+// write sends the metrics packed into datagrams and returns how many of them,
+// from the start, went out in datagrams that were sent successfully.
+func (c *netdataClient) write(metrics []string) (int, error) {
+	if c.conn == nil {
+		// Resolving the address may stall, dialing UDP sends nothing
+		conn, err := net.DialTimeout("udp", c.address, c.dialTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("failed to connect to Netdata at %s: %w", c.address, err)
+		}
+		c.conn = conn
+	}
+
+	var datagram []byte
+	sent, packed := 0, 0
+	flush := func() error {
+		if len(datagram) == 0 {
+			return nil
+		}
+		n, err := c.conn.Write(datagram)
+		if err == nil && n < len(datagram) {
+			err = fmt.Errorf("short write of %d of %d bytes", n, len(datagram))
+		}
+		datagram = datagram[:0]
+		if err != nil {
+			return fmt.Errorf("failed to send metrics to Netdata at %s: %w", c.address, err)
+		}
+		sent += packed
+		packed = 0
+		return nil
+	}
+
+	for _, metric := range metrics {
+		if strings.Contains(metric, "|#") {
+			metric += "," + c.tags
+		} else {
+			metric += "|#" + c.tags
+		}
+		// A metric exceeding the limit by itself is still sent, on its own
+		if len(datagram) > 0 && (c.maxDatagram == 0 || len(datagram)+1+len(metric) > c.maxDatagram) {
+			if err := flush(); err != nil {
+				return sent, err
+			}
+		}
+		if len(datagram) > 0 {
+			datagram = append(datagram, '\n')
+		}
+		datagram = append(datagram, metric...)
+		packed++
+	}
+	err := flush()
+	return sent, err
+}
+
+// Close closes the underlying connection.
+func (c *netdataClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// metricsSender delivers a batch of statsd metrics.
+type metricsSender interface {
+	send(metrics []string) error
+}
+
+// metricsDispatcher takes metric delivery off the processing path. Batches are
+// queued on a bounded channel and sent to Netdata by a dedicated goroutine, so
+// a slow or stalled sink never delays a run. When the queue is full the oldest
+// batch is dropped and counted in s5commander.metrics_dropped.
+type metricsDispatcher struct {
+	next    metricsSender
+	queue   chan []string
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+}
+
+func newMetricsDispatcher(next metricsSender, size int) *metricsDispatcher {
+	d := &metricsDispatcher{
+		next:  next,
+		queue: make(chan []string, size),
+	}
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// send queues a batch without blocking. It always returns nil, delivery errors
+// are logged by the sending goroutine.
+func (d *metricsDispatcher) send(metrics []string) error {
+	for {
+		select {
+		case d.queue <- metrics:
+			return nil
+		default:
+		}
+
+		select {
+		case <-d.queue:
+			d.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+func (d *metricsDispatcher) run() {
+	defer d.wg.Done()
+
+	for metrics := range d.queue {
+		if dropped := d.dropped.Swap(0); dropped > 0 {
+			metrics = append(metrics, fmt.Sprintf("s5commander.metrics_dropped:%d|c", dropped))
+		}
+		if err := d.next.send(metrics); err != nil {
+			log.Printf("Error sending metrics to Netdata: %v", err)
+		}
+	}
+}
+
+// Close sends the batches still queued and stops the sending goroutine.
+func (d *metricsDispatcher) Close() error {
+	close(d.queue)
+	d.wg.Wait()
+	return nil
+}
+
+// metricsAggregate combines statsd metrics by type: the increments of a
+// counter are summed, so each of them is sent exactly once however batches are
+// combined, while a gauge keeps its latest value. Metrics are told apart by
+// name and statsd tags.
+type metricsAggregate struct {
+	names    []string // name and tags, in order of first appearance
+	counters map[string]float64
+	gauges   map[string]string
+}
+
+func newMetricsAggregate() *metricsAggregate {
+	return &metricsAggregate{counters: make(map[string]float64), gauges: make(map[string]string)}
+}
+
+// add folds metrics into the aggregate. Metrics that are not well-formed
+// counters or gauges are rejected.
+func (a *metricsAggregate) add(metrics []string) error {
+	for _, metric := range metrics {
+		name, rest, ok := strings.Cut(metric, ":")
+		value, rest, ok2 := strings.Cut(rest, "|")
+		if !ok || !ok2 {
+			return fmt.Errorf("malformed metric %q", metric)
+		}
+		kind, tags, tagged := strings.Cut(rest, "|")
+		key := name
+		if tagged {
+			key += "|" + tags
+		}
+		_, counter := a.counters[key]
+		_, gauge := a.gauges[key]
+		switch kind {
+		case "c":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("malformed counter %q: %w", metric, err)
+			}
+			a.counters[key] += n
+		case "g":
+			a.gauges[key] = value
+		default:
+			return fmt.Errorf("unsupported metric type in %q", metric)
+		}
+		if !counter && !gauge {
+			a.names = append(a.names, key)
+		}
+	}
+	return nil
+}
+
+// drain returns the aggregated metrics and empties the aggregate.
+func (a *metricsAggregate) drain() []string {
+	metrics := make([]string, 0, len(a.names))
+	for _, key := range a.names {
+		name, tags, tagged := strings.Cut(key, "|")
+		if tagged {
+			tags = "|" + tags
+		}
+		if n, ok := a.counters[key]; ok {
+			metrics = append(metrics, fmt.Sprintf("%s:%s|c%s", name, strconv.FormatFloat(n, 'f', -1, 64), tags))
+		} else {
+			metrics = append(metrics, fmt.Sprintf("%s:%s|g%s", name, a.gauges[key], tags))
+		}
+	}
+	a.names = nil
+	clear(a.counters)
+	clear(a.gauges)
+	return metrics
+}
+
+// metricsBuffer aggregates statsd metrics and hands them on once per flush
+// interval instead of after every run. Counters are summed over the buffered
+// runs while gauges keep their latest value.
+type metricsBuffer struct {
+	next metricsSender
+	mu   sync.Mutex
+	agg  *metricsAggregate
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newMetricsBuffer(next metricsSender, interval time.Duration) *metricsBuffer {
+	b := &metricsBuffer{
+		next: next,
+		agg:  newMetricsAggregate(),
+		stop: make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run(interval)
+	return b
+}
+
+// send adds a batch to the buffer. Metrics that are not well-formed counters or
+// gauges are rejected.
+func (b *metricsBuffer) send(metrics []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.agg.add(metrics)
+}
+
+func (b *metricsBuffer) run(interval time.Duration) {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.flush(); err != nil {
+				log.Printf("Error sending metrics to Netdata: %v", err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// flush sends the aggregated metrics, if any, and empties the buffer.
+func (b *metricsBuffer) flush() error {
+	b.mu.Lock()
+	metrics := b.agg.drain()
+	b.mu.Unlock()
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return b.next.send(metrics)
+}
+
+// Close stops the periodic flushes and sends what is still buffered.
+func (b *metricsBuffer) Close() error {
+	close(b.stop)
+	b.wg.Wait()
+	return b.flush()
+}
+
+// deleteSuccessRate returns the share of the transferred files that were
+// deleted, in percent. With --async-delete the deletes of a run may complete
+// in a later one, so the rate is capped at 100.
+func deleteSuccessRate(summary *Summary) float64 {
+	if summary.FilesTransferred == 0 {
+		return 0
+	}
+	return min(float64(summary.FilesDeleted)/float64(summary.FilesTransferred)*100.0, 100.0)
+}
+
+func sendToNetdata(sender metricsSender, summary *Summary, runCount int) error {
+	// Calculate derived metrics
+	megabytesTransferred := float64(summary.TotalBytes) / (1024 * 1024)
+	successRate := deleteSuccessRate(summary)
+
+	metrics := []string{
+		// Current run metrics (these reset each run)
+		fmt.Sprintf("s5commander.current.files_transferred:%d|g", summary.FilesTransferred),
+		fmt.Sprintf("s5commander.current.files_deleted:%d|g", summary.FilesDeleted),
+		fmt.Sprintf("s5commander.current.megabytes_transferred:%.2f|g", megabytesTransferred),
+		fmt.Sprintf("s5commander.current.files_failed_delete:%d|g", len(summary.FilesFailed)),
+		fmt.Sprintf("s5commander.current.success_rate:%.2f|g", successRate),
+		fmt.Sprintf("s5commander.current.exec_ms:%d|g", summary.ExecDuration.Milliseconds()),
+		fmt.Sprintf("s5commander.current.parse_ms:%d|g", summary.ParseDuration.Milliseconds()),
+		fmt.Sprintf("s5commander.current.key_collisions:%d|g", summary.KeyCollisions),
+		fmt.Sprintf("s5commander.current.key_too_long:%d|g", summary.KeysTooLong),
+		fmt.Sprintf("s5commander.current.owner_filtered:%d|g", summary.OwnerFiltered),
+		fmt.Sprintf("s5commander.current.files_failed_upload:%d|g", len(summary.UploadsFailed)),
+		fmt.Sprintf("s5commander.current.files_dead_lettered:%d|g", summary.FilesDeadLettered),
+		fmt.Sprintf("s5commander.consistency_violations:%d|c", summary.ConsistencyViolations),
+		fmt.Sprintf("s5commander.current.retries:%d|g", summary.Retries),
+		fmt.Sprintf("s5commander.current.deletes_withheld:%d|g", summary.DeletesWithheld),
+		fmt.Sprintf("s5commander.current.files_kept_by_size:%d|g", summary.FilesKeptBySize),
+		fmt.Sprintf("s5commander.current.stable_changed:%d|g", summary.StableChanged),
+		fmt.Sprintf("s5commander.current.waiting_for_token_ms:%d|g", summary.TokenWait.Milliseconds()),
+		fmt.Sprintf("s5commander.current.empty_files_skipped:%d|g", summary.EmptyFilesSkipped),
+		fmt.Sprintf("s5commander.current.malformed_output_lines:%d|g", summary.MalformedLines),
+		fmt.Sprintf("s5commander.current.progress_lines_skipped:%d|g", summary.ProgressLines),
+		fmt.Sprintf("s5commander.current.files_skipped_existing:%d|g", summary.SkippedExisting),
+		fmt.Sprintf("s5commander.current.count_discrepancy:%d|g", summary.CountDiscrepancy),
+		fmt.Sprintf("s5commander.current.bytes_shortfall:%d|g", summary.BytesShortfall),
+		fmt.Sprintf("s5commander.current.files_expired:%d|g", summary.FilesExpired),
+		fmt.Sprintf("s5commander.current.files_stuck:%d|g", summary.FilesStuck),
+		fmt.Sprintf("s5commander.current.delete_queue_depth:%d|g", summary.DeleteQueueDepth),
+		fmt.Sprintf("s5commander.current.source_vanished:%d|g", summary.SourceVanished),
+		fmt.Sprintf("s5commander.runs_without_destination:%d|c", summary.NoDestination),
+		fmt.Sprintf("s5commander.batch_deferred:%d|c", summary.BatchDeferred),
+		fmt.Sprintf("s5commander.empty_output_success:%d|c", summary.EmptyOutput),
+		fmt.Sprintf("s5commander.spawn_throttled:%d|c", summary.SpawnThrottled),
+		fmt.Sprintf("s5commander.empty_runs_skipped:%d|c", summary.EmptySkipped),
+		fmt.Sprintf("s5commander.load_throttled:%d|c", summary.LoadThrottled),
+		fmt.Sprintf("s5commander.throttled:%d|c", summary.Throttled),
+		fmt.Sprintf("s5commander.state_compacted:%d|c", summary.StateCompacted),
+		fmt.Sprintf("s5commander.window.partitions_touched:%d|g", summary.PartitionsTouched),
+		fmt.Sprintf("s5commander.suspect_runs:%d|c", summary.SuspectRuns),
+
+		// Operational metrics
+		fmt.Sprintf("s5commander.runs_completed:%d|c", runCount),
+		fmt.Sprintf("s5commander.last_activity:%d|g", time.Now().Unix()),
+
+		// Liveness metrics, sent on every tick including idle and failed runs
+		"s5commander.heartbeat:1|c",
+		fmt.Sprintf("s5commander.last_run_timestamp:%d|g", time.Now().Unix()),
+	}
+
+	if summary.FreeInodes > 0 {
+		inodePressure := 0
+		if summary.InodePressure {
+			inodePressure = 1
+		}
+		metrics = append(metrics,
+			fmt.Sprintf("s5commander.free_inodes:%d|g", summary.FreeInodes),
+			fmt.Sprintf("s5commander.inode_pressure:%d|g", inodePressure),
+		)
+	}
+	if summary.OpenFiles > 0 {
+		metrics = append(metrics, fmt.Sprintf("s5commander.open_files:%d|g", summary.OpenFiles))
+	}
+	mountLost := 0
+	if summary.MountLost {
+		mountLost = 1
+	}
+	metrics = append(metrics, fmt.Sprintf("s5commander.current.mount_lost:%d|g", mountLost))
+
+	if summary.RetryBudget != nil {
+		metrics = append(metrics, fmt.Sprintf("s5commander.current.retry_budget_remaining:%d|g", *summary.RetryBudget))
+	}
+
+	if summary.EffectiveConcurrency > 0 {
+		metrics = append(metrics, fmt.Sprintf("s5commander.current.effective_concurrency:%d|g", summary.EffectiveConcurrency))
+	}
+	// Reasons differing only in punctuation share a metric
+	bySlug := make(map[string]int)
+	for reason, count := range summary.FailureReasons {
+		bySlug[reasonSlug(reason)] += count
+	}
+	for _, slug := range sortedKeys(bySlug) {
+		metrics = append(metrics, fmt.Sprintf("s5commander.upload_failures.%s:%d|c", slug, bySlug[slug]))
+	}
+	for _, reason := range sortedKeys(summary.DeleteReasons) {
+		metrics = append(metrics, fmt.Sprintf("s5commander.delete_failures.%s:%d|c", reason, summary.DeleteReasons[reason]))
+	}
+	for _, binary := range sortedKeys(summary.BinaryRuns) {
+		metrics = append(metrics,
+			fmt.Sprintf("s5commander.binary.%s.runs:%d|c", binary, summary.BinaryRuns[binary]),
+			fmt.Sprintf("s5commander.binary.%s.runs_failed:%d|c", binary, summary.BinaryFailures[binary]),
+			fmt.Sprintf("s5commander.binary.%s.files_transferred:%d|c", binary, summary.BinaryFiles[binary]),
+		)
+	}
+	if summary.InterBatchDelay > 0 {
+		metrics = append(metrics, fmt.Sprintf("s5commander.current.inter_batch_delay_ms:%d|g", summary.InterBatchDelay.Milliseconds()))
+	}
+
+	if rt := summary.Runtime; rt != nil {
+		metrics = append(metrics,
+			fmt.Sprintf("s5commander.runtime.goroutines:%d|g", rt.Goroutines),
+			fmt.Sprintf("s5commander.runtime.heap_inuse_bytes:%d|g", rt.HeapInuse),
+			fmt.Sprintf("s5commander.runtime.gc_count:%d|g", rt.NumGC),
+			fmt.Sprintf("s5commander.runtime.gc_pause_total_ms:%d|g", rt.GCPause.Milliseconds()),
+			fmt.Sprintf("s5commander.runtime.gc_last_pause_us:%d|g", rt.LastPause.Microseconds()),
+		)
+	}
+	if p := summary.Percentiles; p != nil {
+		for i, name := range []string{"p50", "p90", "p99"} {
+			metrics = append(metrics,
+				fmt.Sprintf("s5commander.throughput.%s_megabytes_per_second:%.2f|g", name, p.Throughput[i]/(1024*1024)),
+				fmt.Sprintf("s5commander.run_duration.%s_ms:%d|g", name, int64(p.Duration[i]*1000)),
+			)
+		}
+		degraded := 0
+		if p.Degraded {
+			degraded = 1
+		}
+		metrics = append(metrics, fmt.Sprintf("s5commander.throughput.degraded:%d|g", degraded))
+	}
+
+	return sender.send(metrics)
+}
+
+// sendStartMetrics marks a start of the program, tagged with its build.
+func sendStartMetrics(sender metricsSender) error {
+	tags := fmt.Sprintf("|#version:%s,commit:%s", version, commit)
+	return sender.send([]string{
+		"s5commander.started:1|c" + tags,
+		"s5commander.build_info:1|g" + tags,
+	})
+}
+
+// sendProgressMetrics reports the uploads of the running run so far.
+func sendProgressMetrics(sender metricsSender, progress progressSnapshot) error {
+	metrics := []string{
+		fmt.Sprintf("s5commander.current.run_progress:%d|g", progress.Uploaded),
+		fmt.Sprintf("s5commander.current.run_progress_bytes:%d|g", progress.Bytes),
+		fmt.Sprintf("s5commander.current.run_progress_failed:%d|g", progress.Failed),
+	}
+	if progress.Planned > 0 {
+		metrics = append(metrics, fmt.Sprintf("s5commander.current.run_progress_planned:%d|g", progress.Planned))
+	}
+	return sender.send(metrics)
+}
+
+func sendShutdownMetrics(sender metricsSender, summary *Summary, totalRuns int) error {
+	megabytesTransferred := float64(summary.TotalBytes) / (1024 * 1024)
+	successRate := deleteSuccessRate(summary)
+
+	metrics := []string{
+		// Final session metrics
+		fmt.Sprintf("s5commander.session.final_files_transferred:%d|g", summary.FilesTransferred),
+		fmt.Sprintf("s5commander.session.final_files_deleted:%d|g", summary.FilesDeleted),
+		fmt.Sprintf("s5commander.session.final_megabytes_transferred:%.2f|g", megabytesTransferred),
+		fmt.Sprintf("s5commander.session.final_files_failed_delete:%d|g", len(summary.FilesFailed)),
+		fmt.Sprintf("s5commander.session.final_success_rate:%.2f|g", successRate),
+		fmt.Sprintf("s5commander.session.total_runs:%d|g", totalRuns),
+		fmt.Sprintf("s5commander.shutdown:%d|c", 1),
+	}
+
+	return sender.send(metrics)
+}
+
+// netdataSink is the MetricSink sending statsd metrics to Netdata.
+type netdataSink struct {
+	client     *netdataClient
+	sender     metricsSender
+	direct     metricsSender // the sender bypassing the buffer
+	dispatcher *metricsDispatcher
+	buffer     *metricsBuffer
+
+	// serializes sends, progress is reported from a goroutine of its own and
+	// the client isn't safe for concurrent use without the queue. A forced
+	// exit closes the sink while runs may still send.
+	mu     sync.Mutex
+	closed bool
+}
+
+// newNetdataSink probes Netdata once and, unless the queue is disabled, sends
+// metrics from a background goroutine. With a flush interval, metrics are
+// aggregated and sent once per interval.
+func newNetdataSink(cfg *Config) *netdataSink {
+	client := newNetdataClient(cfg.NetdataAddress, cfg.MetricDialTimeout, cfg.InstanceID, cfg.NetdataMaxDatagram, cfg.NetdataRedeliver)
+	if err := client.probe(cfg.NetdataProbeTimeout); err != nil {
+		log.Printf("Warning: Netdata is unreachable, metrics will be sent once it comes up: %v", err)
+	}
+
+	sink := &netdataSink{client: client, sender: client}
+	if cfg.MetricsQueueSize > 0 {
+		sink.dispatcher = newMetricsDispatcher(client, cfg.MetricsQueueSize)
+		sink.sender = sink.dispatcher
+	}
+	sink.direct = sink.sender
+	if cfg.MetricFlushInterval > 0 {
+		sink.buffer = newMetricsBuffer(sink.sender, cfg.MetricFlushInterval)
+		sink.sender = sink.buffer
+	}
+	return sink
+}
+
+// Started sends the start marker right away, also with a flush interval.
+func (s *netdataSink) Started() error {
+	return sendStartMetrics(s.direct)
+}
+
+func (s *netdataSink) RunCompleted(summary *Summary, runCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	return sendToNetdata(s.sender, summary, runCount)
+}
+
+func (s *netdataSink) Progress(progress progressSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	return sendProgressMetrics(s.sender, progress)
+}
+
+func (s *netdataSink) Shutdown(summary *Summary, totalRuns int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	return sendShutdownMetrics(s.sender, summary, totalRuns)
+}
+
+// Close flushes the buffered and queued metrics before closing the connection.
+// Metrics sent after it are dropped.
+func (s *netdataSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.buffer != nil {
+		if err := s.buffer.Close(); err != nil {
+			log.Printf("Error sending metrics to Netdata: %v", err)
+		}
+	}
+	if s.dispatcher != nil {
+		s.dispatcher.Close()
+	}
+	return s.client.Close()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeleteSuccessRate(t *testing.T) {
+	for _, tc := range []struct {
+		transferred, deleted int
+		want                 float64
+	}{
+		{0, 0, 0},
+		{4, 3, 75},
+		{4, 4, 100},
+		// Deletes of an earlier run completed by the async delete queue
+		{4, 6, 100},
+	} {
+		got := deleteSuccessRate(&Summary{FilesTransferred: tc.transferred, FilesDeleted: tc.deleted})
+		if got != tc.want {
+			t.Errorf("%d transferred, %d deleted: got %.2f%%, want %.2f%%", tc.transferred, tc.deleted, got, tc.want)
+		}
+	}
+}
+
+// recordingSender keeps the batches sent to it. With block set, every send
+// first reports itself on entered and waits for block to be closed.
+type recordingSender struct {
+	mu      sync.Mutex
+	batches [][]string
+	entered chan struct{}
+	block   chan struct{}
+}
+
+func (s *recordingSender) send(metrics []string) error {
+	if s.block != nil {
+		s.entered <- struct{}{}
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, metrics)
+	return nil
+}
+
+func TestMetricsDispatcherDropsOldestBehindSlowSink(t *testing.T) {
+	sink := &recordingSender{entered: make(chan struct{}, 10), block: make(chan struct{})}
+	d := newMetricsDispatcher(sink, 2)
+
+	d.send([]string{"a:1|c"})
+	<-sink.entered
+	// The sink is stuck on the first batch, the queue holds two more
+	done := make(chan struct{})
+	go func() {
+		for _, metric := range []string{"b:1|c", "c:1|c", "d:1|c"} {
+			d.send([]string{metric})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked on the stalled sink")
+	}
+
+	close(sink.block)
+	d.Close()
+	want := [][]string{{"a:1|c"}, {"c:1|c", "s5commander.metrics_dropped:1|c"}, {"d:1|c"}}
+	if !reflect.DeepEqual(sink.batches, want) {
+		t.Errorf("got batches %q, want %q", sink.batches, want)
+	}
+}
+
+func TestMetricsBufferAggregatesRunsUntilFlush(t *testing.T) {
+	sink := &recordingSender{}
+	b := newMetricsBuffer(sink, time.Hour)
+
+	for _, run := range [][]string{
+		{"s5commander.files_transferred:3|c", "s5commander.current.files_pending:10|g"},
+		{"s5commander.files_transferred:4|c", "s5commander.upload_failures.denied:1|c|#bucket:a"},
+		{"s5commander.files_transferred:1|c", "s5commander.current.files_pending:2|g", "s5commander.upload_failures.denied:2|c|#bucket:b"},
+	} {
+		if err := b.send(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sink.batches) != 0 {
+		t.Fatalf("got batches %q before the flush, want none", sink.batches)
+	}
+	if err := b.send([]string{"not a metric"}); err == nil {
+		t.Error("a malformed metric was accepted")
+	}
+
+	if err := b.flush(); err != nil {
+		t.Fatal(err)
+	}
+	b.send([]string{"s5commander.files_transferred:5|c"})
+	b.Close()
+
+	want := [][]string{
+		{
+			"s5commander.files_transferred:8|c",
+			"s5commander.current.files_pending:2|g",
+			"s5commander.upload_failures.denied:1|c|#bucket:a",
+			"s5commander.upload_failures.denied:2|c|#bucket:b",
+		},
+		// Counted once, the first flush emptied the buffer
+		{"s5commander.files_transferred:5|c"},
+	}
+	if !reflect.DeepEqual(sink.batches, want) {
+		t.Errorf("got batches %q, want %q", sink.batches, want)
+	}
+}
+
+// flakyConn records the datagrams written to it and fails the writes from
+// failFrom on, negative for never.
+type flakyConn struct {
+	net.Conn
+	datagrams []string
+	writes    int
+	failFrom  int
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	c.writes++
+	if c.failFrom >= 0 && c.writes > c.failFrom {
+		return 0, errors.New("connection refused")
+	}
+	c.datagrams = append(c.datagrams, string(b))
+	return len(b), nil
+}
+
+func (c *flakyConn) Close() error { return nil }
+
+func TestNetdataRedeliveryCountsOnce(t *testing.T) {
+	client := newNetdataClient("127.0.0.1:0", time.Second, "test", 0, true)
+	conn := &flakyConn{failFrom: 1}
+	client.conn = conn
+
+	// Only the first datagram goes out
+	if err := client.send([]string{"a:1|c", "b:2|c", "a:3|c"}); err == nil {
+		t.Fatal("the failed send reported no error")
+	}
+	// Netdata is back, the undelivered metrics go out ahead of the new ones
+	conn.failFrom = -1
+	client.conn = conn
+	if err := client.send([]string{"a:4|c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	totals := make(map[string]float64)
+	for _, datagram := range conn.datagrams {
+		for _, metric := range strings.Split(datagram, "\n") {
+			name, rest, _ := strings.Cut(metric, ":")
+			value, _, _ := strings.Cut(rest, "|")
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("malformed metric %q", metric)
+			}
+			totals[name] += n
+		}
+	}
+	if want := map[string]float64{"a": 8, "b": 2}; !reflect.DeepEqual(totals, want) {
+		t.Errorf("got counter totals %v over datagrams %q, want %v", totals, conn.datagrams, want)
+	}
+}
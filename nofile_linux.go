@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFileLimit returns the soft and hard limit on open files of the process.
+func openFileLimit() (soft, hard uint64, err error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, err
+	}
+	return limit.Cur, limit.Max, nil
+}
+
+// setOpenFileLimit sets the soft and hard limit on open files of the process.
+func setOpenFileLimit(soft, hard uint64) error {
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: soft, Max: hard})
+}
+
+// openFileCount returns the number of file descriptors the process has open.
+func openFileCount() (int, error) {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	// Reading the directory takes a descriptor itself
+	return len(names) - 1, nil
+}
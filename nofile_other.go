@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errOpenFilesUnsupported = errors.New("open file limits are not supported on this platform")
+
+// openFileLimit is only implemented on Linux.
+func openFileLimit() (soft, hard uint64, err error) {
+	return 0, 0, errOpenFilesUnsupported
+}
+
+// setOpenFileLimit is only implemented on Linux.
+func setOpenFileLimit(soft, hard uint64) error {
+	return errOpenFilesUnsupported
+}
+
+// openFileCount is only implemented on Linux.
+func openFileCount() (int, error) {
+	return 0, errOpenFilesUnsupported
+}
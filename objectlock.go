@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// objectLockModes are the S3 object lock retention modes.
+var objectLockModes = []string{"GOVERNANCE", "COMPLIANCE"}
+
+// parseObjectLock validates an object lock mode and retain-until date, accepted
+// as RFC 3339 or as a plain date meaning midnight UTC, and returns the mode in
+// upper case and the date in RFC 3339. Both must be set or neither.
+func parseObjectLock(mode, retainUntil string, now time.Time) (string, string, error) {
+	if mode == "" && retainUntil == "" {
+		return "", "", nil
+	}
+	if mode == "" || retainUntil == "" {
+		return "", "", fmt.Errorf("object-lock-mode and object-lock-retain-until must be set together")
+	}
+
+	mode = strings.ToUpper(mode)
+	valid := false
+	for _, m := range objectLockModes {
+		valid = valid || mode == m
+	}
+	if !valid {
+		return "", "", fmt.Errorf("invalid object-lock-mode %q, expected one of %s", mode, strings.Join(objectLockModes, ", "))
+	}
+
+	until, err := time.Parse(time.RFC3339, retainUntil)
+	if err != nil {
+		until, err = time.Parse(time.DateOnly, retainUntil)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("invalid object-lock-retain-until %q, expected a date such as 2030-01-31 or 2030-01-31T00:00:00Z", retainUntil)
+	}
+	if !until.After(now) {
+		return "", "", fmt.Errorf("object-lock-retain-until %s is not in the future", retainUntil)
+	}
+	return mode, until.UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import "log"
+
+// dirLimiter bounds the number of directories held open at once by all
+// enumerations together. A nil *dirLimiter is unlimited.
+type dirLimiter struct {
+	tokens chan struct{}
+}
+
+func newDirLimiter(n int) *dirLimiter {
+	return &dirLimiter{tokens: make(chan struct{}, n)}
+}
+
+// acquire takes a token, waiting until one is free.
+func (l *dirLimiter) acquire() {
+	if l != nil {
+		l.tokens <- struct{}{}
+	}
+}
+
+// tryAcquire takes a token if one is free and reports whether it did.
+func (l *dirLimiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.tokens <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release returns a token taken by acquire or tryAcquire.
+func (l *dirLimiter) release() {
+	if l != nil {
+		<-l.tokens
+	}
+}
+
+const (
+	// assumedWalkDepth is the number of directories an unlimited enumeration is
+	// assumed to hold open, one per level of the spool tree
+	assumedWalkDepth = 16
+	// openFilesPerRun is the number of descriptors a running s5cmd takes in
+	// this process: its output and error files and the pipe feeding it
+	openFilesPerRun = 4
+	// openFilesHeadroom covers the log, state and metric files and connections
+	openFilesHeadroom = 64
+)
+
+// openFilesNeeded estimates how many file descriptors the configured
+// concurrency needs at most.
+func openFilesNeeded(cfg *Config) uint64 {
+	walkers := 1
+	if cfg.SplitBySubdir {
+		walkers = cfg.SubdirConcurrency
+	}
+	runs := walkers
+	if cfg.MaxS5cmdProcesses > 0 {
+		runs = min(runs, cfg.MaxS5cmdProcesses)
+	}
+	dirs := walkers * assumedWalkDepth
+	if cfg.MaxOpenDirs > 0 {
+		dirs = cfg.MaxOpenDirs
+	}
+	return uint64(dirs + runs*openFilesPerRun + openFilesHeadroom)
+}
+
+// checkOpenFileLimit raises the soft limit on open files when it is below what
+// the configured concurrency needs and the hard limit permits, and warns when
+// it can't. Platforms without the limit are not checked.
+func checkOpenFileLimit(cfg *Config) {
+	soft, hard, err := openFileLimit()
+	if err != nil {
+		return
+	}
+	needed := openFilesNeeded(cfg)
+	if soft >= needed {
+		return
+	}
+
+	if hard >= needed {
+		err := setOpenFileLimit(needed, hard)
+		if err == nil {
+			log.Printf("Raised the open file limit from %d to %d for the configured concurrency", soft, needed)
+			return
+		}
+		log.Printf("Error raising the open file limit: %v", err)
+	}
+	log.Printf("Warning: the open file limit of %d (hard limit %d) may be too low for the configured concurrency, which needs up to %d; set max-open-dirs or lower the concurrency", soft, hard, needed)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// runOutput is the summary of a --once invocation printed with --output-json.
+// Unlike the shutdown report, it lists all failed files.
+type runOutput struct {
+	JobIDs            []string `json:"job_ids"`
+	Runs              int      `json:"runs"`
+	DurationSeconds   float64  `json:"duration_seconds"`
+	FilesTransferred  int      `json:"files_transferred"`
+	FilesDeleted      int      `json:"files_deleted"`
+	BytesTransferred  int64    `json:"bytes_transferred"`
+	FilesDeadLettered int      `json:"files_dead_lettered"`
+	FilesExpired      int      `json:"files_expired"`
+	SourceVanished    int      `json:"source_vanished"`
+	Retries           int      `json:"retries"`
+	FilesFailedDelete []string `json:"files_failed_delete"`
+	FilesFailedUpload []string `json:"files_failed_upload"`
+}
+
+// newRunOutput builds the output of the runs summarized in session, which
+// started at startedAt.
+func newRunOutput(session *Summary, runs int, startedAt time.Time) runOutput {
+	// Empty lists are rendered as [] rather than null, for simpler scripts
+	orEmpty := func(list []string) []string {
+		if list == nil {
+			return []string{}
+		}
+		return list
+	}
+	return runOutput{
+		JobIDs:            orEmpty(session.JobIDs),
+		Runs:              runs,
+		DurationSeconds:   time.Since(startedAt).Seconds(),
+		FilesTransferred:  session.FilesTransferred,
+		FilesDeleted:      session.FilesDeleted,
+		BytesTransferred:  session.TotalBytes,
+		FilesDeadLettered: session.FilesDeadLettered,
+		FilesExpired:      session.FilesExpired,
+		SourceVanished:    session.SourceVanished,
+		Retries:           session.Retries,
+		FilesFailedDelete: orEmpty(session.FilesFailed),
+		FilesFailedUpload: orEmpty(session.UploadsFailed),
+	}
+}
+
+// writeRunOutput writes output to w as a single line of JSON.
+func writeRunOutput(w io.Writer, output runOutput) error {
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		return fmt.Errorf("error encoding run output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// sampleJobID stands in for the job IDs naming the work files of runs.
+const sampleJobID = "00000000-0000-0000-0000-000000000000"
+
+// localPath is a local file or directory written by s5-commander, named by
+// its setting.
+type localPath struct {
+	name string
+	path string
+}
+
+// checkOverlaps makes sure that nothing s5-commander writes locally is picked
+// up by the enumeration of folder-prefix again, which would upload its own
+// artifacts or move files in a loop. Directories below folder-prefix are left
+// out of enumeration, everything else that overlaps is an error.
+func checkOverlaps(cfg *Config) error {
+	dirs := []localPath{{"dead-letter-dir", cfg.DeadLetterDir}, {"output-archive-dir", cfg.OutputArchiveDir}}
+	if cfg.StableCopy {
+		dirs = append(dirs, localPath{"work-dir", cfg.WorkDir})
+	}
+	for _, d := range dirs {
+		if d.path == "" {
+			continue
+		}
+		if filepath.Clean(d.path) == filepath.Clean(cfg.FolderPrefix) || isUnder(cfg.FolderPrefix, d.path) {
+			return fmt.Errorf("%s %s must not contain folder-prefix %s", d.name, d.path, cfg.FolderPrefix)
+		}
+		if isUnder(d.path, cfg.FolderPrefix) {
+			// Documented for the work directory, which is always left out
+			if d.name != "work-dir" {
+				log.Printf("Warning: %s %s is under folder-prefix, leaving it out of enumeration", d.name, d.path)
+			}
+			cfg.excludedDirs = append(cfg.excludedDirs, filepath.Clean(d.path))
+		}
+	}
+
+	re, err := globRegexp(sourcePattern(cfg.FolderPrefix, cfg.PathSuffix))
+	if err != nil {
+		return fmt.Errorf("error compiling source pattern: %w", err)
+	}
+	files := []localPath{
+		{"state-file", cfg.StateFile},
+		{"ready-file", cfg.ReadyFile},
+		{"shutdown-report", cfg.ShutdownReport},
+		{"prometheus-textfile", cfg.PrometheusTextfile},
+	}
+	// Job output files are written to the working directory
+	if wd, err := os.Getwd(); err == nil {
+		files = append(files,
+			localPath{"job output", filepath.Join(wd, sampleJobID+".json")},
+			localPath{"job output", filepath.Join(wd, sampleJobID+".stderr.json")},
+			localPath{"job commands", filepath.Join(wd, sampleJobID+".commands")},
+		)
+	}
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		path, err := filepath.Abs(f.path)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(path) {
+			return fmt.Errorf("%s %s matches folder-prefix and path-suffix and would be uploaded", f.name, path)
+		}
+	}
+	return nil
+}
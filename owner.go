@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// parseIDs parses a comma-separated list of numeric user or group IDs.
+func parseIDs(spec string) ([]uint32, error) {
+	var ids []uint32
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", field)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+// ownerAllowed reports whether the file described by info is owned by one of
+// the allowed users and groups. Files whose owner can't be read are not.
+func ownerAllowed(cfg *Config, info fs.FileInfo) bool {
+	if cfg.allowedUIDs == nil && cfg.allowedGIDs == nil {
+		return true
+	}
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return false
+	}
+	if cfg.allowedUIDs != nil && !slices.Contains(cfg.allowedUIDs, uid) {
+		return false
+	}
+	return cfg.allowedGIDs == nil || slices.Contains(cfg.allowedGIDs, gid)
+}
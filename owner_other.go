@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+const ownerSupported = false
+
+// fileOwner is only implemented on Unix systems.
+func fileOwner(info fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// batchPacer spaces out the s5cmd invocations of a run. After an invocation
+// was throttled, the delay doubles up to max; after one that wasn't, it returns
+// to the base delay.
+type batchPacer struct {
+	mu    sync.Mutex
+	base  time.Duration
+	max   time.Duration
+	delay time.Duration
+}
+
+func newBatchPacer(base, max time.Duration) *batchPacer {
+	return &batchPacer{base: base, max: max, delay: base}
+}
+
+// current returns the delay before the next invocation.
+func (p *batchPacer) current() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.delay
+}
+
+// observe adapts the delay to the outcome of an invocation.
+func (p *batchPacer) observe(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil && classOf(err) == errorClassThrottled {
+		p.delay = min(p.delay*2, max(p.max, p.base))
+	} else {
+		p.delay = p.base
+	}
+}
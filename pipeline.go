@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// uploadStream feeds s5cmd run the commands of a job while the spool is still
+// being enumerated, so that uploads start with the first file found instead of
+// after the last one. It is read as s5cmd's stdin.
+type uploadStream struct {
+	*io.PipeReader
+	done chan struct{}
+
+	// set once done is closed
+	commands int
+	planned  Summary
+	err      error
+}
+
+// startUploadStream starts enumerating the files matching srcPath, writing a
+// cp command per file to the stream as they are found.
+func startUploadStream(cfg *Config, jobID, srcPath string) *uploadStream {
+	reader, writer := io.Pipe()
+	s := &uploadStream{PipeReader: reader, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		var lines []uint64
+		lines, _, s.planned, s.err = writeUploads(cfg, jobID, srcPath, writer)
+		s.commands = len(lines)
+		writer.CloseWithError(s.err)
+	}()
+	return s
+}
+
+// finish waits for the enumeration to end and returns the number of commands
+// written and the counters collected while planning. It is called once s5cmd
+// exited, the commands it didn't read any more are discarded.
+func (s *uploadStream) finish() (int, Summary, error) {
+	s.PipeReader.Close()
+	<-s.done
+	if errors.Is(s.err, io.ErrClosedPipe) {
+		// s5cmd stopped reading, e.g. it failed fast, its error is reported
+		s.err = nil
+	}
+	return s.commands, s.planned, s.err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ioPriority is an I/O scheduling class and level as understood by ionice.
+type ioPriority struct {
+	class int // 1 realtime, 2 best-effort, 3 idle
+	level int // 0 (highest) to 7 (lowest), -1 for the default of the class
+}
+
+var ioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// parseIONice parses an I/O priority given as class[:level], e.g. "idle" or
+// "best-effort:7".
+func parseIONice(s string) (ioPriority, error) {
+	name, levelStr, hasLevel := strings.Cut(s, ":")
+	class, ok := ioClasses[name]
+	if !ok {
+		return ioPriority{}, fmt.Errorf("unknown I/O scheduling class %q, must be realtime, best-effort or idle", name)
+	}
+	prio := ioPriority{class: class, level: -1}
+	if !hasLevel {
+		return prio, nil
+	}
+	if class == 3 {
+		return ioPriority{}, fmt.Errorf("the idle I/O scheduling class takes no level")
+	}
+	level, err := strconv.Atoi(levelStr)
+	if err != nil || level < 0 || level > 7 {
+		return ioPriority{}, fmt.Errorf("I/O priority level %q must be between 0 and 7", levelStr)
+	}
+	prio.level = level
+	return prio, nil
+}
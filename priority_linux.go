@@ -0,0 +1,41 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// checkPriority makes sure the tools lowering the priority of s5cmd exist.
+func checkPriority(cfg *Config) error {
+	if cfg.Nice != 0 {
+		if _, err := exec.LookPath("nice"); err != nil {
+			return fmt.Errorf("nice is set but the nice command is not available: %w", err)
+		}
+	}
+	if cfg.ioPriority != nil {
+		if _, err := exec.LookPath("ionice"); err != nil {
+			return fmt.Errorf("ionice is set but the ionice command is not available: %w", err)
+		}
+	}
+	return nil
+}
+
+// priorityArgs prefixes the command line argv with nice and ionice as
+// configured. Launching s5cmd through them, rather than changing its priority
+// once it runs, covers every thread s5cmd starts.
+func priorityArgs(cfg *Config, argv []string) []string {
+	var prefix []string
+	if cfg.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(cfg.Nice))
+	}
+	if p := cfg.ioPriority; p != nil {
+		prefix = append(prefix, "ionice", "-c", strconv.Itoa(p.class))
+		if p.level >= 0 {
+			prefix = append(prefix, "-n", strconv.Itoa(p.level))
+		}
+	}
+	return append(prefix, argv...)
+}
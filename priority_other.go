@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"log"
+	"runtime"
+)
+
+// Process priorities are only implemented on Linux.
+func checkPriority(cfg *Config) error {
+	if cfg.Nice != 0 || cfg.ioPriority != nil {
+		log.Printf("Warning: nice and ionice are not supported on %s and are ignored", runtime.GOOS)
+	}
+	return nil
+}
+
+func priorityArgs(cfg *Config, argv []string) []string {
+	return argv
+}
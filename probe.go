@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+// verifyWriteAccess uploads a probe object to the destination and deletes it
+// again, so that missing permissions surface at startup instead of in the
+// first run. The probe is deleted even if its upload seemingly failed, s5cmd
+// may have written it before reporting an error.
+func verifyWriteAccess(cfg *Config) error {
+	probeID, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("error generating probe ID: %w", err)
+	}
+	localFile := fmt.Sprintf("%s.probe", probeID)
+	defer os.Remove(localFile)
+	if err := os.WriteFile(localFile, []byte("s5-commander write probe\n"), 0o644); err != nil {
+		return fmt.Errorf("error writing probe file: %w", err)
+	}
+
+	outputFile := fmt.Sprintf("%s.json", probeID)
+	defer os.Remove(outputFile)
+	dest := joinDestination(cfg.S3BucketPath, fmt.Sprintf(".s5-commander-probe-%s", probeID))
+
+	cpErr := runS5cmd(cfg, []string{"cp", localFile, dest}, outputFile, outputFile)
+	if cpErr != nil {
+		cpErr = fmt.Errorf("cannot write to %s (class %s): %s", dest, classifyOutput(cfg, outputFile), firstError(cfg, outputFile, cpErr))
+	}
+	rmErr := runS5cmd(cfg, []string{"rm", dest}, outputFile, outputFile)
+	if cpErr != nil {
+		return cpErr
+	}
+	if rmErr != nil {
+		return fmt.Errorf("cannot delete the probe object %s, remove it manually (class %s): %s", dest, classifyOutput(cfg, outputFile), firstError(cfg, outputFile, rmErr))
+	}
+	log.Printf("Verified write and delete access to %s", cfg.S3BucketPath)
+	return nil
+}
+
+// firstError returns the first error message in the s5cmd output file, or
+// fallback if there is none.
+func firstError(cfg *Config, outputFile string, fallback error) string {
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return fallback.Error()
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var result JobResult
+		if err := decodeResult(cfg, scanner.Bytes(), &result); err == nil && result.Error != "" {
+			return result.Error
+		}
+	}
+	return fallback.Error()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// nonResultRecord is the part of an s5cmd record that tells progress and log
+// records apart from the results of operations.
+type nonResultRecord struct {
+	Type  string `json:"type"`
+	Level string `json:"level"`
+}
+
+// progressTypes are the values of the type field of records reporting progress.
+var progressTypes = map[string]bool{"progress": true, "status": true}
+
+// isNonResult reports whether line is a progress or log record. It is only
+// called for records without an operation, results are never decoded twice.
+func isNonResult(line []byte) bool {
+	var record nonResultRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return false
+	}
+	return progressTypes[strings.ToLower(record.Type)] || record.Level != ""
+}
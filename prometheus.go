@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// promMetric describes a metric exposed in the Prometheus text format.
+type promMetric struct {
+	help string
+	kind string // counter or gauge
+}
+
+var promMetrics = map[string]promMetric{
+	"s5commander_runs_total":                      {"Processing runs completed.", "counter"},
+	"s5commander_files_transferred_total":         {"Files successfully transferred.", "counter"},
+	"s5commander_files_deleted_total":             {"Files deleted locally after transfer.", "counter"},
+	"s5commander_bytes_transferred_total":         {"Bytes transferred.", "counter"},
+	"s5commander_files_failed_delete_total":       {"Transferred files that failed to delete.", "counter"},
+	"s5commander_files_failed_upload_total":       {"Files s5cmd failed to upload.", "counter"},
+	"s5commander_files_dead_lettered_total":       {"Files moved to the dead-letter directory.", "counter"},
+	"s5commander_key_collisions_total":            {"Files skipped because their object key clashed with another file.", "counter"},
+	"s5commander_key_too_long_total":              {"Files skipped because their object key exceeded max-key-length.", "counter"},
+	"s5commander_owner_filtered_total":            {"Files left out because of their owner or group, counted on every run they are seen.", "counter"},
+	"s5commander_retries_total":                   {"Runs retried after a retryable error.", "counter"},
+	"s5commander_deletes_withheld_total":          {"Transferred files kept locally because their run had failures.", "counter"},
+	"s5commander_files_kept_by_size_total":        {"Transferred files kept locally because they are larger than keep-larger-than.", "counter"},
+	"s5commander_stable_changed_total":            {"Transferred stable copies whose original changed after the copy was taken and was kept.", "counter"},
+	"s5commander_consistency_violations_total":    {"Runs that deleted more files than they transferred.", "counter"},
+	"s5commander_waiting_for_token_seconds_total": {"Seconds runs spent waiting for a coordination token.", "counter"},
+	"s5commander_empty_files_skipped_total":       {"Zero-byte files not uploaded, counted on every run they are seen.", "counter"},
+	"s5commander_malformed_output_lines_total":    {"Lines of the s5cmd output that could not be parsed.", "counter"},
+	"s5commander_suspect_runs_total":              {"Runs with more malformed output lines than allowed.", "counter"},
+	"s5commander_progress_lines_skipped_total":    {"Progress and log records of the s5cmd output skipped while parsing.", "counter"},
+	"s5commander_files_skipped_existing_total":    {"Files not uploaded because their object already exists.", "counter"},
+	"s5commander_count_discrepancy_total":         {"Difference between the copy totals of s5cmd and the parsed records.", "counter"},
+	"s5commander_bytes_shortfall_total":           {"Bytes enumerated for upload but not reported transferred by runs without failures.", "counter"},
+	"s5commander_files_expired_total":             {"Files deleted without upload because they exceeded max-age-delete.", "counter"},
+	"s5commander_source_vanished_total":           {"Files removed by others between enumeration and upload.", "counter"},
+	"s5commander_runs_without_destination_total":  {"Runs skipped because the destination file was missing or invalid.", "counter"},
+	"s5commander_batch_deferred_total":            {"Runs held back because fewer files than min-batch-files were pending.", "counter"},
+	"s5commander_empty_output_success_total":      {"s5cmd invocations that succeeded without writing any output.", "counter"},
+	"s5commander_spawn_throttled_total":           {"s5cmd starts delayed by the spawn rate limit.", "counter"},
+	"s5commander_empty_runs_skipped_total":        {"Runs skipped without starting s5cmd because no files matched.", "counter"},
+	"s5commander_load_throttled_total":            {"Runs skipped because the load average was above max-load-average.", "counter"},
+	"s5commander_throttled_total":                 {"s5cmd invocations that failed because the endpoint throttled.", "counter"},
+	"s5commander_state_compacted_total":           {"State entries dropped because their file no longer exists.", "counter"},
+	"s5commander_last_run_files_stuck":            {"Files left out of the last run because they repeatedly failed to delete.", "gauge"},
+	"s5commander_delete_queue_depth":              {"Files waiting in the delete queue at the end of the last run.", "gauge"},
+	"s5commander_last_run_timestamp_seconds":      {"Unix time of the last run.", "gauge"},
+	"s5commander_start_time_seconds":              {"Unix time the program started.", "gauge"},
+	"s5commander_last_run_files_transferred":      {"Files transferred in the last run.", "gauge"},
+	"s5commander_last_run_bytes_transferred":      {"Bytes transferred in the last run.", "gauge"},
+	"s5commander_last_run_exec_seconds":           {"Seconds spent waiting for s5cmd in the last run.", "gauge"},
+	"s5commander_last_run_parse_seconds":          {"Seconds spent parsing and deleting in the last run.", "gauge"},
+	"s5commander_free_inodes":                     {"Free inodes on the spool filesystem.", "gauge"},
+	"s5commander_open_files":                      {"File descriptors open at the end of the last run.", "gauge"},
+	"s5commander_inode_pressure":                  {"1 while free inodes are below the configured minimum.", "gauge"},
+	"s5commander_mount_lost":                      {"1 while the spool has been missing for longer than mount-loss-grace.", "gauge"},
+	"s5commander_retry_budget_remaining":          {"Retries left in the current summary window.", "gauge"},
+	"s5commander_effective_concurrency":           {"Concurrent s5cmd invocations the concurrency ramp reached in the last run.", "gauge"},
+	"s5commander_inter_batch_delay_seconds":       {"Pause between the s5cmd invocations of a split-by-subdir run at the end of the last run.", "gauge"},
+	"s5commander_partitions_touched":              {"Distinct partitions files were transferred from in the current summary window.", "gauge"},
+	"s5commander_throughput_p50_bytes_per_second": {"Median throughput of the runs in the throughput window.", "gauge"},
+	"s5commander_throughput_p90_bytes_per_second": {"90th percentile throughput of the runs in the throughput window.", "gauge"},
+	"s5commander_throughput_p99_bytes_per_second": {"99th percentile throughput of the runs in the throughput window.", "gauge"},
+	"s5commander_run_duration_p50_seconds":        {"Median duration of the runs in the throughput window.", "gauge"},
+	"s5commander_run_duration_p90_seconds":        {"90th percentile duration of the runs in the throughput window.", "gauge"},
+	"s5commander_run_duration_p99_seconds":        {"99th percentile duration of the runs in the throughput window.", "gauge"},
+	"s5commander_throughput_degraded":             {"1 if the last run was slower than the configured share of the throughput window median.", "gauge"},
+	"s5commander_run_progress":                    {"Files uploaded so far by the running run, reported every progress-interval.", "gauge"},
+	"s5commander_run_progress_bytes":              {"Bytes uploaded so far by the running run, reported every progress-interval.", "gauge"},
+	"s5commander_runtime_goroutines":              {"Goroutines of the program at the end of the last summary window.", "gauge"},
+	"s5commander_runtime_heap_inuse_bytes":        {"Heap in use by the program at the end of the last summary window.", "gauge"},
+	"s5commander_runtime_gc_total":                {"Garbage collections since startup.", "counter"},
+	"s5commander_runtime_gc_pause_seconds_total":  {"Total garbage collection pause since startup.", "counter"},
+	"s5commander_runtime_gc_last_pause_seconds":   {"Pause of the latest garbage collection.", "gauge"},
+}
+
+// promRegistry holds the current values of the Prometheus metrics.
+type promRegistry struct {
+	mu     sync.Mutex
+	values map[string]float64
+	labels string // rendered label set of every sample
+}
+
+func newPromRegistry(instanceID string) *promRegistry {
+	return &promRegistry{
+		values: make(map[string]float64),
+		labels: fmt.Sprintf(`{instance_id=%q}`, instanceID),
+	}
+}
+
+// started records the start time of the program.
+func (r *promRegistry) started() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values["s5commander_start_time_seconds"] = float64(time.Now().Unix())
+}
+
+// observe adds the counters of summary and sets the last-run gauges.
+func (r *promRegistry) observe(summary *Summary, runCount int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.values["s5commander_runs_total"] += float64(runCount)
+	r.values["s5commander_files_transferred_total"] += float64(summary.FilesTransferred)
+	r.values["s5commander_files_deleted_total"] += float64(summary.FilesDeleted)
+	r.values["s5commander_bytes_transferred_total"] += float64(summary.TotalBytes)
+	r.values["s5commander_files_failed_delete_total"] += float64(len(summary.FilesFailed))
+	r.values["s5commander_files_failed_upload_total"] += float64(len(summary.UploadsFailed))
+	r.values["s5commander_files_dead_lettered_total"] += float64(summary.FilesDeadLettered)
+	r.values["s5commander_key_collisions_total"] += float64(summary.KeyCollisions)
+	r.values["s5commander_key_too_long_total"] += float64(summary.KeysTooLong)
+	r.values["s5commander_owner_filtered_total"] += float64(summary.OwnerFiltered)
+	r.values["s5commander_retries_total"] += float64(summary.Retries)
+	r.values["s5commander_deletes_withheld_total"] += float64(summary.DeletesWithheld)
+	r.values["s5commander_files_kept_by_size_total"] += float64(summary.FilesKeptBySize)
+	r.values["s5commander_stable_changed_total"] += float64(summary.StableChanged)
+	r.values["s5commander_consistency_violations_total"] += float64(summary.ConsistencyViolations)
+	r.values["s5commander_waiting_for_token_seconds_total"] += summary.TokenWait.Seconds()
+	r.values["s5commander_empty_files_skipped_total"] += float64(summary.EmptyFilesSkipped)
+	r.values["s5commander_malformed_output_lines_total"] += float64(summary.MalformedLines)
+	r.values["s5commander_suspect_runs_total"] += float64(summary.SuspectRuns)
+	r.values["s5commander_progress_lines_skipped_total"] += float64(summary.ProgressLines)
+	r.values["s5commander_files_skipped_existing_total"] += float64(summary.SkippedExisting)
+	r.values["s5commander_count_discrepancy_total"] += float64(summary.CountDiscrepancy)
+	r.values["s5commander_bytes_shortfall_total"] += float64(summary.BytesShortfall)
+	r.values["s5commander_files_expired_total"] += float64(summary.FilesExpired)
+	r.values["s5commander_source_vanished_total"] += float64(summary.SourceVanished)
+	r.values["s5commander_runs_without_destination_total"] += float64(summary.NoDestination)
+	r.values["s5commander_batch_deferred_total"] += float64(summary.BatchDeferred)
+	r.values["s5commander_empty_output_success_total"] += float64(summary.EmptyOutput)
+	r.values["s5commander_spawn_throttled_total"] += float64(summary.SpawnThrottled)
+	r.values["s5commander_empty_runs_skipped_total"] += float64(summary.EmptySkipped)
+	r.values["s5commander_load_throttled_total"] += float64(summary.LoadThrottled)
+	r.values["s5commander_throttled_total"] += float64(summary.Throttled)
+	r.values["s5commander_state_compacted_total"] += float64(summary.StateCompacted)
+
+	r.values["s5commander_last_run_timestamp_seconds"] = float64(time.Now().Unix())
+	r.values["s5commander_last_run_files_transferred"] = float64(summary.FilesTransferred)
+	r.values["s5commander_last_run_bytes_transferred"] = float64(summary.TotalBytes)
+	r.values["s5commander_last_run_exec_seconds"] = summary.ExecDuration.Seconds()
+	r.values["s5commander_last_run_parse_seconds"] = summary.ParseDuration.Seconds()
+	r.values["s5commander_last_run_files_stuck"] = float64(summary.FilesStuck)
+	r.values["s5commander_delete_queue_depth"] = float64(summary.DeleteQueueDepth)
+	r.values["s5commander_partitions_touched"] = float64(summary.PartitionsTouched)
+	r.values["s5commander_mount_lost"] = 0
+	if summary.MountLost {
+		r.values["s5commander_mount_lost"] = 1
+	}
+
+	if summary.OpenFiles > 0 {
+		r.values["s5commander_open_files"] = float64(summary.OpenFiles)
+	}
+	if summary.FreeInodes > 0 {
+		r.values["s5commander_free_inodes"] = float64(summary.FreeInodes)
+		r.values["s5commander_inode_pressure"] = 0
+		if summary.InodePressure {
+			r.values["s5commander_inode_pressure"] = 1
+		}
+	}
+
+	if summary.RetryBudget != nil {
+		r.values["s5commander_retry_budget_remaining"] = float64(*summary.RetryBudget)
+	}
+	if summary.EffectiveConcurrency > 0 {
+		r.values["s5commander_effective_concurrency"] = float64(summary.EffectiveConcurrency)
+	}
+	if summary.InterBatchDelay > 0 {
+		r.values["s5commander_inter_batch_delay_seconds"] = summary.InterBatchDelay.Seconds()
+	}
+
+	if rt := summary.Runtime; rt != nil {
+		r.values["s5commander_runtime_goroutines"] = float64(rt.Goroutines)
+		r.values["s5commander_runtime_heap_inuse_bytes"] = float64(rt.HeapInuse)
+		r.values["s5commander_runtime_gc_total"] = float64(rt.NumGC)
+		r.values["s5commander_runtime_gc_pause_seconds_total"] = rt.GCPause.Seconds()
+		r.values["s5commander_runtime_gc_last_pause_seconds"] = rt.LastPause.Seconds()
+	}
+	if p := summary.Percentiles; p != nil {
+		for i, name := range []string{"p50", "p90", "p99"} {
+			r.values["s5commander_throughput_"+name+"_bytes_per_second"] = p.Throughput[i]
+			r.values["s5commander_run_duration_"+name+"_seconds"] = p.Duration[i]
+		}
+		r.values["s5commander_throughput_degraded"] = 0
+		if p.Degraded {
+			r.values["s5commander_throughput_degraded"] = 1
+		}
+	}
+}
+
+// progress sets the gauges of the running run.
+func (r *promRegistry) progress(progress progressSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values["s5commander_run_progress"] = float64(progress.Uploaded)
+	r.values["s5commander_run_progress_bytes"] = float64(progress.Bytes)
+}
+
+// write renders the metrics in the Prometheus text exposition format.
+func (r *promRegistry) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.values))
+	for name := range r.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		metric := promMetrics[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s%s %g\n", name, metric.help, name, metric.kind, name, r.labels, r.values[name])
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// prometheusSink serves the metrics over HTTP for Prometheus to scrape.
+type prometheusSink struct {
+	registry *promRegistry
+	server   *http.Server
+}
+
+func newPrometheusSink(address, instanceID string) (*prometheusSink, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("error listening for Prometheus on %s: %w", address, err)
+	}
+
+	sink := &prometheusSink{registry: newPromRegistry(instanceID)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		sink.registry.write(w)
+	})
+	sink.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := sink.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Error serving Prometheus metrics: %v", err)
+		}
+	}()
+	return sink, nil
+}
+
+func (s *prometheusSink) Started() error {
+	s.registry.started()
+	return nil
+}
+
+func (s *prometheusSink) RunCompleted(summary *Summary, runCount int) error {
+	s.registry.observe(summary, runCount)
+	return nil
+}
+
+func (s *prometheusSink) Progress(progress progressSnapshot) error {
+	s.registry.progress(progress)
+	return nil
+}
+
+func (s *prometheusSink) Shutdown(summary *Summary, totalRuns int) error {
+	return nil
+}
+
+func (s *prometheusSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// textfileSink writes the metrics in the Prometheus text format to a file after
+// every run, for the node_exporter textfile collector.
+type textfileSink struct {
+	registry *promRegistry
+	path     string
+}
+
+func newTextfileSink(path, instanceID string) *textfileSink {
+	return &textfileSink{registry: newPromRegistry(instanceID), path: path}
+}
+
+// Started only records the start time, the file is written after the first run.
+func (s *textfileSink) Started() error {
+	s.registry.started()
+	return nil
+}
+
+func (s *textfileSink) RunCompleted(summary *Summary, runCount int) error {
+	s.registry.observe(summary, runCount)
+	return s.flush()
+}
+
+func (s *textfileSink) Progress(progress progressSnapshot) error {
+	s.registry.progress(progress)
+	return s.flush()
+}
+
+// flush writes the current values to the textfile.
+func (s *textfileSink) flush() error {
+	var b bytes.Buffer
+	if err := s.registry.write(&b); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, b.Bytes()); err != nil {
+		return fmt.Errorf("error writing Prometheus textfile: %w", err)
+	}
+	return nil
+}
+
+func (s *textfileSink) Shutdown(summary *Summary, totalRuns int) error {
+	return nil
+}
+
+func (s *textfileSink) Close() error {
+	return nil
+}
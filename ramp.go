@@ -0,0 +1,52 @@
+package main
+
+import "sync"
+
+// rampLimiter bounds the number of concurrent jobs by a limit that starts at
+// one and adapts to their outcome: every job that succeeds raises it by one up
+// to max, every job that fails halves it.
+type rampLimiter struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	max     int
+	running int
+}
+
+func newRampLimiter(max int) *rampLimiter {
+	r := &rampLimiter{limit: 1, max: max}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// acquire waits until fewer jobs than the current limit are running.
+func (r *rampLimiter) acquire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.running >= r.limit {
+		r.cond.Wait()
+	}
+	r.running++
+}
+
+// release ends a job and adapts the limit to whether it succeeded.
+func (r *rampLimiter) release(succeeded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.running--
+	if succeeded {
+		r.limit = min(r.limit+1, r.max)
+	} else {
+		r.limit = max(r.limit/2, 1)
+	}
+	r.cond.Broadcast()
+}
+
+// current returns the current limit.
+func (r *rampLimiter) current() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limit
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	// maxFailureReasons bounds the distinct reasons collected, further ones
+	// are counted as otherReason
+	maxFailureReasons = 20
+	maxReasonLength   = 120
+	otherReason       = "other"
+)
+
+var (
+	quotedPattern     = regexp.MustCompile(`"[^"]*"|'[^']*'`)
+	commandPattern    = regexp.MustCompile(`^(cp|mv|rm)\s+\S+(\s+\S+)?:`)
+	nonSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// failureReason returns the cause of an s5cmd error message, without the
+// command, paths and request IDs that make every message unique.
+func failureReason(message string) string {
+	reason := quotedPattern.ReplaceAllString(message, "")
+	reason = commandPattern.ReplaceAllString(strings.TrimSpace(reason), "")
+	if i := strings.Index(strings.ToLower(reason), "request id"); i >= 0 {
+		reason = reason[:i]
+	}
+	reason = strings.Trim(strings.Join(strings.Fields(reason), " "), " :,")
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+	if reason == "" {
+		return "unknown"
+	}
+	return reason
+}
+
+// deleteReasons maps the errors of failed deletes to their reason. They are
+// checked in order, the first match wins.
+var deleteReasons = []struct {
+	reason string
+	errs   []error
+}{
+	{"not_found", []error{fs.ErrNotExist}},
+	{"read_only", []error{syscall.EROFS}},
+	{"permission_denied", []error{fs.ErrPermission}},
+	{"busy", []error{syscall.EBUSY, syscall.ETXTBSY}},
+	{"is_directory", []error{syscall.EISDIR, syscall.ENOTEMPTY}},
+	{"io_error", []error{syscall.EIO}},
+}
+
+// deleteFailureReason classifies the error of a failed delete.
+func deleteFailureReason(err error) string {
+	for _, r := range deleteReasons {
+		for _, target := range r.errs {
+			if errors.Is(err, target) {
+				return r.reason
+			}
+		}
+	}
+	return otherReason
+}
+
+// recordDeleteFailure adds path, which failed to delete with err, to the
+// failed deletes of summary.
+func recordDeleteFailure(summary *Summary, path string, err error) {
+	summary.FilesFailed = append(summary.FilesFailed, path)
+	summary.DeleteReasons = addFailureReasons(summary.DeleteReasons, map[string]int{deleteFailureReason(err): 1})
+}
+
+// addFailureReasons adds the counts of other to reasons, which is allocated if
+// nil. Reasons beyond maxFailureReasons are counted as otherReason.
+func addFailureReasons(reasons map[string]int, other map[string]int) map[string]int {
+	for reason, count := range other {
+		if reasons == nil {
+			reasons = make(map[string]int)
+		}
+		if _, known := reasons[reason]; !known && len(reasons) >= maxFailureReasons {
+			reason = otherReason
+		}
+		reasons[reason] += count
+	}
+	return reasons
+}
+
+// topFailureReasons returns the n most common reasons, most common first.
+func topFailureReasons(reasons map[string]int, n int) []string {
+	top := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		top = append(top, reason)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if reasons[top[i]] != reasons[top[j]] {
+			return reasons[top[i]] > reasons[top[j]]
+		}
+		return top[i] < top[j]
+	})
+	return top[:min(n, len(top))]
+}
+
+// formatFailureReasons renders the n most common reasons with their counts.
+func formatFailureReasons(reasons map[string]int, n int) string {
+	top := topFailureReasons(reasons, n)
+	rendered := make([]string, len(top))
+	for i, reason := range top {
+		rendered[i] = fmt.Sprintf("%s (%d)", reason, reasons[reason])
+	}
+	return strings.Join(rendered, "; ")
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reasonSlug turns a reason into a metric name element.
+func reasonSlug(reason string) string {
+	slug := strings.Trim(nonSlugCharacters.ReplaceAllString(strings.ToLower(reason), "_"), "_")
+	if len(slug) > 48 {
+		slug = strings.TrimRight(slug[:48], "_")
+	}
+	return slug
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sensitiveKey is a setting or environment variable holding a secret.
+type sensitiveKey struct {
+	name  string // flag name, or environment variable for credentials s5cmd reads directly
+	value func(cfg *Config) string
+}
+
+// sensitiveKeys lists every setting and environment variable holding a secret.
+// Their values are masked in everything logged, and the effective
+// configuration shows them as REDACTED.
+var sensitiveKeys = []sensitiveKey{
+	{"influxdb-token", func(cfg *Config) string { return cfg.InfluxDBToken }},
+	{"creds-command", func(cfg *Config) string { return cfg.CredsCommand }},
+	{"AWS_SECRET_ACCESS_KEY", func(*Config) string { return os.Getenv("AWS_SECRET_ACCESS_KEY") }},
+	{"AWS_SESSION_TOKEN", func(*Config) string { return os.Getenv("AWS_SESSION_TOKEN") }},
+}
+
+// isSensitiveKey reports whether the setting or environment variable name
+// holds a secret.
+func isSensitiveKey(name string) bool {
+	for _, key := range sensitiveKeys {
+		if key.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveQuery lists URL query parameters carrying credentials, compared
+// case-insensitively.
+var sensitiveQuery = []string{"token", "password", "p", "x-amz-signature", "x-amz-credential", "x-amz-security-token"}
+
+// minSecretLength is the length below which values are not masked. Masking
+// them would mangle unrelated words of every log line, and no real credential
+// is that short.
+const minSecretLength = 4
+
+// redactor masks the secrets of the configuration in text written for humans.
+// Secrets obtained while running, such as the credentials of creds-command,
+// are added as they become known.
+type redactor struct {
+	mu       sync.Mutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// newRedactor collects the secrets of cfg: the values of sensitiveKeys and the
+// passwords and sensitive query parameters of the configured URLs.
+func newRedactor(cfg *Config) *redactor {
+	var secrets []string
+	for _, key := range sensitiveKeys {
+		secrets = append(secrets, key.value(cfg))
+	}
+	for _, rawURL := range []string{cfg.AwsEndpointURL, cfg.InfluxDBURL} {
+		secrets = append(secrets, urlSecrets(rawURL)...)
+	}
+
+	r := &redactor{}
+	r.add(secrets...)
+	return r
+}
+
+// add registers further secrets to mask. It does nothing on a nil redactor.
+func (r *redactor) add(secrets ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := false
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLength && !slices.Contains(r.secrets, secret) {
+			r.secrets = append(r.secrets, secret)
+			added = true
+		}
+	}
+	if !added && r.replacer != nil {
+		return
+	}
+
+	// Longer secrets first, so one containing another is masked as a whole
+	sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	pairs := make([]string, 0, 2*len(r.secrets))
+	for _, secret := range r.secrets {
+		pairs = append(pairs, secret, "REDACTED")
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// redact returns s with every known secret replaced.
+func (r *redactor) redact(s string) string {
+	r.mu.Lock()
+	replacer := r.replacer
+	r.mu.Unlock()
+	return replacer.Replace(s)
+}
+
+// redactingWriter masks secrets in everything written to w. The log package
+// writes every message with a single call, so no secret is split across writes.
+type redactingWriter struct {
+	w        io.Writer
+	redactor *redactor
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// urlSecrets returns the password and the values of sensitive query parameters
+// of rawURL.
+func urlSecrets(rawURL string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return nil
+	}
+	var secrets []string
+	if u.User != nil {
+		password, _ := u.User.Password()
+		secrets = append(secrets, password)
+	}
+	for key, values := range u.Query() {
+		if isSensitiveQuery(key) {
+			secrets = append(secrets, values...)
+		}
+	}
+	return secrets
+}
+
+func isSensitiveQuery(key string) bool {
+	for _, sensitive := range sensitiveQuery {
+		if strings.EqualFold(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL replaces the userinfo and the sensitive query parameters of rawURL,
+// if any.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<unparseable URL>"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	query := u.Query()
+	for key := range query {
+		if isSensitiveQuery(key) {
+			query.Set(key, "REDACTED")
+		}
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
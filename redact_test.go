@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	influxToken   = "influx-token-1234"
+	endpointPass  = "endpoint-pass-5678"
+	fetchedSecret = "fetched-secret-9012"
+	fetchedToken  = "fetched-session-3456"
+)
+
+func newSecretConfig(t *testing.T) *Config {
+	t.Helper()
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret-7890")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	cfg := newTestConfig(t)
+	cfg.InfluxDBToken = influxToken
+	cfg.AwsEndpointURL = "https://user:" + endpointPass + "@s3.example.com"
+	cfg.CredsCommand = "fetch-creds --role uploader"
+	cfg.redactor = newRedactor(cfg)
+	return cfg
+}
+
+// logTo sends the log through the redactor of cfg into a buffer for the rest
+// of the test.
+func logTo(t *testing.T, cfg *Config) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(redactingWriter{w: &buf, redactor: cfg.redactor})
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func assertRedacted(t *testing.T, output string, secrets ...string) {
+	t.Helper()
+	for _, secret := range secrets {
+		if strings.Contains(output, secret) {
+			t.Errorf("%q leaked into the output:\n%s", secret, output)
+		}
+	}
+}
+
+func TestLogRedactsConfiguredSecrets(t *testing.T) {
+	cfg := newSecretConfig(t)
+	logged := logTo(t, cfg)
+
+	log.Printf("error sending to %s with token %s, secret %s, running %s",
+		cfg.AwsEndpointURL, cfg.InfluxDBToken, "env-secret-7890", cfg.CredsCommand)
+	assertRedacted(t, logged.String(), influxToken, endpointPass, "env-secret-7890", cfg.CredsCommand)
+	if !strings.Contains(logged.String(), "REDACTED") {
+		t.Errorf("got %q, want the secrets replaced", logged)
+	}
+}
+
+func TestLogRedactsFetchedCredentials(t *testing.T) {
+	cfg := newSecretConfig(t)
+	// A script standing in for the credentials command
+	command := fakeS5cmd(t, `echo '{"Version":1,"AccessKeyId":"AKID","SecretAccessKey":"`+fetchedSecret+`","SessionToken":"`+fetchedToken+`"}'`)
+	source := newCredsSource(command, time.Hour, cfg.redactor)
+	logged := logTo(t, cfg)
+
+	creds, err := source.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("s5cmd environment: %v", creds.env())
+	assertRedacted(t, logged.String(), fetchedSecret, fetchedToken)
+}
+
+func TestLogConfigRedactsSensitiveSettings(t *testing.T) {
+	cfg := newSecretConfig(t)
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("influxdb-token", "", "Token sent to InfluxDB (env: INFLUXDB_TOKEN)")
+	flags.String("creds-command", "", "Command printing AWS credentials (env: CREDS_COMMAND)")
+	flags.String("aws-endpoint-url", "", "Endpoint (env: AWS_ENDPOINT_URL)")
+	// Without the redacting writer, so that only log-config masks them
+	logged := captureLog(t)
+
+	logEffectiveConfig(resolveSettings(cfg, flags))
+	assertRedacted(t, logged.String(), influxToken, cfg.CredsCommand, endpointPass)
+}
+
+func TestVerboseCommandRedactsEndpoint(t *testing.T) {
+	cfg := newSecretConfig(t)
+	rendered := redactedCommand([]string{"s5cmd", "--endpoint-url", cfg.AwsEndpointURL, "cp", "a", "b"})
+	assertRedacted(t, rendered, endpointPass)
+}
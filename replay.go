@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+// validateReplay checks the settings used by --replay-dir, which needs none of
+// the S3 settings.
+func (cfg *Config) validateReplay() error {
+	if cfg.FolderPrefix == "" {
+		return errors.New("replay-dir (or REPLAY_DIR env var) requires folder-prefix (or FOLDER_PREFIX env var)")
+	}
+	if isUnder(cfg.ReplayDir, cfg.FolderPrefix) || isUnder(cfg.FolderPrefix, cfg.ReplayDir) ||
+		filepath.Clean(cfg.ReplayDir) == filepath.Clean(cfg.FolderPrefix) {
+		return errors.New("replay-dir (or REPLAY_DIR env var) and folder-prefix must not contain each other")
+	}
+	return nil
+}
+
+// replayFiles moves every file below cfg.ReplayDir back to the same relative
+// location below cfg.FolderPrefix, so the next run uploads it again. Files that
+// would overwrite a file in the spool are left in place and counted as skipped.
+func replayFiles(cfg *Config) (replayed, skipped int, err error) {
+	err = filepath.WalkDir(cfg.ReplayDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		target, err := relocate(path, cfg.ReplayDir, cfg.FolderPrefix)
+		if err != nil {
+			log.Printf("Not replaying %s: %v", path, err)
+			skipped++
+			return nil
+		}
+		// Start over with a clean failure count
+		cfg.state.forget(target)
+		replayed++
+		return nil
+	})
+	if err != nil {
+		return replayed, skipped, fmt.Errorf("error walking %s: %w", cfg.ReplayDir, err)
+	}
+	return replayed, skipped, nil
+}
+
+// replay runs the one-shot --replay-dir mode. It exits non-zero if any file
+// could not be moved back.
+func replay(cfg *Config) {
+	if err := cfg.validateReplay(); err != nil {
+		log.Fatal(err)
+	}
+
+	state, err := loadStateStore(cfg.StateFile)
+	if err != nil {
+		log.Fatalf("Error loading state file: %v", err)
+	}
+	cfg.state = state
+
+	replayed, skipped, err := replayFiles(cfg)
+	if saveErr := cfg.state.save(); saveErr != nil {
+		log.Printf("Error saving state file: %v", saveErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Moved %d files from %s back to %s", replayed, cfg.ReplayDir, cfg.FolderPrefix)
+	if skipped > 0 {
+		log.Fatalf("%d files were not replayed because they already exist in %s or could not be moved", skipped, cfg.FolderPrefix)
+	}
+}
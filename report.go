@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// shutdownReport is the machine-readable summary of a session written on shutdown.
+type shutdownReport struct {
+	Version           string    `json:"version"`
+	Commit            string    `json:"commit"`
+	BuildDate         string    `json:"build_date"`
+	InstanceID        string    `json:"instance_id"`
+	StartedAt         time.Time `json:"started_at"`
+	StoppedAt         time.Time `json:"stopped_at"`
+	DurationSeconds   float64   `json:"duration_seconds"`
+	Runs              int       `json:"runs"`
+	FilesTransferred  int       `json:"files_transferred"`
+	FilesDeleted      int       `json:"files_deleted"`
+	BytesTransferred  int64     `json:"bytes_transferred"`
+	FilesDeadLettered int       `json:"files_dead_lettered"`
+	// The failed files are sampled, their counts are complete
+	FilesFailedDeleteCount int      `json:"files_failed_delete_count"`
+	FilesFailedDelete      []string `json:"files_failed_delete"`
+	FilesFailedUploadCount int      `json:"files_failed_upload_count"`
+	FilesFailedUpload      []string `json:"files_failed_upload"`
+}
+
+// newShutdownReport builds the report of a session that started at startedAt.
+// At most sampleSize failed files of each kind are listed.
+func newShutdownReport(session *Summary, runs int, startedAt time.Time, instanceID string, sampleSize int) shutdownReport {
+	stoppedAt := time.Now()
+	failedDelete, _ := samplePaths(session.FilesFailed, sampleSize)
+	failedUpload, _ := samplePaths(session.UploadsFailed, sampleSize)
+	return shutdownReport{
+		Version:                version,
+		Commit:                 commit,
+		BuildDate:              date,
+		InstanceID:             instanceID,
+		StartedAt:              startedAt,
+		StoppedAt:              stoppedAt,
+		DurationSeconds:        stoppedAt.Sub(startedAt).Seconds(),
+		Runs:                   runs,
+		FilesTransferred:       session.FilesTransferred,
+		FilesDeleted:           session.FilesDeleted,
+		BytesTransferred:       session.TotalBytes,
+		FilesDeadLettered:      session.FilesDeadLettered,
+		FilesFailedDeleteCount: len(session.FilesFailed),
+		FilesFailedDelete:      failedDelete,
+		FilesFailedUploadCount: len(session.UploadsFailed),
+		FilesFailedUpload:      failedUpload,
+	}
+}
+
+// writeShutdownReport writes report as JSON to path, replacing it atomically.
+func writeShutdownReport(path string, report shutdownReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding shutdown report: %w", err)
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// restoreFiles downloads the objects matching pattern, relative to the bucket
+// path, back into the folder prefix. Objects whose local file already exists
+// are not downloaded again. Nothing is deleted on either side.
+func restoreFiles(cfg *Config, pattern string) (Summary, error) {
+	jobID, err := uuid.NewRandom()
+	if err != nil {
+		return Summary{}, fmt.Errorf("error generating job ID: %v", err)
+	}
+	outputFile := fmt.Sprintf("%s.json", jobID.String())
+	defer os.Remove(outputFile)
+
+	src := joinDestination(cfg.S3BucketPath, instanceKey(cfg, strings.TrimPrefix(pattern, "/")))
+	dest := strings.TrimSuffix(cfg.FolderPrefix, "/") + "/" + cfg.StripPrefix
+	operation := []string{"cp", "--no-clobber", src, dest}
+
+	if err := runS5cmd(cfg, operation, outputFile, outputFile); err != nil {
+		if isNoMatch, _ := checkForNoMatchError(cfg, outputFile); isNoMatch {
+			return Summary{}, nil
+		}
+		// Some objects may have been downloaded all the same
+		summary, _ := parseRestoreOutput(cfg, outputFile)
+		return summary, fmt.Errorf("error running s5cmd for restore: %w", err)
+	}
+	return parseRestoreOutput(cfg, outputFile)
+}
+
+// parseRestoreOutput counts the downloads reported in the s5cmd output. Unlike
+// parseOutputFile it never deletes: the sources of a restore are objects.
+func parseRestoreOutput(cfg *Config, outputFile string) (Summary, error) {
+	var summary Summary
+	file, err := os.Open(outputFile)
+	if err != nil {
+		return summary, fmt.Errorf("error opening job result file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var result JobResult
+		if err := decodeResult(cfg, scanner.Bytes(), &result); err != nil {
+			summary.MalformedLines++
+			continue
+		}
+		if result.Operation != "cp" {
+			continue
+		}
+		if result.Success && result.Object.Type == "file" {
+			summary.FilesTransferred++
+			summary.TotalBytes += result.Object.Size
+		} else if !result.Success && result.Error != "" && !result.skippedExisting() {
+			log.Printf("Error restoring %s: %s", result.Source, result.Error)
+			summary.UploadsFailed = append(summary.UploadsFailed, result.Source)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, fmt.Errorf("error reading job result file: %w", err)
+	}
+	return summary, nil
+}
+
+// restore runs the one-shot --restore mode. It exits non-zero if any object
+// could not be downloaded.
+func restore(cfg *Config) {
+	log.Printf("Restoring %s from %s to %s", cfg.Restore, cfg.S3BucketPath, cfg.FolderPrefix)
+	summary, err := restoreFiles(cfg, cfg.Restore)
+	log.Printf("Restored %d files, %.2f MB", summary.FilesTransferred, float64(summary.TotalBytes)/(1024*1024))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(summary.UploadsFailed) > 0 {
+		log.Fatalf("%d objects failed to restore", len(summary.UploadsFailed))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreFilesNeverDeletes(t *testing.T) {
+	cfg := newTestConfig(t)
+	t.Chdir(t.TempDir())
+	local := writeSpoolFile(t, cfg, "a/local.log", "abc")
+	restored := filepath.Join(cfg.FolderPrefix, "a", "restored.log")
+
+	// Reports one download, one failure and the local file as existing, with
+	// the local paths as the sources a broken parser might delete
+	cfg.S5cmdBinary = fakeS5cmd(t, `
+echo '{"operation":"cp","success":true,"source":"`+local+`","destination":"`+restored+`","object":{"type":"file","size":3}}'
+echo '{"operation":"cp","success":false,"source":"`+restored+`","error":"connection refused"}'
+echo '{"operation":"cp","success":false,"source":"`+local+`","error":"object already exists"}'
+echo abc > `+restored+`
+`)
+
+	summary, err := restoreFiles(cfg, "a/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertExists(t, local)
+	assertExists(t, restored)
+	if summary.FilesTransferred != 1 || summary.FilesDeleted != 0 || len(summary.UploadsFailed) != 1 {
+		t.Errorf("got %d restored, %d deleted and %d failed, want 1, 0 and 1",
+			summary.FilesTransferred, summary.FilesDeleted, len(summary.UploadsFailed))
+	}
+
+	// The output file of the job is cleaned up
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("restore left %d files in the working directory", len(entries))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// retryBudget caps the retries of all runs within a summary window, so that a
+// sustained outage is not amplified by every run retrying.
+type retryBudget struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+func newRetryBudget(limit int) *retryBudget {
+	return &retryBudget{limit: limit}
+}
+
+// take uses up one retry and reports whether one was left.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// remaining returns the number of retries left in the window.
+func (b *retryBudget) remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit - b.used
+}
+
+// reset starts a new window with the full budget.
+func (b *retryBudget) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// route sends files whose object key matches pattern to destination.
+type route struct {
+	pattern     *regexp.Regexp
+	destination string
+}
+
+// parseRoute parses a pattern=destination rule. Destinations that aren't an
+// s3:// URL are relative to s3BucketPath.
+func parseRoute(rule, s3BucketPath string) (route, error) {
+	pattern, destination, ok := strings.Cut(rule, "=")
+	if !ok || pattern == "" || destination == "" {
+		return route{}, fmt.Errorf("invalid route %q, expected pattern=destination", rule)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return route{}, fmt.Errorf("invalid pattern in route %q: %w", rule, err)
+	}
+	if !strings.HasPrefix(destination, "s3://") {
+		destination = joinDestination(s3BucketPath, strings.TrimPrefix(destination, "/"))
+	}
+	return route{pattern: re, destination: destination}, nil
+}
+
+// parseRoutes parses rules in order, see parseRoute.
+func parseRoutes(rules []string, s3BucketPath string) ([]route, error) {
+	var routes []route
+	for _, rule := range rules {
+		r, err := parseRoute(rule, s3BucketPath)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// destinationFor returns the destination prefix of the first route matching
+// key, or the bucket path if none does.
+func (cfg *Config) destinationFor(key string) string {
+	for _, r := range cfg.routes {
+		if r.pattern.MatchString(key) {
+			return r.destination
+		}
+	}
+	return cfg.S3BucketPath
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseRoute(t *testing.T) {
+	for _, tc := range []struct {
+		rule, want string
+		fails      bool
+	}{
+		{`\.csv$=csv/`, "s3://bucket/prefix/csv/", false},
+		{`\.csv$=/csv/`, "s3://bucket/prefix/csv/", false},
+		{`\.csv$=s3://other/csv/`, "s3://other/csv/", false},
+		{`\.csv$`, "", true},
+		{`=csv/`, "", true},
+		{`\.csv$=`, "", true},
+		{`(=csv/`, "", true},
+	} {
+		r, err := parseRoute(tc.rule, "s3://bucket/prefix/")
+		if (err != nil) != tc.fails || r.destination != tc.want {
+			t.Errorf("parseRoute(%q) = %q, %v; want %q, failure %v", tc.rule, r.destination, err, tc.want, tc.fails)
+		}
+	}
+}
+
+func TestDestinationForFirstMatchWins(t *testing.T) {
+	routes, err := parseRoutes([]string{`^logs/.*\.gz$=s3://gz/`, `^logs/=s3://logs/`, `\.gz$=s3://other-gz/`}, "s3://bucket/prefix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{S3BucketPath: "s3://bucket/prefix/", routes: routes}
+
+	for key, want := range map[string]string{
+		"logs/a.gz":  "s3://gz/",
+		"logs/a.txt": "s3://logs/",
+		"data/a.gz":  "s3://other-gz/",
+		// No route matches, the bucket path is the fallback
+		"data/a.txt": "s3://bucket/prefix/",
+	} {
+		if got := cfg.destinationFor(key); got != want {
+			t.Errorf("destinationFor(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
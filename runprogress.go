@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// runProgress counts the upload records of the running s5cmd jobs as s5cmd
+// writes them, so that long runs report progress before they complete. The
+// jobs of a split-by-subdir run may overlap, the progress covers all of them.
+type runProgress struct {
+	mu       sync.Mutex
+	active   bool
+	started  time.Time
+	jobs     int  // jobs running
+	planned  int  // files planned by the jobs started so far
+	unknown  bool // a job started without a planned file count
+	uploaded int
+	failed   int
+	bytes    int64
+}
+
+// progressSnapshot is the progress of a run at one point in time.
+type progressSnapshot struct {
+	Jobs     int
+	Planned  int // 0 if not known
+	Uploaded int
+	Failed   int
+	Bytes    int64
+	Elapsed  time.Duration
+}
+
+func newRunProgress() *runProgress {
+	return &runProgress{}
+}
+
+// begin starts counting a new run.
+func (p *runProgress) begin() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active, p.started = true, time.Now()
+	p.jobs, p.planned, p.unknown = 0, 0, false
+	p.uploaded, p.failed, p.bytes = 0, 0, 0
+}
+
+// end stops reporting until the next run begins.
+func (p *runProgress) end() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active = false
+}
+
+// startJob registers a job with planned files, 0 if not known up front, and
+// returns the function counting the lines of its output. It returns nil if
+// progress isn't reported.
+func (p *runProgress) startJob(cfg *Config, planned int) func(line []byte) {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs++
+	p.planned += planned
+	p.unknown = p.unknown || planned == 0
+	return func(line []byte) { p.record(cfg, line) }
+}
+
+// finishJob unregisters a job registered with startJob.
+func (p *runProgress) finishJob() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs--
+}
+
+// record counts an output line, as parseOutputFile will once the job is done.
+func (p *runProgress) record(cfg *Config, line []byte) {
+	var result JobResult
+	if err := decodeResult(cfg, line, &result); err != nil || result.Operation != "cp" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if result.Success && result.Object.Type == "file" {
+		p.uploaded++
+		p.bytes += result.Object.Size
+	} else if !result.Success && result.Error != "" && !result.skippedExisting() && !sourceVanished(cfg, &result) {
+		p.failed++
+	}
+}
+
+// snapshot returns the progress of the current run, false between runs.
+func (p *runProgress) snapshot() (progressSnapshot, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.active {
+		return progressSnapshot{}, false
+	}
+	snap := progressSnapshot{
+		Jobs:     p.jobs,
+		Uploaded: p.uploaded,
+		Failed:   p.failed,
+		Bytes:    p.bytes,
+		Elapsed:  time.Since(p.started),
+	}
+	if !p.unknown {
+		snap.Planned = p.planned
+	}
+	return snap, true
+}
+
+// String renders the progress for the log.
+func (s progressSnapshot) String() string {
+	files := fmt.Sprintf("%d", s.Uploaded)
+	if s.Planned > 0 {
+		files = fmt.Sprintf("%d/%d", s.Uploaded, s.Planned)
+	}
+	return fmt.Sprintf("uploaded %s files, %.2f MB so far, %d failed, %d s5cmd jobs running, %v elapsed",
+		files, float64(s.Bytes)/(1024*1024), s.Failed, s.Jobs, s.Elapsed.Round(time.Second))
+}
+
+// report logs and sends the progress of the current run every interval until
+// ctx is done. Nothing is reported between runs.
+func (p *runProgress) report(ctx context.Context, interval time.Duration, metrics MetricSink) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		snap, ok := p.snapshot()
+		if !ok || snap.Elapsed < interval {
+			continue
+		}
+		log.Printf("Run progress: %s", snap)
+		if err := metrics.Progress(snap); err != nil {
+			log.Printf("Error sending progress metrics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"time"
+)
+
+// runtimeStats is the health of the program itself, to spot leaks in the
+// background goroutines of the metric sender, the delete queue or the
+// enumeration pipeline.
+type runtimeStats struct {
+	Goroutines int
+	HeapInuse  uint64        // bytes
+	NumGC      uint32        // garbage collections since startup
+	GCPause    time.Duration // total stop-the-world pause since startup
+	LastPause  time.Duration // pause of the latest garbage collection
+}
+
+// readRuntimeStats samples the runtime. Reading the memory statistics stops the
+// world briefly, so it is done once per summary window only.
+func readRuntimeStats() *runtimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := &runtimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapInuse:  mem.HeapInuse,
+		NumGC:      mem.NumGC,
+		GCPause:    time.Duration(mem.PauseTotalNs),
+	}
+	if mem.NumGC > 0 {
+		stats.LastPause = time.Duration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+	return stats
+}
+
+func (s *runtimeStats) log() {
+	log.Printf("Runtime: %d goroutines, %.1f MiB heap in use, %d GCs paused %v in total, last %v",
+		s.Goroutines, float64(s.HeapInuse)/mebibyte, s.NumGC, s.GCPause.Round(time.Microsecond), s.LastPause.Round(time.Microsecond))
+}
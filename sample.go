@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxLoggedPathLen bounds the length of a single path in logs and reports.
+const maxLoggedPathLen = 256
+
+// samplePaths returns at most n of paths, each shortened to maxLoggedPathLen,
+// and the number of paths left out.
+func samplePaths(paths []string, n int) ([]string, int) {
+	if n > len(paths) {
+		n = len(paths)
+	}
+	sample := make([]string, n)
+	for i, path := range paths[:n] {
+		sample[i] = shortenPath(path)
+	}
+	return sample, len(paths) - n
+}
+
+// shortenPath cuts the middle out of paths longer than maxLoggedPathLen,
+// keeping the start and the file name, which are the useful parts.
+func shortenPath(path string) string {
+	if len(path) <= maxLoggedPathLen {
+		return path
+	}
+	keep := (maxLoggedPathLen - 3) / 2
+	return path[:keep] + "..." + path[len(path)-keep:]
+}
+
+// formatSample renders a sample of paths for a log line, e.g. "a, b (+3 more)".
+func formatSample(paths []string, n int) string {
+	sample, more := samplePaths(paths, n)
+	s := strings.Join(sample, ", ")
+	if more > 0 {
+		if s != "" {
+			s += " "
+		}
+		s += fmt.Sprintf("(+%d more)", more)
+	}
+	return s
+}
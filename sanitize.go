@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// keySanitizer lowercases object keys and replaces characters outside an
+// allowed set. The transform is deterministic, so a file always maps to the
+// same key.
+type keySanitizer struct {
+	disallowed  *regexp.Regexp
+	replacement string
+}
+
+// newKeySanitizer returns a keySanitizer keeping the characters of the regexp
+// character class allowed and replacing every other character with replacement.
+func newKeySanitizer(allowed, replacement string) (*keySanitizer, error) {
+	disallowed, err := regexp.Compile("[^" + allowed + "]")
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed characters %q: %w", allowed, err)
+	}
+	if disallowed.MatchString(replacement) {
+		return nil, fmt.Errorf("replacement %q contains disallowed characters", replacement)
+	}
+	return &keySanitizer{disallowed: disallowed, replacement: replacement}, nil
+}
+
+// sanitize returns the sanitized form of key. Path separators are always kept.
+func (s *keySanitizer) sanitize(key string) string {
+	segments := strings.Split(strings.ToLower(key), "/")
+	for i, segment := range segments {
+		segments[i] = s.disallowed.ReplaceAllString(segment, s.replacement)
+	}
+	return strings.Join(segments, "/")
+}
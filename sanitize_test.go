@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeySanitizerRules(t *testing.T) {
+	s, err := newKeySanitizer("a-z0-9._-", "_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"Logs/App.LOG":          "logs/app.log",
+		"a b/c+d=e.gz":          "a_b/c_d_e.gz",
+		"host-1/2026_10_16.log": "host-1/2026_10_16.log",
+		"ümlaut/ß.log":          "_mlaut/_.log",
+		"a//b":                  "a//b",
+	} {
+		if got := s.sanitize(key); got != want {
+			t.Errorf("sanitize(%q) = %q, want %q", key, got, want)
+		}
+		// The same key always maps to the same result
+		if again := s.sanitize(key); again != want {
+			t.Errorf("sanitize(%q) changed to %q on the second call", key, again)
+		}
+	}
+}
+
+func TestNewKeySanitizerRejectsInvalidRules(t *testing.T) {
+	if _, err := newKeySanitizer("a-z[", "_"); err == nil {
+		t.Error("an invalid character class was accepted")
+	}
+	if _, err := newKeySanitizer("a-z", "+"); err == nil {
+		t.Error("a replacement made of disallowed characters was accepted")
+	}
+}
+
+func TestSanitizedKeyCollisionsAreSkipped(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.SanitizeKeys = true
+	sanitizer, err := newKeySanitizer("a-z0-9._-", "_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.keySanitizer = sanitizer
+	upper := writeSpoolFile(t, cfg, "App.log", "abc")
+	lower := writeSpoolFile(t, cfg, "app.log", "abc")
+	other := writeSpoolFile(t, cfg, "other.log", "abc")
+	captureLog(t)
+
+	var commands bytes.Buffer
+	lines, colliding, planned, err := writeUploads(cfg, "job", sourcePattern(cfg.FolderPrefix, cfg.PathSuffix), &commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned.KeyCollisions != 2 {
+		t.Errorf("got %d key collisions, want 2", planned.KeyCollisions)
+	}
+	kept := 0
+	for _, h := range lines {
+		if !colliding[h] {
+			kept++
+		}
+	}
+	if kept != 1 {
+		t.Errorf("got %d uploads kept, want only %s", kept, other)
+	}
+	assertExists(t, upper)
+	assertExists(t, lower)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/fs"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// shuffler randomizes processing order for shuffle-order. It is shared by the
+// concurrent jobs of split-by-subdir runs.
+type shuffler struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// newShuffler returns a shuffler seeded with seed, or with the current time if
+// seed is 0.
+func newShuffler(seed int64) *shuffler {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &shuffler{rand: rand.New(rand.NewSource(seed))}
+}
+
+func (s *shuffler) shuffle(n int, swap func(i, j int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rand.Shuffle(n, swap)
+}
+
+// shuffleStrings shuffles s in place.
+func (s *shuffler) shuffleStrings(values []string) {
+	s.shuffle(len(values), func(i, j int) { values[i], values[j] = values[j], values[i] })
+}
+
+// shuffleEntries shuffles a chunk of directory entries in place, so that no
+// partition of the spool is always enumerated, and thus uploaded, last.
+func (s *shuffler) shuffleEntries(entries []fs.DirEntry) {
+	s.shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+}
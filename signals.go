@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// forceExitTimeout bounds the cleanup of a forced exit, a sink or a process
+// that hangs must not keep the process from exiting.
+const forceExitTimeout = 5 * time.Second
+
+// watchSignals shuts down gracefully at the first signal and calls exit at the
+// second one, or with second-signal abort-drain aborts the drain and calls exit
+// at the third. If the shutdown was started already, e.g. by --once or
+// --max-runtime, the first signal counts as the second.
+func watchSignals(ctx context.Context, cfg *Config, signals <-chan os.Signal, cancel, abortDrain, exit func()) {
+	sig := <-signals
+	if ctx.Err() == nil {
+		log.Printf("Received signal %v, initiating graceful shutdown...", sig)
+		cancel()
+		sig = <-signals
+	}
+
+	if cfg.SecondSignal == secondSignalAbortDrain {
+		log.Printf("Received signal %v during graceful shutdown, no longer waiting for the backlog to drain", sig)
+		abortDrain()
+		sig = <-signals
+	}
+	log.Printf("Received signal %v during graceful shutdown, forcing exit without waiting for it to finish", sig)
+	exit()
+}
+
+// forceExit cleans up and exits with status 1, without waiting for the run in
+// progress, the drain or the delete queue. It kills the running s5cmd
+// processes, removes the work files of their jobs and the ready file, and
+// flushes the metrics sent so far. Files whose upload was not parsed yet stay
+// in place and are uploaded again by the next start.
+func forceExit(cfg *Config, metrics MetricSink) {
+	done := make(chan struct{})
+	go func() {
+		cfg.jobs.abort()
+		if cfg.ReadyFile != "" {
+			if err := os.Remove(cfg.ReadyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Error removing ready file: %v", err)
+			}
+		}
+		if err := metrics.Close(); err != nil {
+			log.Printf("Error flushing metrics: %v", err)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("Cleaned up, exiting now")
+	case <-time.After(forceExitTimeout):
+		log.Printf("Cleanup not done within %v, exiting now", forceExitTimeout)
+	}
+	os.Exit(1)
+}
+
+// jobTracker keeps the work files and s5cmd processes of the running jobs, so
+// that a forced exit, which skips their deferred cleanup, can release them.
+type jobTracker struct {
+	mu        sync.Mutex
+	files     map[string][]string // job ID -> work files
+	processes map[*os.Process]bool
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{files: make(map[string][]string), processes: make(map[*os.Process]bool)}
+}
+
+// reserve registers the work files of jobID. It does nothing on a nil tracker.
+func (t *jobTracker) reserve(jobID string, files []string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[jobID] = files
+}
+
+// release unregisters the work files of jobID, once the job removed them.
+func (t *jobTracker) release(jobID string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.files, jobID)
+}
+
+// started registers a running s5cmd process.
+func (t *jobTracker) started(process *os.Process) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.processes[process] = true
+}
+
+// exited unregisters an s5cmd process registered with started.
+func (t *jobTracker) exited(process *os.Process) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.processes, process)
+}
+
+// abort kills the running s5cmd processes and removes the work files of the
+// running jobs.
+func (t *jobTracker) abort() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for process := range t.processes {
+		if err := process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			log.Printf("Error killing s5cmd process %d: %v", process.Pid, err)
+		}
+	}
+	for jobID, files := range t.files {
+		for _, file := range files {
+			if err := os.RemoveAll(file); err != nil {
+				log.Printf("Error removing work file %s of job %s: %v", file, jobID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// signalWatch runs watchSignals in the background and reports its calls.
+type signalWatch struct {
+	signals   chan os.Signal
+	cancelled chan struct{}
+	aborted   chan struct{}
+	exited    chan struct{}
+}
+
+func startSignalWatch(ctx context.Context, cfg *Config) *signalWatch {
+	w := &signalWatch{
+		signals:   make(chan os.Signal, 3),
+		cancelled: make(chan struct{}),
+		aborted:   make(chan struct{}),
+		exited:    make(chan struct{}),
+	}
+	go watchSignals(ctx, cfg, w.signals,
+		func() { close(w.cancelled) }, func() { close(w.aborted) }, func() { close(w.exited) })
+	return w
+}
+
+func waitFor(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the %s", what)
+	}
+}
+
+func assertNotDone(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Errorf("unexpected %s", what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWatchSignalsForcesExitAtSecondSignal(t *testing.T) {
+	w := startSignalWatch(context.Background(), &Config{SecondSignal: secondSignalExit})
+
+	w.signals <- syscall.SIGTERM
+	waitFor(t, w.cancelled, "graceful shutdown")
+	assertNotDone(t, w.exited, "exit at the first signal")
+
+	w.signals <- syscall.SIGTERM
+	waitFor(t, w.exited, "forced exit")
+}
+
+func TestWatchSignalsAbortsDrainFirst(t *testing.T) {
+	w := startSignalWatch(context.Background(), &Config{SecondSignal: secondSignalAbortDrain})
+
+	w.signals <- syscall.SIGINT
+	waitFor(t, w.cancelled, "graceful shutdown")
+	w.signals <- syscall.SIGINT
+	waitFor(t, w.aborted, "aborted drain")
+	assertNotDone(t, w.exited, "exit at the second signal")
+	w.signals <- syscall.SIGINT
+	waitFor(t, w.exited, "forced exit")
+}
+
+func TestWatchSignalsFirstSignalForcesExitOnceShuttingDown(t *testing.T) {
+	// --once or --max-runtime started the shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := startSignalWatch(ctx, &Config{SecondSignal: secondSignalExit})
+
+	w.signals <- syscall.SIGTERM
+	waitFor(t, w.exited, "forced exit")
+	assertNotDone(t, w.cancelled, "second graceful shutdown")
+}
+
+func TestJobTrackerAbortKillsAndReleases(t *testing.T) {
+	dir := t.TempDir()
+	tracker := newJobTracker()
+	reserved := []string{filepath.Join(dir, "job.json"), filepath.Join(dir, "job.commands")}
+	released := filepath.Join(dir, "done.json")
+	if err := createExclusive(append(reserved, released)...); err != nil {
+		t.Fatal(err)
+	}
+	tracker.reserve("job", reserved)
+	tracker.reserve("done", []string{released})
+	tracker.release("done")
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	tracker.started(cmd.Process)
+
+	tracker.abort()
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+		t.Errorf("got %v, want the s5cmd process killed", err)
+	}
+	for _, path := range reserved {
+		assertGone(t, path)
+	}
+	assertExists(t, released)
+}
+
+func TestForceExitCleansUp(t *testing.T) {
+	if readyFile := os.Getenv("FORCE_EXIT_READY_FILE"); readyFile != "" {
+		cfg := &Config{ReadyFile: readyFile, jobs: newJobTracker()}
+		cfg.jobs.reserve("job", []string{os.Getenv("FORCE_EXIT_WORK_FILE")})
+		forceExit(cfg, multiSink{})
+		return
+	}
+
+	dir := t.TempDir()
+	readyFile := filepath.Join(dir, "ready")
+	workFile := filepath.Join(dir, "job.json")
+	if err := createExclusive(readyFile, workFile); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestForceExitCleansUp$")
+	cmd.Env = append(os.Environ(), "FORCE_EXIT_READY_FILE="+readyFile, "FORCE_EXIT_WORK_FILE="+workFile)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("got %v, want exit status 1", err)
+	}
+	assertGone(t, readyFile)
+	assertGone(t, workFile)
+}
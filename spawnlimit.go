@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// spawnLimiter is a token bucket bounding how many s5cmd processes are started
+// per second across the whole program. Its burst is one second's worth.
+type spawnLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	tokens    float64 // negative while waiters hold reservations
+	last      time.Time
+	throttled int // spawns that had to wait since the last collect
+}
+
+func newSpawnLimiter(perSecond int) *spawnLimiter {
+	return &spawnLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+// wait takes a token, sleeping until one is available.
+func (l *spawnLimiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return
+	}
+	// The token is reserved, later callers wait behind it
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.throttled++
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// collect returns the number of spawns that waited since the last call.
+func (l *spawnLimiter) collect() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	throttled := l.throttled
+	l.throttled = 0
+	return throttled
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stableRoot is the work directory holding the stable copies of all jobs.
+func stableRoot(cfg *Config) string {
+	return filepath.Join(cfg.WorkDir, "stable")
+}
+
+// stableJobDir is the work directory holding the stable copies of a job.
+func stableJobDir(cfg *Config, jobID string) string {
+	return filepath.Join(stableRoot(cfg), jobID)
+}
+
+// snapshot creates the stable copy of a file under the job's stable directory
+// and returns its path. The copy keeps the path of the file relative to the
+// folder prefix, so the original can be derived from it after the upload.
+func snapshot(cfg *Config, jobID, path string) (string, error) {
+	rel, err := filepath.Rel(cfg.FolderPrefix, path)
+	if err != nil || !isUnder(path, cfg.FolderPrefix) {
+		return "", fmt.Errorf("%s is not under %s", path, cfg.FolderPrefix)
+	}
+
+	target := filepath.Join(stableJobDir(cfg, jobID), rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return "", fmt.Errorf("error creating %s: %w", filepath.Dir(target), err)
+	}
+	linked, err := linkOrCopy(path, target)
+	if err != nil {
+		return "", fmt.Errorf("error creating stable copy of %s: %w", path, err)
+	}
+	if linked {
+		cfg.stableLinks.add(target)
+	}
+	return target, nil
+}
+
+// removeStableDir removes the stable copies of a job.
+func removeStableDir(cfg *Config, jobID string) {
+	dir := stableJobDir(cfg, jobID)
+	os.RemoveAll(dir)
+	cfg.stableLinks.forget(dir)
+}
+
+// stableLinks remembers which stable copies are hardlinks. A hardlink whose
+// original was replaced can't be told from a copy by looking at the files.
+type stableLinks struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func newStableLinks() *stableLinks {
+	return &stableLinks{paths: make(map[string]struct{})}
+}
+
+func (l *stableLinks) add(path string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths[path] = struct{}{}
+}
+
+func (l *stableLinks) linked(path string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.paths[path]
+	return ok
+}
+
+// forget drops the links under dir.
+func (l *stableLinks) forget(dir string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for path := range l.paths {
+		if isUnder(path, dir) {
+			delete(l.paths, path)
+		}
+	}
+}
+
+// snapshotMatches reports whether original is still the file its stable copy
+// at snapshot was taken from: the same file for a hardlink, and of the same
+// size and modification time for a copy.
+func snapshotMatches(cfg *Config, snapshot, original string) bool {
+	snapInfo, err := os.Stat(snapshot)
+	if err != nil {
+		return false
+	}
+	origInfo, err := os.Stat(original)
+	if err != nil {
+		return false
+	}
+	if os.SameFile(snapInfo, origInfo) {
+		return true
+	}
+	if cfg.stableLinks.linked(snapshot) {
+		return false
+	}
+	return snapInfo.Size() == origInfo.Size() && snapInfo.ModTime().Equal(origInfo.ModTime())
+}
+
+// originalPath returns the spool file a stable copy was taken from. Any other
+// path is returned unchanged.
+func originalPath(cfg *Config, path string) string {
+	if !cfg.StableCopy || !isUnder(path, stableRoot(cfg)) {
+		return path
+	}
+
+	rel, err := filepath.Rel(stableRoot(cfg), path)
+	if err != nil {
+		return path
+	}
+	// drop the job directory
+	_, rel, ok := strings.Cut(rel, string(filepath.Separator))
+	if !ok {
+		return path
+	}
+	return filepath.Join(cfg.FolderPrefix, rel)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newStableConfig returns a test configuration taking stable copies into a
+// temporary work directory on the filesystem of the spool.
+func newStableConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg := newTestConfig(t)
+	cfg.StableCopy = true
+	cfg.WorkDir = t.TempDir()
+	cfg.stableLinks = newStableLinks()
+	return cfg
+}
+
+// copySnapshot takes the stable copy of path the way snapshot falls back to
+// when the work directory is on another filesystem, and returns its path.
+func copySnapshot(t *testing.T, cfg *Config, path string) string {
+	t.Helper()
+	rel, err := filepath.Rel(cfg.FolderPrefix, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(stableJobDir(cfg, "job"), rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(path, target); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func TestStableCopyDeletesOriginal(t *testing.T) {
+	for name, take := range map[string]func(*testing.T, *Config, string) string{
+		"hardlink": func(t *testing.T, cfg *Config, path string) string {
+			stable, err := snapshot(cfg, "job", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameFile(t, stable, path) {
+				t.Fatal("the snapshot on the same filesystem is not a hardlink")
+			}
+			return stable
+		},
+		"copy": copySnapshot,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := newStableConfig(t)
+			original := writeSpoolFile(t, cfg, "a/1.log", "abc")
+			stable := take(t, cfg, original)
+
+			summary, err := parseAndCleanup(cfg, writeOutput(t, cpSuccess(stable, 3)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.FilesDeleted != 1 || summary.StableChanged != 0 {
+				t.Errorf("got %d deleted and %d changed, want 1 and 0", summary.FilesDeleted, summary.StableChanged)
+			}
+			assertGone(t, original)
+		})
+	}
+}
+
+func TestStableCopyKeepsChangedOriginal(t *testing.T) {
+	for name, change := range map[string]struct {
+		take   func(*testing.T, *Config, string) string
+		change func(*testing.T, string)
+	}{
+		"hardlink replaced": {
+			take: func(t *testing.T, cfg *Config, path string) string {
+				stable, err := snapshot(cfg, "job", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return stable
+			},
+			change: func(t *testing.T, path string) {
+				replacement := path + ".new"
+				if err := os.WriteFile(replacement, []byte("abc"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Rename(replacement, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		"copy appended to": {
+			take: copySnapshot,
+			change: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString("def")
+				f.Close()
+			},
+		},
+		"copy rewritten": {
+			take: copySnapshot,
+			change: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("xyz"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				later := time.Now().Add(time.Minute)
+				if err := os.Chtimes(path, later, later); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := newStableConfig(t)
+			original := writeSpoolFile(t, cfg, "a/1.log", "abc")
+			stable := change.take(t, cfg, original)
+			change.change(t, original)
+
+			summary, err := parseAndCleanup(cfg, writeOutput(t, cpSuccess(stable, 3)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.FilesDeleted != 0 || summary.StableChanged != 1 {
+				t.Errorf("got %d deleted and %d changed, want 0 and 1", summary.FilesDeleted, summary.StableChanged)
+			}
+			assertExists(t, original)
+		})
+	}
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(aInfo, bInfo)
+}
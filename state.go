@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileState is the bookkeeping kept for a single local file.
+type fileState struct {
+	UploadFailures int   `json:"upload_failures"`
+	DeleteFailures int   `json:"delete_failures,omitempty"` // runs in a row the file uploaded but failed to delete
+	ModTime        int64 `json:"mod_time,omitempty"`        // unix nanoseconds, tells a replaced file from a stuck or kept one
+	Kept           bool  `json:"kept,omitempty"`            // uploaded and kept locally because of keep-larger-than
+}
+
+// stateStore keeps per-file state across runs. It is persisted to a JSON file
+// when a path is configured and held in memory only otherwise.
+type stateStore struct {
+	mu    sync.Mutex
+	path  string
+	dirty bool
+	Files map[string]*fileState `json:"files"`
+
+	// last compaction, zero before the first one
+	compacted time.Time
+}
+
+// loadStateStore returns the state store persisted at path, or an empty one if
+// the file doesn't exist yet or path is empty.
+func loadStateStore(path string) (*stateStore, error) {
+	store := &stateStore{path: path, Files: make(map[string]*fileState)}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if store.Files == nil {
+		store.Files = make(map[string]*fileState)
+	}
+	return store, nil
+}
+
+// recordUploadFailure increments and returns the number of failed upload
+// attempts of path.
+func (s *stateStore) recordUploadFailure(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.Files[path]
+	if !ok {
+		state = &fileState{}
+		s.Files[path] = state
+	}
+	state.UploadFailures++
+	s.dirty = true
+	return state.UploadFailures
+}
+
+// recordDeleteFailure increments and returns the number of runs in which path,
+// last modified at modTime, was uploaded but could not be deleted. The count
+// starts over if the file at path was replaced.
+func (s *stateStore) recordDeleteFailure(path string, modTime time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.Files[path]
+	if !ok {
+		state = &fileState{}
+		s.Files[path] = state
+	}
+	if state.ModTime != modTime.UnixNano() {
+		state.DeleteFailures = 0
+		state.ModTime = modTime.UnixNano()
+	}
+	state.DeleteFailures++
+	s.dirty = true
+	return state.DeleteFailures
+}
+
+// recordKept marks path, last modified at modTime, as uploaded and kept
+// locally.
+func (s *stateStore) recordKept(path string, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.Files[path]
+	if !ok {
+		state = &fileState{}
+		s.Files[path] = state
+	}
+	state.Kept = true
+	state.ModTime = modTime.UnixNano()
+	s.dirty = true
+}
+
+// kept reports whether path, last modified at modTime, was uploaded and kept
+// locally. A file modified since is uploaded again.
+func (s *stateStore) kept(path string, modTime time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.Files[path]
+	return ok && state.Kept && state.ModTime == modTime.UnixNano()
+}
+
+// deleteFailures returns the number of runs in a row in which path, last
+// modified at modTime, was uploaded but could not be deleted.
+func (s *stateStore) deleteFailures(path string, modTime time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.Files[path]; ok && state.ModTime == modTime.UnixNano() {
+		return state.DeleteFailures
+	}
+	return 0
+}
+
+// forget drops all state kept for path.
+func (s *stateStore) forget(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Files[path]; ok {
+		delete(s.Files, path)
+		s.dirty = true
+	}
+}
+
+// compact drops the state of files that no longer exist, e.g. files removed
+// by others after a failed upload, if the last compaction is at least interval
+// ago. It returns the number of entries dropped.
+func (s *stateStore) compact(interval time.Duration) int {
+	s.mu.Lock()
+	if time.Since(s.compacted) < interval {
+		s.mu.Unlock()
+		return 0
+	}
+	s.compacted = time.Now()
+	paths := make([]string, 0, len(s.Files))
+	for path := range s.Files {
+		paths = append(paths, path)
+	}
+	s.mu.Unlock()
+
+	// Checked without holding the lock, the async deleter may need it
+	var gone []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+			gone = append(gone, path)
+		}
+	}
+
+	for _, path := range gone {
+		s.forget(path)
+	}
+	return len(gone)
+}
+
+// save persists the store if it changed since it was last saved. The file is
+// replaced atomically so a crash never leaves a truncated state file behind.
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// freeInodes returns the number of free and total inodes of the filesystem
+// holding path.
+func freeInodes(path string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Ffree, stat.Files, nil
+}
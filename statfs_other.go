@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// freeInodes is only implemented on Linux.
+func freeInodes(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("inode statistics are not supported on this platform")
+}
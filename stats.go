@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// statRecord is a line of the statistics s5cmd prints at exit with --stat, e.g.
+// {"operation":"cp","success":10,"error":1}.
+type statRecord struct {
+	Operation string `json:"operation"`
+	Success   *int64 `json:"success"`
+	Error     *int64 `json:"error"`
+}
+
+// decodeStat decodes line as a statistics record. Per-file records carry a
+// boolean success and a string error, so they never decode as one.
+func decodeStat(line []byte) (statRecord, bool) {
+	var stat statRecord
+	if err := json.Unmarshal(line, &stat); err != nil || stat.Success == nil || stat.Error == nil {
+		return statRecord{}, false
+	}
+	return stat, true
+}
+
+// s5cmdStats are the cp totals reported by s5cmd itself.
+type s5cmdStats struct {
+	Seen    bool
+	Success int64
+	Error   int64
+}
+
+func (s *s5cmdStats) add(stat statRecord) {
+	if stat.Operation != "cp" {
+		return
+	}
+	s.Seen = true
+	s.Success += *stat.Success
+	s.Error += *stat.Error
+}
+
+// crossCheckStats compares the totals s5cmd reported with the counts parsed from
+// its per-file records and records the difference in the summary. A difference
+// means records were lost or the output format drifted.
+func crossCheckStats(stats s5cmdStats, summary *Summary, outputFiles []string) {
+	if !stats.Seen {
+		log.Printf("Warning: no s5cmd statistics found in %v to cross-check", outputFiles)
+		return
+	}
+
+	// Files skipped by --no-clobber are reported as warnings, not as errors
+	parsedSuccess := int64(summary.FilesTransferred)
+	parsedError := int64(len(summary.UploadsFailed))
+	discrepancy := abs(stats.Success-parsedSuccess) + abs(stats.Error-parsedError)
+	if discrepancy == 0 {
+		return
+	}
+	log.Printf("Warning: s5cmd reported %d successful and %d failed copies, but %d and %d were parsed from its output in %v",
+		stats.Success, stats.Error, parsedSuccess, parsedError, outputFiles)
+	summary.CountDiscrepancy += int(discrepancy)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// checkBytesShortfall compares the bytes of the files enumerated for a job with
+// the bytes s5cmd reported transferring and records the shortfall in the
+// summary, warning when it exceeds cfg.ShortfallPercent of the planned bytes.
+// Jobs with failed, skipped or vanished uploads are not checked, their gap is
+// accounted for already.
+func checkBytesShortfall(cfg *Config, jobID string, summary *Summary) {
+	if summary.BytesPlanned == 0 || len(summary.UploadsFailed) > 0 || summary.SkippedExisting > 0 || summary.SourceVanished > 0 {
+		return
+	}
+	shortfall := summary.BytesPlanned - summary.TotalBytes
+	if shortfall <= 0 {
+		return
+	}
+	summary.BytesShortfall += shortfall
+	if shortfall*100 > summary.BytesPlanned*int64(cfg.ShortfallPercent) {
+		log.Printf("Warning: job %s transferred %d bytes, %d (%.1f%%) less than the %d bytes of the files it enumerated",
+			jobID, summary.TotalBytes, shortfall, float64(shortfall)*100/float64(summary.BytesPlanned), summary.BytesPlanned)
+	}
+}
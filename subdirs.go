@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listSubdirs returns the names of the top-level directories under folderPrefix.
+func listSubdirs(folderPrefix string) ([]string, error) {
+	entries, err := os.ReadDir(folderPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %w", folderPrefix, err)
+	}
+
+	var subdirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subdirs = append(subdirs, entry.Name())
+		}
+	}
+	return subdirs, nil
+}
+
+// partitionOf returns the partition of path, the top-level directory under
+// folderPrefix it lies in, or "" for files directly inside folderPrefix.
+func partitionOf(folderPrefix, path string) string {
+	rel, err := filepath.Rel(filepath.Clean(folderPrefix), filepath.Clean(path))
+	if err != nil || !isUnder(path, folderPrefix) {
+		return ""
+	}
+	partition, _, found := strings.Cut(filepath.ToSlash(rel), "/")
+	if !found {
+		return ""
+	}
+	return partition
+}
+
+// subdirPattern rewrites pathSuffix so that its first path segment matches only
+// subdir. The first segment must consist of wildcards only, otherwise the
+// pattern can't be split by subdirectory without changing what it matches.
+func subdirPattern(pathSuffix, subdir string) (string, error) {
+	segments := strings.Split(strings.TrimPrefix(pathSuffix, "/"), "/")
+	if len(segments) < 2 || strings.Trim(segments[0], "*") != "" {
+		return "", fmt.Errorf("path suffix must start with a wildcard directory segment (e.g. /**/...)")
+	}
+	segments[0] = subdir
+	return strings.Join(segments, "/"), nil
+}
+
+// subdirDestination returns the destination prefix for files of subdir, so that
+// objects keep the key layout of a single run over the whole folder prefix.
+func subdirDestination(s3BucketPath, subdir string) string {
+	return strings.TrimSuffix(s3BucketPath, "/") + "/" + subdir + "/"
+}
+
+// processSubdirs runs one s5cmd per top-level subdirectory of the folder prefix,
+// at most cfg.SubdirConcurrency at a time, and merges their summaries. With a
+// concurrency ramp, the run starts with a single s5cmd and adds more as they
+// succeed. Once ctx is cancelled during the pause between them, no further
+// s5cmd is started and the run completes with those already running.
+func processSubdirs(ctx context.Context, cfg *Config, jobID string) (Summary, error) {
+	subdirs, err := listSubdirs(cfg.FolderPrefix)
+	if err != nil {
+		return Summary{}, err
+	}
+	if cfg.shuffler != nil {
+		cfg.shuffler.shuffleStrings(subdirs)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		summary Summary
+		errs    []error
+	)
+	sem := make(chan struct{}, cfg.SubdirConcurrency)
+	var ramp *rampLimiter
+	if cfg.ConcurrencyRamp {
+		ramp = newRampLimiter(cfg.SubdirConcurrency)
+	}
+	var pacer *batchPacer
+	if cfg.InterBatchDelay > 0 {
+		pacer = newBatchPacer(cfg.InterBatchDelay, cfg.InterBatchMaxDelay)
+	}
+
+launch:
+	for i, subdir := range subdirs {
+		pattern, err := subdirPattern(cfg.PathSuffix, subdir)
+		if err != nil {
+			return Summary{}, err
+		}
+
+		if pacer != nil && i > 0 {
+			select {
+			case <-time.After(pacer.current()):
+			case <-ctx.Done():
+				log.Printf("Shutting down, leaving %d subdirectories for the next run", len(subdirs)-i)
+				break launch
+			}
+		}
+		wg.Add(1)
+		if ramp != nil {
+			ramp.acquire()
+		} else {
+			sem <- struct{}{}
+		}
+		go func(subJobID, srcPath, destPath string) {
+			defer wg.Done()
+
+			subSummary, err := runJob(ctx, cfg, subJobID, srcPath, destPath)
+			if pacer != nil {
+				pacer.observe(err)
+			}
+			if ramp != nil {
+				ramp.release(err == nil && len(subSummary.UploadsFailed) == 0)
+			} else {
+				<-sem
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			summary.merge(subSummary)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}(fmt.Sprintf("%s-%d", jobID, i), sourcePattern(cfg.FolderPrefix, pattern), subdirDestination(cfg.S3BucketPath, subdir))
+	}
+	wg.Wait()
+	if ramp != nil {
+		summary.EffectiveConcurrency = ramp.current()
+	}
+	if pacer != nil {
+		summary.InterBatchDelay = pacer.current()
+	}
+
+	if len(errs) > 0 {
+		return summary, &runError{
+			class: classOf(errs[0]),
+			err:   fmt.Errorf("%d of %d subdirectory runs failed, first error: %w", len(errs), len(subdirs), errs[0]),
+		}
+	}
+	return summary, nil
+}
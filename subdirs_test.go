@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessSubdirsStopsPacingOnShutdown(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.PathSuffix = "*/*"
+	cfg.SplitBySubdir = true
+	cfg.SubdirConcurrency = 1
+	cfg.InterBatchDelay = time.Hour
+	writeSpoolFile(t, cfg, "a/1.log", "abc")
+	writeSpoolFile(t, cfg, "b/1.log", "abc")
+	started := filepath.Join(t.TempDir(), "started")
+	cfg.S5cmdBinary = fakeS5cmd(t, "echo run >> "+started+"\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	begin := time.Now()
+	if _, err := processSubdirs(ctx, cfg, "job"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > 10*time.Second {
+		t.Errorf("the run took %v, the shutdown didn't end the pause", elapsed)
+	}
+	data, err := os.ReadFile(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 1 {
+		t.Errorf("got %d s5cmd runs, want 1", runs)
+	}
+}
+
+func TestListSubdirs(t *testing.T) {
+	cfg := newTestConfig(t)
+	writeSpoolFile(t, cfg, "a/1.log", "abc")
+	writeSpoolFile(t, cfg, "b/c/1.log", "abc")
+	writeSpoolFile(t, cfg, "top.log", "abc")
+
+	subdirs, err := listSubdirs(cfg.FolderPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(subdirs, ",") != "a,b" {
+		t.Errorf("got %v, want the top-level directories [a b]", subdirs)
+	}
+}
+
+func TestSubdirPattern(t *testing.T) {
+	for _, tc := range []struct {
+		suffix, subdir, want string
+		fails                bool
+	}{
+		{"/*/*.log", "a", "a/*.log", false},
+		{"**/x/*.gz", "b", "b/x/*.gz", false},
+		{"/logs/*.log", "a", "", true},
+		{"*.log", "a", "", true},
+	} {
+		got, err := subdirPattern(tc.suffix, tc.subdir)
+		if (err != nil) != tc.fails || got != tc.want {
+			t.Errorf("subdirPattern(%q, %q) = %q, %v; want %q, failure %v", tc.suffix, tc.subdir, got, err, tc.want, tc.fails)
+		}
+	}
+}
+
+func TestSubdirDestination(t *testing.T) {
+	for _, base := range []string{"s3://bucket/prefix", "s3://bucket/prefix/"} {
+		if got := subdirDestination(base, "a"); got != "s3://bucket/prefix/a/" {
+			t.Errorf("subdirDestination(%q, a) = %q, want s3://bucket/prefix/a/", base, got)
+		}
+	}
+}
+
+func TestProcessSubdirsMergesSummaries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := newTestConfig(t)
+	cfg.PathSuffix = "*/*"
+	cfg.SplitBySubdir = true
+	cfg.SubdirConcurrency = 2
+	cfg.S5cmdBinary = uploadingS5cmd(t)
+	files := []string{
+		writeSpoolFile(t, cfg, "a/1.log", "abc"),
+		writeSpoolFile(t, cfg, "a/2.log", "abc"),
+		writeSpoolFile(t, cfg, "b/1.log", "abc"),
+	}
+
+	summary, err := processSubdirs(context.Background(), cfg, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.FilesTransferred != 3 || summary.FilesDeleted != 3 || summary.TotalBytes != 9 {
+		t.Errorf("got %d transferred, %d deleted, %d bytes, want 3, 3, 9",
+			summary.FilesTransferred, summary.FilesDeleted, summary.TotalBytes)
+	}
+	if len(summary.JobIDs) != 2 {
+		t.Errorf("got jobs %v, want one per subdirectory", summary.JobIDs)
+	}
+	for _, file := range files {
+		assertGone(t, file)
+	}
+}
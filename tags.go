@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// S3 object tag limits, see
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-tagging.html
+const (
+	maxObjectTags        = 10
+	maxObjectTagKeyLen   = 128
+	maxObjectTagValueLen = 256
+)
+
+// objectTagChars matches the characters S3 accepts in tag keys and values.
+var objectTagChars = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// objectTag is a single key=value object tag.
+type objectTag struct {
+	key   string
+	value string
+}
+
+// parseObjectTags parses comma-separated key=value tags and checks them against
+// the S3 limits, since a single invalid tag fails every upload of a run.
+func parseObjectTags(spec string) ([]objectTag, error) {
+	var tags []objectTag
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		if key == "" || utf8.RuneCountInString(key) > maxObjectTagKeyLen {
+			return nil, fmt.Errorf("tag key %q must be 1 to %d characters long", key, maxObjectTagKeyLen)
+		}
+		if utf8.RuneCountInString(value) > maxObjectTagValueLen {
+			return nil, fmt.Errorf("value of tag %q must be at most %d characters long", key, maxObjectTagValueLen)
+		}
+		if !objectTagChars.MatchString(key) || !objectTagChars.MatchString(value) {
+			return nil, fmt.Errorf("tag %q may only contain letters, numbers, spaces and _ . : / = + - @", pair)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return nil, fmt.Errorf("tag key %q uses the reserved aws: prefix", key)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate tag key %q", key)
+		}
+		seen[key] = true
+		tags = append(tags, objectTag{key: key, value: value})
+	}
+	if len(tags) > maxObjectTags {
+		return nil, fmt.Errorf("at most %d tags are allowed per object, got %d", maxObjectTags, len(tags))
+	}
+	return tags, nil
+}
+
+// taggingArg encodes tags as the URL query string S3 expects, keeping their
+// configured order.
+func taggingArg(tags []objectTag) string {
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = url.QueryEscape(tag.key) + "=" + url.QueryEscape(tag.value)
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseObjectTags(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		want  []objectTag
+		fails bool
+	}{
+		{"", nil, false},
+		{"team=data, env=prod ,", []objectTag{{"team", "data"}, {"env", "prod"}}, false},
+		{"empty=", []objectTag{{"empty", ""}}, false},
+		{"path=a/b:c+d@e", []objectTag{{"path", "a/b:c+d@e"}}, false},
+		{"team", nil, true},
+		{"=data", nil, true},
+		{strings.Repeat("k", 129) + "=v", nil, true},
+		{"k=" + strings.Repeat("v", 257), nil, true},
+		{"team=data&more", nil, true},
+		{"AWS:owner=me", nil, true},
+		{"team=a,team=b", nil, true},
+		{"a=1,b=1,c=1,d=1,e=1,f=1,g=1,h=1,i=1,j=1,k=1", nil, true},
+	} {
+		got, err := parseObjectTags(tc.spec)
+		if (err != nil) != tc.fails || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseObjectTags(%q) = %v, %v; want %v, failure %v", tc.spec, got, err, tc.want, tc.fails)
+		}
+	}
+}
+
+func TestCpOptionsTagging(t *testing.T) {
+	tags, err := parseObjectTags("team=data science,path=a/b,sum=1+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{objectTags: tags}
+
+	want := []string{"--tagging", "team=data+science&path=a%2Fb&sum=1%2B1"}
+	if got := cpOptions(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := cpOptions(&Config{}); len(got) != 0 {
+		t.Errorf("got %q without tags, want no options", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// percentiles reported over the throughput window
+var windowQuantiles = []float64{0.5, 0.9, 0.99}
+
+// runPercentiles holds the p50, p90 and p99 of the runs in the window.
+type runPercentiles struct {
+	Throughput [3]float64 // bytes per second spent in s5cmd
+	Duration   [3]float64 // seconds of s5cmd, parsing and deleting
+	Degraded   bool       // the run's throughput was below the baseline of the window before it
+}
+
+// sampleRing keeps the last len(samples) values added to it.
+type sampleRing struct {
+	samples []float64
+	next    int
+	full    bool
+}
+
+func newSampleRing(size int) *sampleRing {
+	return &sampleRing{samples: make([]float64, size)}
+}
+
+func (r *sampleRing) add(v float64) {
+	r.samples[r.next] = v
+	r.next = (r.next + 1) % len(r.samples)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *sampleRing) len() int {
+	if r.full {
+		return len(r.samples)
+	}
+	return r.next
+}
+
+// quantile returns the q-quantile of the samples using the nearest-rank method.
+func (r *sampleRing) quantile(q float64) float64 {
+	n := r.len()
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), r.samples[:n]...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(q*float64(n))) - 1
+	return sorted[max(rank, 0)]
+}
+
+// throughputWindow tracks throughput and duration of the last runs that
+// transferred files. Idle runs are left out, they would drag every percentile
+// towards zero. Once the window is full, a run whose throughput falls below
+// degradedFraction of the median of the window is flagged as degraded.
+type throughputWindow struct {
+	throughput       *sampleRing
+	duration         *sampleRing
+	degradedFraction float64
+}
+
+func newThroughputWindow(size int, degradedFraction float64) *throughputWindow {
+	return &throughputWindow{
+		throughput:       newSampleRing(size),
+		duration:         newSampleRing(size),
+		degradedFraction: degradedFraction,
+	}
+}
+
+// observe adds the run to the window and returns the percentiles of the window,
+// or nil if it holds no runs yet.
+func (w *throughputWindow) observe(summary *Summary) *runPercentiles {
+	var p runPercentiles
+	if summary.FilesTransferred > 0 && summary.ExecDuration > 0 {
+		throughput := float64(summary.TotalBytes) / summary.ExecDuration.Seconds()
+		if w.degradedFraction > 0 && w.throughput.full {
+			baseline := w.throughput.quantile(0.5)
+			if throughput < baseline*w.degradedFraction {
+				log.Printf("Warning: throughput of %.2f MB/s is below %.0f%% of the recent median of %.2f MB/s",
+					throughput/(1024*1024), w.degradedFraction*100, baseline/(1024*1024))
+				p.Degraded = true
+			}
+		}
+		w.throughput.add(throughput)
+		w.duration.add((summary.ExecDuration + summary.ParseDuration).Seconds())
+	}
+	if w.throughput.len() == 0 {
+		return nil
+	}
+
+	for i, q := range windowQuantiles {
+		p.Throughput[i] = w.throughput.quantile(q)
+		p.Duration[i] = w.duration.quantile(q)
+	}
+	return &p
+}
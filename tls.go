@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// checkTLSFiles makes sure the CA bundle and the client key pair can be read
+// and parsed, so that a broken mutual TLS setup fails at startup rather than in
+// every run.
+func checkTLSFiles(cfg *Config) error {
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return errors.New("client-cert and client-key (or CLIENT_CERT and CLIENT_KEY env vars) must be set together")
+	}
+	if cfg.ClientCert != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey); err != nil {
+			return fmt.Errorf("error loading client certificate %s and key %s: %w", cfg.ClientCert, cfg.ClientKey, err)
+		}
+	}
+
+	if cfg.CACert != "" {
+		data, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return fmt.Errorf("error reading CA certificate: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM certificates found in CA certificate %s", cfg.CACert)
+		}
+	}
+	return nil
+}
+
+// tlsEnv returns the environment variables passing the TLS settings to s5cmd,
+// which leaves them to the AWS SDK.
+func tlsEnv(cfg *Config) []string {
+	var env []string
+	if cfg.CACert != "" {
+		env = append(env, "AWS_CA_BUNDLE="+cfg.CACert)
+	}
+	if cfg.ClientCert != "" {
+		env = append(env,
+			"AWS_SDK_GO_CLIENT_TLS_CERT="+cfg.ClientCert,
+			"AWS_SDK_GO_CLIENT_TLS_KEY="+cfg.ClientKey,
+		)
+	}
+	return env
+}
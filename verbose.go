@@ -0,0 +1,17 @@
+package main
+
+import "strings"
+
+// redactedCommand renders the argv of an s5cmd invocation for logging.
+// Credentials are passed in the environment or the credentials file and never
+// appear in argv, except as userinfo of the endpoint URL, which is redacted.
+func redactedCommand(args []string) string {
+	rendered := make([]string, len(args))
+	for i, arg := range args {
+		if i > 0 && args[i-1] == "--endpoint-url" {
+			arg = redactURL(arg)
+		}
+		rendered[i] = quoteArg(arg)
+	}
+	return strings.Join(rendered, " ")
+}